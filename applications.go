@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The steps an application goes through
+const APPLICATION_SAVED = "saved"
+const APPLICATION_APPLIED = "applied"
+const APPLICATION_INTERVIEWING = "interviewing"
+const APPLICATION_OFFER = "offer"
+const APPLICATION_REJECTED = "rejected"
+
+var applicationStatuses = []string{APPLICATION_SAVED, APPLICATION_APPLIED, APPLICATION_INTERVIEWING, APPLICATION_OFFER, APPLICATION_REJECTED}
+
+var errApplicationAlreadyExists = errors.New("this offer is already tracked")
+var errOfferNotFound = errors.New("this offer does not exist")
+
+// This variable stores a status an application went through
+type ApplicationStatusChange struct {
+	Status    string
+	ChangedAt time.Time
+}
+
+// This variable stores the application of a user to an offer
+type Application struct {
+	ID     int
+	UserID int
+	// The offer is set to null when it is removed from the board, the company, title and url copied when the application was created keep the record readable
+	OfferID     *int
+	CompanyName string
+	// The slug of the company page, empty when the company is not on the board
+	CompanySlug string
+	OfferTitle  string
+	OfferURL    string
+	Status      string `binding:"omitempty,oneof=saved applied interviewing offer rejected"`
+	Notes       string
+	AppliedAt   *time.Time
+	// A reminder is sent by email once this date has passed
+	FollowUpAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	History    []ApplicationStatusChange `json:",omitempty"`
+}
+
+// The columns read when scanning an application with scanApplication
+const APPLICATION_COLUMNS = `id, user_id, offer_id, company_name,
+	coalesce((SELECT companies.slug FROM companies WHERE companies.name = applications.company_name), ''), offer_title, offer_url, status, notes, applied_at, follow_up_at, created_at, updated_at`
+
+// This function scans a row selected with the APPLICATION_COLUMNS columns
+func scanApplication(row pgx.Row) (Application, error) {
+	var application Application
+	err := row.Scan(&application.ID, &application.UserID, &application.OfferID, &application.CompanyName, &application.CompanySlug, &application.OfferTitle, &application.OfferURL,
+		&application.Status, &application.Notes, &application.AppliedAt, &application.FollowUpAt, &application.CreatedAt, &application.UpdatedAt)
+	return application, err
+}
+
+// This function returns whether a follow up reminder of an application is due
+func (a Application) FollowUpDue() bool {
+	return a.FollowUpAt != nil && !a.FollowUpAt.After(time.Now())
+}
+
+// This function creates an application and its first status change. When it is linked to an offer, the offer company, title and url are copied in it
+func createApplication(ctx context.Context, db *pgxpool.Pool, application Application) (Application, error) {
+	if application.Status == "" {
+		application.Status = APPLICATION_SAVED
+	}
+	if application.Status == APPLICATION_APPLIED && application.AppliedAt == nil {
+		now := time.Now()
+		application.AppliedAt = &now
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return application, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if application.OfferID != nil {
+		query := `SELECT coalesce(company_name, ''), title, offer_url FROM offers WHERE id = @id`
+		args := pgx.NamedArgs{
+			"id": *application.OfferID,
+		}
+		err = tx.QueryRow(ctx, query, args).Scan(&application.CompanyName, &application.OfferTitle, &application.OfferURL)
+		switch {
+		case err == pgx.ErrNoRows:
+			return application, errOfferNotFound
+		case err != nil:
+			return application, fmt.Errorf("unable to query row: %w", err)
+		}
+	}
+	if application.CompanyName == "" {
+		return application, fmt.Errorf("the company name is required when the application is not linked to an offer")
+	}
+
+	query := `INSERT INTO applications (user_id, offer_id, company_name, offer_title, offer_url, status, notes, applied_at, follow_up_at)
+		VALUES (@user_id, @offer_id, @company_name, @offer_title, @offer_url, @status, @notes, @applied_at, @follow_up_at)
+		RETURNING ` + APPLICATION_COLUMNS
+	args := pgx.NamedArgs{
+		"user_id":      application.UserID,
+		"offer_id":     application.OfferID,
+		"company_name": application.CompanyName,
+		"offer_title":  application.OfferTitle,
+		"offer_url":    application.OfferURL,
+		"status":       application.Status,
+		"notes":        application.Notes,
+		"applied_at":   application.AppliedAt,
+		"follow_up_at": application.FollowUpAt,
+	}
+	application, err = scanApplication(tx.QueryRow(ctx, query, args))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return application, errApplicationAlreadyExists
+		}
+		return application, fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	err = addApplicationStatusChange(ctx, tx, application.ID, application.Status)
+	if err != nil {
+		return application, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return application, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return application, err
+}
+
+// This function updates the status, notes and dates of an application of a user, and records the status change in its history.
+// It returns false if the application does not exist or belongs to another user.
+func updateApplication(ctx context.Context, db *pgxpool.Pool, application Application) (Application, bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return application, false, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var previousStatus string
+	query := `SELECT status FROM applications WHERE id = @id AND user_id = @user_id FOR UPDATE`
+	args := pgx.NamedArgs{
+		"id":      application.ID,
+		"user_id": application.UserID,
+	}
+	err = tx.QueryRow(ctx, query, args).Scan(&previousStatus)
+	switch {
+	case err == pgx.ErrNoRows:
+		return application, false, nil
+	case err != nil:
+		return application, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	// An empty status keeps the current one
+	if application.Status == "" {
+		application.Status = previousStatus
+	}
+	if application.Status == APPLICATION_APPLIED && previousStatus != APPLICATION_APPLIED && application.AppliedAt == nil {
+		now := time.Now()
+		application.AppliedAt = &now
+	}
+
+	// Changing the follow up date schedules a new reminder
+	query = `UPDATE applications SET status = @status, notes = @notes, applied_at = @applied_at, follow_up_at = @follow_up_at,
+		reminder_sent_at = CASE WHEN follow_up_at IS DISTINCT FROM @follow_up_at::timestamptz THEN NULL ELSE reminder_sent_at END,
+		updated_at = now()
+		WHERE id = @id RETURNING ` + APPLICATION_COLUMNS
+	args = pgx.NamedArgs{
+		"id":           application.ID,
+		"status":       application.Status,
+		"notes":        application.Notes,
+		"applied_at":   application.AppliedAt,
+		"follow_up_at": application.FollowUpAt,
+	}
+	application, err = scanApplication(tx.QueryRow(ctx, query, args))
+	if err != nil {
+		return application, false, fmt.Errorf("unable to update row: %w", err)
+	}
+
+	if application.Status != previousStatus {
+		err = addApplicationStatusChange(ctx, tx, application.ID, application.Status)
+		if err != nil {
+			return application, false, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return application, false, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return application, true, nil
+}
+
+func addApplicationStatusChange(ctx context.Context, tx pgx.Tx, applicationID int, status string) error {
+	query := `INSERT INTO application_status_changes (application_id, status) VALUES (@application_id, @status)`
+	args := pgx.NamedArgs{
+		"application_id": applicationID,
+		"status":         status,
+	}
+	_, err := tx.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return err
+}
+
+// This function returns an application of a user with its status history, or false if it does not exist or belongs to another user
+func getApplication(ctx context.Context, db *pgxpool.Pool, userID int, id int) (Application, bool, error) {
+	query := `SELECT ` + APPLICATION_COLUMNS + ` FROM applications WHERE id = @id AND user_id = @user_id`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": userID,
+	}
+	application, err := scanApplication(db.QueryRow(ctx, query, args))
+	switch {
+	case err == pgx.ErrNoRows:
+		return application, false, nil
+	case err != nil:
+		return application, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	application.History, err = getApplicationHistory(ctx, db, id)
+	if err != nil {
+		return application, false, err
+	}
+
+	return application, true, nil
+}
+
+func getApplicationHistory(ctx context.Context, db *pgxpool.Pool, applicationID int) ([]ApplicationStatusChange, error) {
+	var history []ApplicationStatusChange
+
+	query := `SELECT status, changed_at FROM application_status_changes WHERE application_id = @application_id ORDER BY changed_at, id`
+	args := pgx.NamedArgs{
+		"application_id": applicationID,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return history, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var change ApplicationStatusChange
+		err = rows.Scan(&change.Status, &change.ChangedAt)
+		if err != nil {
+			return history, fmt.Errorf("unable to scan row: %w", err)
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// This function returns the applications of a user with their history, filtered by status when it is not empty, the last updated first
+func getApplications(ctx context.Context, db *pgxpool.Pool, userID int, status string) ([]Application, error) {
+	var applications []Application
+
+	query := `SELECT ` + APPLICATION_COLUMNS + ` FROM applications WHERE user_id = @user_id AND (@status = '' OR status = @status) ORDER BY updated_at DESC, id DESC`
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"status":  status,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return applications, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		application, err := scanApplication(rows)
+		if err != nil {
+			return applications, fmt.Errorf("unable to scan row: %w", err)
+		}
+		applications = append(applications, application)
+	}
+	if rows.Err() != nil {
+		return applications, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	for i := range applications {
+		applications[i].History, err = getApplicationHistory(ctx, db, applications[i].ID)
+		if err != nil {
+			return applications, err
+		}
+	}
+
+	return applications, nil
+}
+
+func deleteApplication(ctx context.Context, db *pgxpool.Pool, userID int, id int) (bool, error) {
+	query := `DELETE FROM applications WHERE id = @id AND user_id = @user_id`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": userID,
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, err
+}
+
+// This function returns the applications of a user whose follow up date has passed
+func getDueFollowUps(ctx context.Context, db *pgxpool.Pool, userID int, now time.Time) ([]Application, error) {
+	var applications []Application
+
+	query := `SELECT ` + APPLICATION_COLUMNS + ` FROM applications WHERE user_id = @user_id AND follow_up_at <= @now ORDER BY follow_up_at`
+	args := pgx.NamedArgs{
+		"user_id": userID,
+		"now":     now,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return applications, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		application, err := scanApplication(rows)
+		if err != nil {
+			return applications, fmt.Errorf("unable to scan row: %w", err)
+		}
+		applications = append(applications, application)
+	}
+
+	return applications, rows.Err()
+}
+
+// This function returns the users email with their applications whose follow up date has passed and whose reminder has not been sent yet
+func getUnsentFollowUpReminders(ctx context.Context, db *pgxpool.Pool, now time.Time) (map[string][]Application, error) {
+	reminders := make(map[string][]Application)
+
+	query := `SELECT u.email, a.id, a.user_id, a.offer_id, a.company_name, a.offer_title, a.offer_url, a.status, a.notes, a.applied_at, a.follow_up_at, a.created_at, a.updated_at
+		FROM applications a JOIN users u ON u.id = a.user_id
+		WHERE a.follow_up_at <= @now AND a.reminder_sent_at IS NULL
+		ORDER BY a.follow_up_at`
+	args := pgx.NamedArgs{
+		"now": now,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return reminders, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		var a Application
+		err = rows.Scan(&email, &a.ID, &a.UserID, &a.OfferID, &a.CompanyName, &a.OfferTitle, &a.OfferURL,
+			&a.Status, &a.Notes, &a.AppliedAt, &a.FollowUpAt, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return reminders, fmt.Errorf("unable to scan row: %w", err)
+		}
+		reminders[email] = append(reminders[email], a)
+	}
+
+	return reminders, rows.Err()
+}
+
+// This function records that the follow up reminder of applications has been sent
+func markFollowUpRemindersSent(ctx context.Context, db *pgxpool.Pool, ids []int, now time.Time) error {
+	query := `UPDATE applications SET reminder_sent_at = @now WHERE id = ANY(@ids)`
+	args := pgx.NamedArgs{
+		"ids": ids,
+		"now": now,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update rows: %w", err)
+	}
+
+	return err
+}
+
+// This function reads the application id of the request path, and answers with an error if it is not valid or the application does not exist for the current user
+func getApplicationFromPath(c *gin.Context) (Application, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return Application{}, false
+	}
+
+	application, exists, err := getApplication(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return application, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return application, false
+	}
+
+	return application, true
+}
+
+func getApplicationsAPI(c *gin.Context) {
+	applications, err := getApplications(c.Request.Context(), dbpoolapi, currentUserID(c), c.Query("status"))
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": applications})
+}
+
+func getApplicationAPI(c *gin.Context) {
+	application, ok := getApplicationFromPath(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": application})
+}
+
+func createApplicationAPI(c *gin.Context) {
+	var application Application
+	if err := c.ShouldBindJSON(&application); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	application.UserID = currentUserID(c)
+
+	application, err := createApplication(c.Request.Context(), dbpoolapi, application)
+	switch {
+	case err == errOfferNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err == errApplicationAlreadyExists:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		slog.Error("An error happened while creating the application", LOG_ERROR, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": application})
+}
+
+func updateApplicationAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	var application Application
+	if err := c.ShouldBindJSON(&application); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	application.ID = id
+	application.UserID = currentUserID(c)
+
+	application, exists, err := updateApplication(c.Request.Context(), dbpoolapi, application)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": application})
+}
+
+func deleteApplicationAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	exists, err := deleteApplication(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// This function returns the applications of the current user that should be followed up now
+func getDueFollowUpsAPI(c *gin.Context) {
+	applications, err := getDueFollowUps(c.Request.Context(), dbpoolapi, currentUserID(c), time.Now())
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": applications})
+}
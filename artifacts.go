@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The outcomes of the page an artifact was captured on
+const ARTIFACT_FAILURE = "failure"
+const ARTIFACT_SUCCESS = "success"
+
+// The time given to the capture of the artifacts of a page
+const ARTIFACT_CAPTURE_TIMEOUT = 20 * time.Second
+
+// The quality of the jpeg screenshots of the pages
+const ARTIFACT_SCREENSHOT_QUALITY = 60
+
+// The size above which the HTML of a page is truncated
+const ARTIFACT_MAX_HTML_SIZE = 2 << 20
+
+// The console errors a tab keeps for the page it shows, the first ones being the most useful
+const ARTIFACT_MAX_CONSOLE_ERRORS = 50
+
+// The number of artifacts listed by run
+const ARTIFACTS_LIST_LIMIT = 500
+
+// The interval at which a worker deletes the expired artifacts, a worker running for days
+const ARTIFACTS_CLEANUP_INTERVAL = time.Hour
+
+// The contents of an artifact served apart, by their column
+const ARTIFACT_SCREENSHOT = "screenshot"
+const ARTIFACT_HTML = "html"
+
+// This variable stores the artifacts of a page: a screenshot, its rendered HTML and the errors of its console, captured when the crawler
+// failed to read it so the page can be looked at afterwards
+type CrawlArtifact struct {
+	ID            int
+	RunID         string
+	CompanyName   string
+	Page          string
+	URL           string
+	Outcome       string
+	Reason        string
+	ConsoleErrors []string
+	CreatedAt     time.Time
+	ScreenshotURL string
+	HTMLURL       string
+}
+
+// This variable stores the artifacts captured in a run, a crawl or a task of a worker
+type artifactsRecorder struct {
+	db       *pgxpool.Pool
+	runID    string
+	captured atomic.Int32
+}
+
+type artifactsRecorderKey struct{}
+type artifactsCompanyKey struct{}
+
+// This function returns a context whose pages are captured as artifacts of the run given, up to artifacts.max_per_run pages
+// from this context. A worker calls it for each task, its tasks being recorded under its id.
+func withArtifacts(ctx context.Context, db *pgxpool.Pool, runID string) context.Context {
+	return context.WithValue(ctx, artifactsRecorderKey{}, &artifactsRecorder{db: db, runID: runID})
+}
+
+// This function returns a context whose artifacts are recorded for a company
+func withArtifactsCompany(ctx context.Context, companyName string) context.Context {
+	return context.WithValue(ctx, artifactsCompanyKey{}, companyName)
+}
+
+// This function captures the artifacts of the page shown in the tab of the context, on a failure with artifacts.on_failure and on a success
+// with artifacts.on_success, up to artifacts.max_per_run by run. An error while capturing them is only logged, the crawl goes on.
+func captureArtifacts(ctx context.Context, page string, pageURL string, outcome string, reason string) {
+	recorder, ok := ctx.Value(artifactsRecorderKey{}).(*artifactsRecorder)
+	if !ok || ctx.Err() != nil {
+		return
+	}
+	if outcome == ARTIFACT_FAILURE && !appConfig.Artifacts.OnFailure || outcome == ARTIFACT_SUCCESS && !appConfig.Artifacts.OnSuccess {
+		return
+	}
+	tab, ok := ctx.Value(pooledTabKey{}).(*pooledTab)
+	if !ok {
+		return
+	}
+	if recorder.captured.Add(1) > int32(appConfig.Artifacts.MaxPerRun) {
+		return
+	}
+	logger := loggerFromContext(ctx).With(LOG_URL, pageURL)
+
+	ctx, cancel := context.WithTimeout(ctx, ARTIFACT_CAPTURE_TIMEOUT)
+	defer cancel()
+
+	artifact := CrawlArtifact{
+		RunID:         recorder.runID,
+		Page:          page,
+		URL:           pageURL,
+		Outcome:       outcome,
+		Reason:        reason,
+		ConsoleErrors: tab.consoleErrors(),
+	}
+	artifact.CompanyName, _ = ctx.Value(artifactsCompanyKey{}).(string)
+
+	var screenshot []byte
+	err := chromedp.Run(ctx, chromedp.FullScreenshot(&screenshot, ARTIFACT_SCREENSHOT_QUALITY))
+	if err != nil {
+		logger.Warn("Unable to take a screenshot of the page", LOG_ERROR, err)
+	}
+	var html string
+	err = chromedp.Run(ctx, chromedp.Evaluate(`document.documentElement ? document.documentElement.outerHTML : ""`, &html))
+	if err != nil {
+		logger.Warn("Unable to get the HTML of the page", LOG_ERROR, err)
+	}
+	if len(html) > ARTIFACT_MAX_HTML_SIZE {
+		html = strings.ToValidUTF8(html[:ARTIFACT_MAX_HTML_SIZE], "")
+	}
+
+	id, err := createCrawlArtifact(ctx, recorder.db, artifact, screenshot, html)
+	if err != nil {
+		logger.Error("Unable to record the artifacts of the page", LOG_ERROR, err)
+		return
+	}
+	artifactsCapturedTotal.WithLabelValues(page, outcome).Inc()
+	logger.Info("The artifacts of the page have been captured", "artifact_id", id, "reason", reason)
+}
+
+// This function captures the artifacts of a page that failed to load, unless it was not fetched, to be polite or as its domain is down
+func captureErrorArtifacts(ctx context.Context, page string, pageURL string, err error) {
+	if errors.Is(err, errCircuitOpen) || isPageSkipped(err) || errors.Is(err, context.Canceled) {
+		return
+	}
+	captureArtifacts(ctx, page, pageURL, ARTIFACT_FAILURE, err.Error())
+}
+
+// This function records the console errors of the page shown in a tab: the errors logged by its scripts, its uncaught exceptions
+// and the errors of the browser, like the resources that failed to load
+func (t *pooledTab) recordConsole(ev interface{}) {
+	var message string
+
+	switch ev := ev.(type) {
+	case *runtime.EventConsoleAPICalled:
+		if ev.Type != runtime.APITypeError {
+			return
+		}
+		var args []string
+		for _, arg := range ev.Args {
+			if arg.Value != nil {
+				args = append(args, string(arg.Value))
+			} else {
+				args = append(args, arg.Description)
+			}
+		}
+		message = strings.Join(args, " ")
+	case *runtime.EventExceptionThrown:
+		message = ev.ExceptionDetails.Text
+		if ev.ExceptionDetails.Exception != nil && ev.ExceptionDetails.Exception.Description != "" {
+			message += " " + ev.ExceptionDetails.Exception.Description
+		}
+	case *log.EventEntryAdded:
+		// The requests blocked by the tab are not errors of the page
+		if ev.Entry.Level != log.LevelError || strings.Contains(ev.Entry.Text, "ERR_BLOCKED_BY_CLIENT") {
+			return
+		}
+		message = ev.Entry.Text
+		if ev.Entry.URL != "" {
+			message += " " + ev.Entry.URL
+		}
+	default:
+		return
+	}
+
+	t.consoleMu.Lock()
+	defer t.consoleMu.Unlock()
+	if len(t.console) < ARTIFACT_MAX_CONSOLE_ERRORS {
+		t.console = append(t.console, message)
+	}
+}
+
+// This function returns the console errors of the page shown in a tab
+func (t *pooledTab) consoleErrors() []string {
+	t.consoleMu.Lock()
+	defer t.consoleMu.Unlock()
+	return append([]string{}, t.console...)
+}
+
+// This function forgets the console errors of the page a tab showed, once it is given back
+func (t *pooledTab) clearConsole() {
+	t.consoleMu.Lock()
+	defer t.consoleMu.Unlock()
+	t.console = nil
+}
+
+func createCrawlArtifact(ctx context.Context, db *pgxpool.Pool, artifact CrawlArtifact, screenshot []byte, html string) (int, error) {
+	query := `INSERT INTO crawl_artifacts (run_id, company_name, page, url, outcome, reason, console_errors, screenshot, html)
+		VALUES (@run_id, @company_name, @page, @url, @outcome, @reason, @console_errors, @screenshot, @html) RETURNING id`
+	args := pgx.NamedArgs{
+		"run_id":         artifact.RunID,
+		"company_name":   artifact.CompanyName,
+		"page":           artifact.Page,
+		"url":            artifact.URL,
+		"outcome":        artifact.Outcome,
+		"reason":         artifact.Reason,
+		"console_errors": artifact.ConsoleErrors,
+		"screenshot":     screenshot,
+		"html":           html,
+	}
+	var id int
+	err := db.QueryRow(ctx, query, args).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return id, nil
+}
+
+// This function deletes the artifacts older than artifacts.retention, it returns the number deleted
+func deleteExpiredArtifacts(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	query := `DELETE FROM crawl_artifacts WHERE created_at < @before`
+	tag, err := db.Exec(ctx, query, pgx.NamedArgs{"before": time.Now().Add(-appConfig.Artifacts.Retention)})
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete rows: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// This function deletes the expired artifacts at the start of a run, an error being only logged as the run goes on
+func cleanExpiredArtifacts(ctx context.Context, db *pgxpool.Pool) {
+	deleted, err := deleteExpiredArtifacts(ctx, db)
+	if err != nil {
+		loggerFromContext(ctx).Error("Unable to delete the expired artifacts", LOG_ERROR, err)
+		return
+	}
+	if deleted > 0 {
+		loggerFromContext(ctx).Info("The expired artifacts have been deleted", "deleted", deleted)
+	}
+}
+
+// This function deletes the expired artifacts now and then every ARTIFACTS_CLEANUP_INTERVAL, until the context is done
+func cleanExpiredArtifactsPeriodically(ctx context.Context, db *pgxpool.Pool) {
+	ticker := time.NewTicker(ARTIFACTS_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		cleanExpiredArtifacts(ctx, db)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// This function returns the artifacts of a run, without their screenshot and HTML, the last captured first
+func getCrawlArtifacts(ctx context.Context, db *pgxpool.Pool, runID string, limit int) ([]CrawlArtifact, error) {
+	query := `SELECT id, run_id, company_name, page, url, outcome, reason, console_errors, created_at FROM crawl_artifacts
+		WHERE run_id = @run_id ORDER BY created_at DESC, id DESC LIMIT @limit`
+	rows, err := db.Query(ctx, query, pgx.NamedArgs{"run_id": runID, "limit": limit})
+	if err != nil {
+		return nil, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+
+	artifacts := []CrawlArtifact{}
+	for rows.Next() {
+		var artifact CrawlArtifact
+		err = rows.Scan(&artifact.ID, &artifact.RunID, &artifact.CompanyName, &artifact.Page, &artifact.URL, &artifact.Outcome, &artifact.Reason, &artifact.ConsoleErrors, &artifact.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		artifact.ScreenshotURL = "/artifacts/" + strconv.Itoa(artifact.ID) + "/" + ARTIFACT_SCREENSHOT
+		artifact.HTMLURL = "/artifacts/" + strconv.Itoa(artifact.ID) + "/" + ARTIFACT_HTML
+		artifacts = append(artifacts, artifact)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	return artifacts, nil
+}
+
+// This function returns the screenshot or the HTML of an artifact, by its column, it returns false when the artifact does not exist
+func getCrawlArtifactContent(ctx context.Context, db *pgxpool.Pool, id int, column string) ([]byte, bool, error) {
+	var content []byte
+	query := fmt.Sprintf(`SELECT %s FROM crawl_artifacts WHERE id = @id`, pgx.Identifier{column}.Sanitize())
+	err := db.QueryRow(ctx, query, pgx.NamedArgs{"id": id}).Scan(&content)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	return content, true, nil
+}
+
+// This function returns the artifacts captured in a crawl run, or by a worker with its id
+func getCrawlArtifactsAPI(c *gin.Context) {
+	artifacts, err := getCrawlArtifacts(c.Request.Context(), dbpoolapi, c.Param("id"), ARTIFACTS_LIST_LIMIT)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": artifacts})
+}
+
+// This function returns the screenshot or the HTML of an artifact. The HTML is served as text, so the scripts of the page do not run on the site.
+func getCrawlArtifactContentAPI(column string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		content, found, err := getCrawlArtifactContent(c.Request.Context(), dbpoolapi, id, column)
+		if err != nil {
+			slog.Error("An error happened with the query", LOG_ERROR, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		if !found || len(content) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+			return
+		}
+
+		c.Header("X-Content-Type-Options", "nosniff")
+		if column == ARTIFACT_HTML {
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", content)
+			return
+		}
+		c.Data(http.StatusOK, "image/jpeg", content)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The name of the cookie holding the web interface session token
+const SESSION_COOKIE = "session"
+
+// The key under which the authenticated user is stored in the request context
+const USER_CONTEXT_KEY = "user"
+
+// The name of the form field holding the CSRF token of the session, sent by every form of the web interface that changes something
+const CSRF_FIELD = "csrf_token"
+
+// This middleware identifies the caller from its session cookie or its API token given as a bearer token.
+// It does not reject anonymous callers, requireUser and requireRole do.
+func authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user User
+		var found bool
+		var err error
+
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			user, found, err = getAPITokenUser(c.Request.Context(), dbpoolapi, strings.TrimPrefix(header, "Bearer "))
+		} else if cookie, cookieErr := c.Cookie(SESSION_COOKIE); cookieErr == nil {
+			user, found, err = getSessionUser(c.Request.Context(), dbpoolapi, cookie)
+		}
+
+		if err != nil {
+			slog.Error("An error happened while authenticating the request", LOG_ERROR, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed"})
+			return
+		}
+		if found {
+			c.Set(USER_CONTEXT_KEY, user)
+		}
+
+		c.Next()
+	}
+}
+
+// This middleware identifies the caller of a feed from the token query parameter, the feed readers being unable to send headers.
+// It is only used by the feed routes, as a token in a url ends up in the logs of the proxies and in the Referer headers.
+func authenticateFeedReader() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if _, ok := currentUser(c); ok || token == "" {
+			c.Next()
+			return
+		}
+
+		user, found, err := getAPITokenUser(c.Request.Context(), dbpoolapi, token)
+		if err != nil {
+			slog.Error("An error happened while authenticating the request", LOG_ERROR, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Authentication failed"})
+			return
+		}
+		if found {
+			c.Set(USER_CONTEXT_KEY, user)
+		}
+
+		c.Next()
+	}
+}
+
+// This function returns the authenticated user of a request, or false for anonymous requests
+func currentUser(c *gin.Context) (User, bool) {
+	value, exists := c.Get(USER_CONTEXT_KEY)
+	if !exists {
+		return User{}, false
+	}
+	user, ok := value.(User)
+	return user, ok
+}
+
+// This function returns the id of the authenticated user of a request, or 0 for anonymous requests
+func currentUserID(c *gin.Context) int {
+	user, _ := currentUser(c)
+	return user.ID
+}
+
+// This middleware rejects anonymous requests, the web interface pages are redirected to the login page
+func requireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := currentUser(c); !ok {
+			if isUIRequest(c) {
+				// After the login, a form is not sent again, the user goes back to the page it was sent from
+				redirect := c.Request.URL.RequestURI()
+				if c.Request.Method != http.MethodGet {
+					redirect = safeRedirect(c.PostForm("redirect"), "/")
+				}
+				c.Redirect(http.StatusSeeOther, "/login?redirect="+url.QueryEscape(redirect))
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// This middleware rejects the requests whose user does not have the given role, admins have every role
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if user.Role != role && !user.isAdmin() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not allowed to do this"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// This function returns the CSRF token of a session, derived from its token so it needs no storage.
+// Another site can make the browser send the session cookie, but cannot read it nor the pages, so it cannot send this token.
+func csrfToken(sessionToken string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// This middleware rejects the forms sent with a session cookie without the CSRF token of the session, so another site
+// cannot change the wishlist or the applications of a user, or log them out. The requests authenticated by an API token carry no cookie to abuse.
+func verifyCSRFToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie, err := c.Cookie(SESSION_COOKIE)
+		if err != nil || strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+			c.Next()
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(c.PostForm(CSRF_FIELD)), []byte(csrfToken(cookie))) != 1 {
+			slog.Warn("A form was sent without the CSRF token of its session", "path", c.Request.URL.Path)
+			c.String(http.StatusForbidden, "The form has expired, please reload the page and send it again")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// The web interface pages are the ones under /board, the others answer in json
+func isUIRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/board/")
+}
+
+// This function returns the redirection asked by a form or query, as long as it stays on the board
+func safeRedirect(redirect string, fallback string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return fallback
+	}
+	return redirect
+}
+
+func loginPage(c *gin.Context) {
+	renderPage(c, http.StatusOK, "login.html", gin.H{
+		"Title":    "Login",
+		"Redirect": safeRedirect(c.Query("redirect"), "/"),
+	})
+}
+
+func login(c *gin.Context) {
+	redirect := safeRedirect(c.PostForm("redirect"), "/")
+
+	user, ok, err := authenticateUser(c.Request.Context(), dbpoolapi, c.PostForm("email"), c.PostForm("password"))
+	if err != nil {
+		slog.Error("An error happened while authenticating the user", LOG_ERROR, err)
+		renderPage(c, http.StatusInternalServerError, "login.html", gin.H{"Title": "Login", "Redirect": redirect, "LoginError": "The login failed, please retry"})
+		return
+	}
+	if !ok {
+		renderPage(c, http.StatusUnauthorized, "login.html", gin.H{"Title": "Login", "Redirect": redirect, "Email": c.PostForm("email"), "LoginError": "Wrong email or password"})
+		return
+	}
+
+	token, err := createSession(c.Request.Context(), dbpoolapi, user.ID)
+	if err != nil {
+		slog.Error("An error happened while creating the session", LOG_ERROR, err)
+		renderPage(c, http.StatusInternalServerError, "login.html", gin.H{"Title": "Login", "Redirect": redirect, "LoginError": "The login failed, please retry"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SESSION_COOKIE, token, int(SESSION_DURATION.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusSeeOther, redirect)
+}
+
+func logout(c *gin.Context) {
+	if cookie, err := c.Cookie(SESSION_COOKIE); err == nil {
+		err = deleteSession(c.Request.Context(), dbpoolapi, cookie)
+		if err != nil {
+			slog.Error("An error happened while deleting the session", LOG_ERROR, err)
+		}
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SESSION_COOKIE, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+func getCurrentUserAPI(c *gin.Context) {
+	user, _ := currentUser(c)
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+func getAPITokensAPI(c *gin.Context) {
+	tokens, err := getAPITokens(c.Request.Context(), dbpoolapi, currentUserID(c))
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tokens})
+}
+
+func createAPITokenAPI(c *gin.Context) {
+	var request struct {
+		Name string `binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiToken, token, err := createAPIToken(c.Request.Context(), dbpoolapi, currentUserID(c), request.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": apiToken, "token": token})
+}
+
+func deleteAPITokenAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	exists, err := deleteAPIToken(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func getUsersAPI(c *gin.Context) {
+	users, err := getUsers(c.Request.Context(), dbpoolapi)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+func createUserAPI(c *gin.Context) {
+	var request struct {
+		Email    string `binding:"required,email"`
+		Password string `binding:"required"`
+		Role     string `binding:"required,oneof=admin reader"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := createUser(c.Request.Context(), dbpoolapi, request.Email, request.Password, request.Role)
+	if err == errEmailAlreadyUsed {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": user})
+}
+
+func deleteUserAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+	if id == currentUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete yourself"})
+		return
+	}
+
+	exists, err := deleteUser(c.Request.Context(), dbpoolapi, id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// This function is the create-user command, used to create the first admin : create-user <email> <admin|reader>
+// The password is read from the FTJ_PASSWORD environment variable or from the standard input.
+func createUserCommand(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: french-top-jobs create-user [flags] <email> <admin|reader>")
+	}
+
+	password := os.Getenv("FTJ_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("unable to read the password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	db, err := initDbConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user, err := createUser(ctx, db, args[0], password, args[1])
+	if err != nil {
+		return fmt.Errorf("unable to create the user: %w", err)
+	}
+
+	slog.Info("The user has been created", "email", user.Email, "role", user.Role)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyCSRFToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/board/wishlist/:slug", verifyCSRFToken(), func(c *gin.Context) {
+		c.Status(http.StatusSeeOther)
+	})
+
+	const session = "session-token"
+	tests := []struct {
+		name          string
+		cookie        string
+		authorization string
+		token         string
+		want          int
+	}{
+		{"token of the session", session, "", csrfToken(session), http.StatusSeeOther},
+		{"without token", session, "", "", http.StatusForbidden},
+		{"token of another session", session, "", csrfToken("other-session"), http.StatusForbidden},
+		{"session token itself", session, "", session, http.StatusForbidden},
+		{"without session", "", "", "", http.StatusSeeOther},
+		{"api token", session, "Bearer ftj_token", "", http.StatusSeeOther},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{"redirect": {"/"}}
+			if test.token != "" {
+				form.Set(CSRF_FIELD, test.token)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/board/wishlist/back-market", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: test.cookie})
+			}
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != test.want {
+				t.Errorf("the form was answered with %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestFormsCarryTheCSRFToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const session = "session-token"
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/board/applications", nil)
+	c.Request.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: session})
+	c.Set(USER_CONTEXT_KEY, User{ID: 1, Email: "user@example.fr"})
+
+	renderPage(c, http.StatusOK, "applications.html", gin.H{
+		"Title":        "Applications",
+		"Statuses":     []string{"saved", "applied"},
+		"Applications": []Application{{ID: 7, CompanyName: "Back Market", Status: "saved"}},
+	})
+
+	page := w.Body.String()
+	forms := strings.Count(page, `method="post"`)
+	tokens := strings.Count(page, `name="csrf_token" value="`+csrfToken(session)+`"`)
+	if forms == 0 || tokens != forms {
+		t.Errorf("the page has %d forms sent by post and %d CSRF tokens of the session", forms, tokens)
+	}
+}
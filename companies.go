@@ -2,36 +2,122 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/text/unicode/norm"
 )
 
 // This variable stores the name of a company and the website associated
 type Company struct {
-	Name        string
-	IsTop500    bool
-	Website     string
-	LinkedInURL string
-	WTTJURL     string
-	JobsPageURL string
+	Name         string
+	IsTop500     bool
+	Website      string
+	LinkedInURL  string
+	WTTJURL      string
+	JobsPageURL  string
+	IsContractor bool
+	Slug         string
 }
 
-func addCompany(db *pgxpool.Pool, company Company) error {
-	query := `INSERT INTO companies (name, is_top_500, website_url, linkedin_url, wttj_url, job_page_url) VALUES (@name, @isTop500, @website_url, @linkedin_url, @wttj_url, @job_page_url)`
+// The columns read when scanning a company with scanCompany
+const COMPANY_COLUMNS = `name, is_top_500, website_url, linkedin_url, wttj_url, job_page_url, is_contractor, coalesce(slug, '')`
+
+// This function scans a row selected with the COMPANY_COLUMNS columns
+func scanCompany(row pgx.Row) (Company, error) {
+	var company Company
+	err := row.Scan(&company.Name, &company.IsTop500, &company.Website, &company.LinkedInURL, &company.WTTJURL, &company.JobsPageURL, &company.IsContractor, &company.Slug)
+	return company, err
+}
+
+// This function returns the name of a company as it is used in urls, "L'Oréal Paris" becomes "l-oreal-paris"
+func companySlug(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Accents are dropped
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && slug.Len() > 0 {
+				slug.WriteRune('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return slug.String()
+}
+
+// The slug of the companies whose name has no letter or digit
+const COMPANY_SLUG_FALLBACK = "company"
+
+// This function returns the first slug of a company name that is not taken: the slug of its name, else the slug followed by "-2", "-3"...
+// The companies whose name gives no slug get COMPANY_SLUG_FALLBACK instead.
+func nextFreeSlug(name string, taken map[string]bool) string {
+	base := companySlug(name)
+	if base == "" {
+		base = COMPANY_SLUG_FALLBACK
+	}
+	slug := base
+	for i := 2; taken[slug]; i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return slug
+}
+
+// This function returns a slug for a company name that no other company has, names like "L'Oréal" and "L Oreal" giving the same slug
+func uniqueCompanySlug(ctx context.Context, db *pgxpool.Pool, name string) (string, error) {
+	base := companySlug(name)
+	if base == "" {
+		base = COMPANY_SLUG_FALLBACK
+	}
+
+	// The slugs are made of letters, digits and dashes only, so they have no LIKE wildcard to escape
+	query := `SELECT slug FROM companies WHERE slug = @slug OR slug LIKE @slug || '-%'`
+	rows, err := db.Query(ctx, query, pgx.NamedArgs{"slug": base})
+	if err != nil {
+		return "", fmt.Errorf("unable to query rows: %w", err)
+	}
+	slugs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", fmt.Errorf("unable to scan rows: %w", err)
+	}
+
+	taken := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		taken[slug] = true
+	}
+	return nextFreeSlug(name, taken), nil
+}
+
+func addCompany(ctx context.Context, db *pgxpool.Pool, company Company) error {
+	slug, err := uniqueCompanySlug(ctx, db, company.Name)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO companies (name, is_top_500, website_url, linkedin_url, wttj_url, job_page_url, is_contractor, slug) VALUES (@name, @isTop500, @website_url, @linkedin_url, @wttj_url, @job_page_url, @is_contractor, @slug)`
 	args := pgx.NamedArgs{
-		"name":         company.Name,
-		"isTop500":     company.IsTop500,
-		"website_url":  company.Website,
-		"linkedin_url": company.LinkedInURL,
-		"wttj_url":     company.WTTJURL,
-		"job_page_url": company.JobsPageURL,
-	}
-	_, err := db.Exec(context.TODO(), query, args)
+		"name":          company.Name,
+		"isTop500":      company.IsTop500,
+		"website_url":   company.Website,
+		"linkedin_url":  company.LinkedInURL,
+		"wttj_url":      company.WTTJURL,
+		"job_page_url":  company.JobsPageURL,
+		"is_contractor": company.IsContractor,
+		"slug":          slug,
+	}
+	_, err = db.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to insert row: %w", err)
 	}
@@ -39,14 +125,14 @@ func addCompany(db *pgxpool.Pool, company Company) error {
 	return err
 }
 
-func addMultipleCompanies(db *pgxpool.Pool, companies []Company) error {
+func addMultipleCompanies(ctx context.Context, db *pgxpool.Pool, companies []Company) error {
 	var rows [][]interface{}
 	for _, company := range companies {
 		companySlice := []interface{}{company.Name, company.IsTop500, company.Website, company.LinkedInURL, company.WTTJURL, company.JobsPageURL}
 		rows = append(rows, companySlice)
 	}
 	_, err := db.CopyFrom(
-		context.TODO(),
+		ctx,
 		pgx.Identifier{"name"},
 		[]string{"name", "is_top_500", "website_url", "linkedin_url", "wttj_url", "job_page_url"},
 		pgx.CopyFromRows(rows),
@@ -58,13 +144,13 @@ func addMultipleCompanies(db *pgxpool.Pool, companies []Company) error {
 	return err
 }
 
-func updatecompanyValue(db *pgxpool.Pool, companyName string, value string, content any) error {
+func updatecompanyValue(ctx context.Context, db *pgxpool.Pool, companyName string, value string, content any) error {
 	query := `UPDATE companies SET ` + value + ` = @content WHERE name = @companyName`
 	args := pgx.NamedArgs{
 		"companyName": companyName,
 		"content":     content,
 	}
-	_, err := db.Exec(context.Background(), query, args)
+	_, err := db.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to update row: %w", err)
 	}
@@ -72,23 +158,23 @@ func updatecompanyValue(db *pgxpool.Pool, companyName string, value string, cont
 	return err
 }
 
-func updateCompanyLinkedinURL(db *pgxpool.Pool, companyName string, content string) error {
-	err := updatecompanyValue(db, companyName, "linkedin_url", content)
+func updateCompanyLinkedinURL(ctx context.Context, db *pgxpool.Pool, companyName string, content string) error {
+	err := updatecompanyValue(ctx, db, companyName, "linkedin_url", content)
 	return err
 }
 
-func updateCompanyWTTJURL(db *pgxpool.Pool, companyName string, content string) error {
-	err := updatecompanyValue(db, companyName, "wttj_url", content)
+func updateCompanyWTTJURL(ctx context.Context, db *pgxpool.Pool, companyName string, content string) error {
+	err := updatecompanyValue(ctx, db, companyName, "wttj_url", content)
 	return err
 }
 
-func updateCompanyWebsiteURL(db *pgxpool.Pool, companyName string, content string) error {
-	err := updatecompanyValue(db, companyName, "website_url", content)
+func updateCompanyWebsiteURL(ctx context.Context, db *pgxpool.Pool, companyName string, content string) error {
+	err := updatecompanyValue(ctx, db, companyName, "website_url", content)
 	return err
 }
 
-func updateCompany(db *pgxpool.Pool, company Company) error {
-	query := `UPDATE companies SET name = @name, is_top_500 = @isTop500, website_url = @website_url, linkedin_url = @linkedin_url, wttj_url = @wttj_url, job_page_url = @job_page_url WHERE name = @companyToUpdate`
+func updateCompany(ctx context.Context, db *pgxpool.Pool, company Company) error {
+	query := `UPDATE companies SET name = @name, is_top_500 = @isTop500, website_url = @website_url, linkedin_url = @linkedin_url, wttj_url = @wttj_url, job_page_url = @job_page_url, is_contractor = @is_contractor WHERE name = @companyToUpdate`
 	args := pgx.NamedArgs{
 		"name":            company.Name,
 		"isTop500":        company.IsTop500,
@@ -96,9 +182,10 @@ func updateCompany(db *pgxpool.Pool, company Company) error {
 		"linkedin_url":    company.LinkedInURL,
 		"wttj_url":        company.WTTJURL,
 		"job_page_url":    company.JobsPageURL,
+		"is_contractor":   company.IsContractor,
 		"companyToUpdate": company.Name,
 	}
-	_, err := db.Exec(context.Background(), query, args)
+	_, err := db.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to update row: %w", err)
 	}
@@ -106,22 +193,20 @@ func updateCompany(db *pgxpool.Pool, company Company) error {
 	return err
 }
 
-func getCompany(db *pgxpool.Pool, companyName string) (Company, bool, error) {
-	var company Company
-
+func getCompany(ctx context.Context, db *pgxpool.Pool, companyName string) (Company, bool, error) {
 	exists := false
 
-	query := "select * from companies where name = @companyName"
+	query := "select " + COMPANY_COLUMNS + " from companies where name = @companyName"
 	args := pgx.NamedArgs{
 		"companyName": companyName,
 	}
-	row := db.QueryRow(context.TODO(), query, args)
-	err := row.Scan(&company.Name, &company.IsTop500, &company.Website, &company.LinkedInURL, &company.WTTJURL, &company.JobsPageURL)
+	row := db.QueryRow(ctx, query, args)
+	company, err := scanCompany(row)
 	switch {
 	case err == pgx.ErrNoRows:
 		err = nil
 	case err != nil:
-		log.Printf("Database query failed because of %s :", err)
+		slog.Error("Database query failed", LOG_ERROR, err)
 	default:
 		exists = true
 	}
@@ -129,20 +214,65 @@ func getCompany(db *pgxpool.Pool, companyName string) (Company, bool, error) {
 	return company, exists, err
 }
 
-func getAllCompanies(db *pgxpool.Pool) []Company {
+func getCompanyBySlug(ctx context.Context, db *pgxpool.Pool, slug string) (Company, bool, error) {
+	exists := false
+
+	query := "select " + COMPANY_COLUMNS + " from companies where slug = @slug"
+	args := pgx.NamedArgs{
+		"slug": slug,
+	}
+	company, err := scanCompany(db.QueryRow(ctx, query, args))
+	switch {
+	case err == pgx.ErrNoRows:
+		err = nil
+	case err != nil:
+		slog.Error("Database query failed", LOG_ERROR, err)
+	default:
+		exists = true
+	}
+
+	return company, exists, err
+}
+
+// This function sets the slug of the companies added before slugs existed, the companies whose slug cannot be set being skipped
+// and their errors returned joined
+func fillMissingCompanySlugs(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, "select name from companies where slug is null order by name")
+	if err != nil {
+		return fmt.Errorf("unable to query rows: %w", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("unable to scan rows: %w", err)
+	}
+
+	var errs []error
+	for _, name := range names {
+		slug, err := uniqueCompanySlug(ctx, db, name)
+		if err == nil {
+			err = updatecompanyValue(ctx, db, name, "slug", slug)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to set the slug of %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func getAllCompanies(ctx context.Context, db *pgxpool.Pool) []Company {
 	var companies []Company
 
-	query := "select * from companies"
+	query := "select " + COMPANY_COLUMNS + " from companies"
 
-	rows, err := db.Query(context.TODO(), query)
+	rows, err := db.Query(ctx, query)
 	if err != nil {
-		log.Printf("Database query failed because of %s :", err)
+		slog.Error("Database query failed", LOG_ERROR, err)
 	}
 	for rows.Next() {
-		var company Company
-		err = rows.Scan(&company.Name, &company.IsTop500, &company.Website, &company.LinkedInURL, &company.WTTJURL, &company.JobsPageURL)
+		company, err := scanCompany(rows)
 		if err != nil {
-			log.Printf("Database query scan failed because of %s :", err)
+			slog.Error("Database query scan failed", LOG_ERROR, err)
 		}
 		companies = append(companies, company)
 	}
@@ -150,12 +280,12 @@ func getAllCompanies(db *pgxpool.Pool) []Company {
 	return companies
 }
 
-func deleteCompany(db *pgxpool.Pool, companyName string) error {
+func deleteCompany(ctx context.Context, db *pgxpool.Pool, companyName string) error {
 	query := `DELETE FROM companies WHERE name = @companyToDelete`
 	args := pgx.NamedArgs{
 		"companyToDelete": companyName,
 	}
-	_, err := db.Exec(context.Background(), query, args)
+	_, err := db.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to delete row: %w", err)
 	}
@@ -164,12 +294,24 @@ func deleteCompany(db *pgxpool.Pool, companyName string) error {
 }
 
 func getAllCompaniesAPI(c *gin.Context) {
-	companies := getAllCompanies(dbpoolapi)
+	// Only return the members of a list edition when the list query parameter is given
+	if slug := c.Query("list"); slug != "" {
+		year, err := parseCompanyListYear(c, slug)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		companies := getCompaniesInList(c.Request.Context(), dbpoolapi, slug, year)
+		c.JSON(http.StatusOK, gin.H{"data": companies})
+		return
+	}
+
+	companies := getAllCompanies(c.Request.Context(), dbpoolapi)
 	c.JSON(http.StatusOK, gin.H{"data": companies})
 }
 
 func getCompanyAPI(c *gin.Context) {
-	company, exists, _ := getCompany(dbpoolapi, c.Query("name"))
+	company, exists, _ := getCompany(c.Request.Context(), dbpoolapi, c.Query("name"))
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Record not found!"})
 		return
@@ -177,3 +319,45 @@ func getCompanyAPI(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"data": company})
 }
+
+func deleteCompanyAPI(c *gin.Context) {
+	company, ok := getCompanyFromPath(c)
+	if !ok {
+		return
+	}
+
+	err := deleteCompany(c.Request.Context(), dbpoolapi, company.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// This function flags a company as a contractor or not, the offers of contractors can be left out of the searches
+func updateCompanyContractorAPI(c *gin.Context) {
+	company, ok := getCompanyFromPath(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		IsContractor bool
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := updatecompanyValue(c.Request.Context(), dbpoolapi, company.Name, "is_contractor", request.IsContractor)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	company.IsContractor = request.IsContractor
+	c.JSON(http.StatusOK, gin.H{"data": company})
+}
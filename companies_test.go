@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestCompanySlug(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"L'Oréal Paris", "l-oreal-paris"},
+		{"L Oreal", "l-oreal"},
+		{"  Société Générale  ", "societe-generale"},
+		{"BNP Paribas (France)", "bnp-paribas-france"},
+		{"42", "42"},
+		{"Ça & Là", "ca-la"},
+		{"!!!", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := companySlug(test.name); got != test.want {
+				t.Errorf("companySlug(%q) = %q, want %q", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNextFreeSlug(t *testing.T) {
+	tests := []struct {
+		name    string
+		company string
+		taken   []string
+		want    string
+	}{
+		{"free", "L'Oréal", nil, "l-oreal"},
+		{"taken", "L Oreal", []string{"l-oreal"}, "l-oreal-2"},
+		{"suffixes taken", "L.Oreal", []string{"l-oreal", "l-oreal-2", "l-oreal-3"}, "l-oreal-4"},
+		{"suffix of another name", "L'Oréal 2", []string{"l-oreal", "l-oreal-2"}, "l-oreal-2-2"},
+		{"gap in the suffixes", "L'Oréal", []string{"l-oreal", "l-oreal-3"}, "l-oreal-2"},
+		{"no letter", "!!!", nil, COMPANY_SLUG_FALLBACK},
+		{"no letter taken", "***", []string{COMPANY_SLUG_FALLBACK}, COMPANY_SLUG_FALLBACK + "-2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			taken := make(map[string]bool)
+			for _, slug := range test.taken {
+				taken[slug] = true
+			}
+			if got := nextFreeSlug(test.company, taken); got != test.want {
+				t.Errorf("nextFreeSlug(%q, %v) = %q, want %q", test.company, test.taken, got, test.want)
+			}
+		})
+	}
+}
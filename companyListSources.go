@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gocolly/colly"
+	"gopkg.in/yaml.v2"
+)
+
+// This variable stores one line of a company list : the company name and its rank in the list (0 when the list is not ranked)
+type CompanyListEntry struct {
+	CompanyName string
+	Rank        int
+}
+
+// A company list source knows how to fetch the members of one edition of a list (Tech500 2023, Next40 2024, ...)
+type CompanyListSource interface {
+	ListSlug() string
+	ListName() string
+	Year() int
+	Fetch() ([]CompanyListEntry, error)
+}
+
+// This source scrapes an html table where each row is a company, like the tablepress tables used by datarecrutement.fr
+type htmlTableListSource struct {
+	Slug        string `yaml:"slug"`
+	Name        string `yaml:"name"`
+	EditionYear int    `yaml:"year"`
+	URL         string `yaml:"url"`
+	// CSS selector of the table body containing the rows
+	TableSelector string `yaml:"table_selector"`
+	// 1-based column indexes, a rank column of 0 means the row position is used as the rank
+	NameColumn int `yaml:"name_column"`
+	RankColumn int `yaml:"rank_column"`
+}
+
+func (s htmlTableListSource) ListSlug() string { return s.Slug }
+func (s htmlTableListSource) ListName() string { return s.Name }
+func (s htmlTableListSource) Year() int        { return s.EditionYear }
+
+func (s htmlTableListSource) Fetch() ([]CompanyListEntry, error) {
+	var entries []CompanyListEntry
+	var rankErr error
+
+	// Create a new colly collector
+	c := colly.NewCollector()
+
+	// Define a callback to execute when the collector finds the table holding the list.
+	c.OnHTML(s.TableSelector, func(h *colly.HTMLElement) {
+		h.ForEach("tr", func(i int, el *colly.HTMLElement) {
+			name := strings.TrimSpace(el.ChildText(fmt.Sprintf("td:nth-child(%d)", s.NameColumn)))
+			if name == "" {
+				return
+			}
+			rank := i + 1
+			if s.RankColumn > 0 {
+				rankText := strings.TrimSpace(el.ChildText(fmt.Sprintf("td:nth-child(%d)", s.RankColumn)))
+				var err error
+				rank, err = strconv.Atoi(rankText)
+				if err != nil {
+					if rankErr == nil {
+						rankErr = fmt.Errorf("invalid rank %q for %s in %s", rankText, name, s.URL)
+					}
+					return
+				}
+			}
+			entries = append(entries, CompanyListEntry{CompanyName: name, Rank: rank})
+		})
+	})
+
+	err := c.Visit(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to visit %s: %w", s.URL, err)
+	}
+	if rankErr != nil {
+		return nil, rankErr
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no company found in %s with selector %s", s.URL, s.TableSelector)
+	}
+
+	return entries, nil
+}
+
+// This source scrapes an html page where each element matching a selector is a company, like the cards of the laureates of
+// the French Tech programs. The companies are ranked by their order on the page.
+type htmlListSource struct {
+	Slug        string `yaml:"slug"`
+	Name        string `yaml:"name"`
+	EditionYear int    `yaml:"year"`
+	URL         string `yaml:"url"`
+	// CSS selector of the elements holding the companies names
+	ItemSelector string `yaml:"item_selector"`
+}
+
+func (s htmlListSource) ListSlug() string { return s.Slug }
+func (s htmlListSource) ListName() string { return s.Name }
+func (s htmlListSource) Year() int        { return s.EditionYear }
+
+func (s htmlListSource) Fetch() ([]CompanyListEntry, error) {
+	var entries []CompanyListEntry
+	seen := make(map[string]bool)
+
+	c := colly.NewCollector()
+
+	c.OnHTML(s.ItemSelector, func(el *colly.HTMLElement) {
+		name := strings.Join(strings.Fields(el.Text), " ")
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		entries = append(entries, CompanyListEntry{CompanyName: name, Rank: len(entries) + 1})
+	})
+
+	err := c.Visit(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to visit %s: %w", s.URL, err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no company found in %s with selector %s", s.URL, s.ItemSelector)
+	}
+
+	return entries, nil
+}
+
+// This source reads a local csv file, the first column is the company name and the optional second one its rank
+type csvListSource struct {
+	Slug        string `yaml:"slug"`
+	Name        string `yaml:"name"`
+	EditionYear int    `yaml:"year"`
+	Path        string `yaml:"path"`
+	// Set it to true when the first line of the file contains the columns names
+	Header bool `yaml:"header"`
+}
+
+func (s csvListSource) ListSlug() string { return s.Slug }
+func (s csvListSource) ListName() string { return s.Name }
+func (s csvListSource) Year() int        { return s.EditionYear }
+
+func (s csvListSource) Fetch() ([]CompanyListEntry, error) {
+	var entries []CompanyListEntry
+
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", s.Path, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", s.Path, err)
+		}
+		if line == 0 && s.Header {
+			continue
+		}
+
+		name := strings.TrimSpace(record[0])
+		if name == "" {
+			continue
+		}
+		rank := len(entries) + 1
+		if len(record) > 1 && strings.TrimSpace(record[1]) != "" {
+			rank, err = strconv.Atoi(strings.TrimSpace(record[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid rank %q for %s in %s", record[1], name, s.Path)
+			}
+		}
+		entries = append(entries, CompanyListEntry{CompanyName: name, Rank: rank})
+	}
+
+	return entries, nil
+}
+
+// The list editions known by default, new editions or other lists, like the Next40 and French Tech 120 of company-lists.example.yaml,
+// can be added in the company lists file
+var defaultCompanyListSources = []CompanyListSource{
+	htmlTableListSource{
+		Slug:          "tech500",
+		Name:          "Tech500",
+		EditionYear:   2023,
+		URL:           "https://datarecrutement.fr/actualites/nos-actualites/tech500/",
+		TableSelector: "table#tablepress-9 > tbody",
+		NameColumn:    2,
+	},
+}
+
+// This variable describes the company lists file, each list kind has its own section
+type companyListsFile struct {
+	HTMLTables []htmlTableListSource `yaml:"html_tables"`
+	HTMLLists  []htmlListSource      `yaml:"html_lists"`
+	CSV        []csvListSource       `yaml:"csv"`
+}
+
+// This function returns the default company list sources plus the ones declared in the company lists file if it exists,
+// a list of the file replacing the default edition with the same slug and year
+func loadCompanyListSources(path string) ([]CompanyListSource, error) {
+	var sources []CompanyListSource
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return append(sources, defaultCompanyListSources...), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var listsFile companyListsFile
+	err = yaml.NewDecoder(file).Decode(&listsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", path, err)
+	}
+
+	for _, source := range listsFile.HTMLTables {
+		if source.NameColumn == 0 {
+			source.NameColumn = 1
+		}
+		sources = append(sources, source)
+	}
+	for _, source := range listsFile.HTMLLists {
+		if source.ItemSelector == "" {
+			return nil, fmt.Errorf("the html list %s in %s needs an item_selector", source.Slug, path)
+		}
+		sources = append(sources, source)
+	}
+	for _, source := range listsFile.CSV {
+		if source.Path == "" {
+			return nil, fmt.Errorf("the csv list %s in %s needs a path", source.Slug, path)
+		}
+		sources = append(sources, source)
+	}
+
+	for _, source := range sources {
+		if source.ListSlug() == "" || source.Year() == 0 {
+			return nil, fmt.Errorf("every company list in %s needs a slug and a year", path)
+		}
+	}
+
+	for _, source := range defaultCompanyListSources {
+		overridden := slices.ContainsFunc(sources, func(s CompanyListSource) bool {
+			return s.ListSlug() == source.ListSlug() && s.Year() == source.Year()
+		})
+		if !overridden {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources, nil
+}
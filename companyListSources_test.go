@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The page of the laureates of a program, as the French Tech mission publishes them
+const LAUREATES_FIXTURE = `<html><body>
+<section id="next40">
+  <div class="laureate"><span class="laureate-name"> Back Market </span></div>
+  <div class="laureate"><span class="laureate-name">Doctolib</span></div>
+  <div class="laureate"><span class="laureate-name">Back
+    Market</span></div>
+  <div class="laureate"><span class="laureate-name"> </span></div>
+</section>
+<section id="french-tech-120">
+  <div class="laureate"><span class="laureate-name">Alan</span></div>
+</section>
+</body></html>`
+
+// The page of a ranked list published as a tablepress table
+const RANKED_TABLE_FIXTURE = `<html><body>
+<table id="tablepress-9"><tbody>
+  <tr><td>1</td><td>Back Market</td></tr>
+  <tr><td>2</td><td>Doctolib</td></tr>
+  <tr><td></td><td></td></tr>
+  <tr><td>3</td><td>Alan</td></tr>
+</tbody></table>
+</body></html>`
+
+// This function serves an html page for the duration of a test
+func serveFixture(t *testing.T, html string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(html))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestHTMLListSourceFetch(t *testing.T) {
+	url := serveFixture(t, LAUREATES_FIXTURE)
+
+	source := htmlListSource{Slug: "next40", Name: "Next40", EditionYear: 2024, URL: url, ItemSelector: "#next40 .laureate-name"}
+	entries, err := source.Fetch()
+	if err != nil {
+		t.Fatalf("unable to fetch the list: %v", err)
+	}
+	want := []CompanyListEntry{{CompanyName: "Back Market", Rank: 1}, {CompanyName: "Doctolib", Rank: 2}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("the list has %v, want %v", entries, want)
+	}
+
+	source.ItemSelector = "#next40 .laureate-title"
+	if _, err := source.Fetch(); err == nil {
+		t.Error("a selector matching no company did not fail the list")
+	}
+}
+
+func TestHTMLTableListSourceFetch(t *testing.T) {
+	url := serveFixture(t, RANKED_TABLE_FIXTURE)
+
+	source := htmlTableListSource{Slug: "tech500", EditionYear: 2023, URL: url, TableSelector: "table#tablepress-9 > tbody", NameColumn: 2, RankColumn: 1}
+	entries, err := source.Fetch()
+	if err != nil {
+		t.Fatalf("unable to fetch the list: %v", err)
+	}
+	want := []CompanyListEntry{{CompanyName: "Back Market", Rank: 1}, {CompanyName: "Doctolib", Rank: 2}, {CompanyName: "Alan", Rank: 3}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("the list has %v, want %v", entries, want)
+	}
+
+	invalid := serveFixture(t, strings.Replace(RANKED_TABLE_FIXTURE, "<td>2</td>", "<td>#2</td>", 1))
+	source.URL = invalid
+	if _, err := source.Fetch(); err == nil {
+		t.Error("a rank that is not a number did not fail the list")
+	}
+}
+
+func TestLoadCompanyListSources(t *testing.T) {
+	// The example file declares valid lists, the default edition it does not override being kept
+	sources, err := loadCompanyListSources("company-lists.example.yaml")
+	if err != nil {
+		t.Fatalf("unable to load the example company lists: %v", err)
+	}
+	var editions []string
+	for _, source := range sources {
+		editions = append(editions, source.ListSlug())
+	}
+	want := []string{"tech500", "next40", "french-tech-120", "my-selection", "tech500"}
+	if !reflect.DeepEqual(editions, want) {
+		t.Errorf("the example file gives the lists %v, want %v", editions, want)
+	}
+
+	// Without file, only the default editions are fetched
+	sources, err = loadCompanyListSources(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil || len(sources) != len(defaultCompanyListSources) {
+		t.Errorf("without file the lists are %v, %v, want the default ones", sources, err)
+	}
+
+	invalid := map[string]string{
+		"csv without path":           "csv:\n  - slug: mine\n    year: 2024\n",
+		"html list without selector": "html_lists:\n  - slug: next40\n    year: 2024\n    url: https://example.fr\n",
+		"list without year":          "csv:\n  - slug: mine\n    path: mine.csv\n",
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "company-lists.yaml")
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatalf("unable to write the company lists file: %v", err)
+			}
+			if _, err := loadCompanyListSources(path); err == nil {
+				t.Error("the company lists file was accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// This variable stores a company list and the editions of it present in the database
+type CompanyList struct {
+	Slug  string
+	Name  string
+	Years []int
+}
+
+// This variable stores the membership of a company in one edition of a list
+type CompanyListMembership struct {
+	ListSlug    string
+	Year        int
+	CompanyName string
+	Rank        int
+}
+
+// This variable stores the companies that entered or dropped out of a list between an edition and the previous one
+type CompanyListChanges struct {
+	ListSlug     string
+	Year         int
+	PreviousYear int
+	Entered      []string
+	DroppedOut   []string
+}
+
+// This function fetches every configured company list, adds the companies that aren't already present in the database and records their memberships.
+// The lists that cannot be synchronised are logged and skipped, an error is returned when the lists cannot be loaded.
+func syncCompanyLists(ctx context.Context, db *pgxpool.Pool) error {
+	logger := loggerFromContext(ctx)
+
+	err := fillMissingCompanySlugs(ctx, db)
+	if err != nil {
+		logger.Error("An error happened while filling the companies slugs", LOG_ERROR, err)
+	}
+
+	sources, err := loadCompanyListSources(appConfig.Sources.CompanyListsFile)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		listLogger := logger.With("list", source.ListSlug(), "year", source.Year())
+		err = syncCompanyList(withLogger(ctx, listLogger), db, source)
+		if err != nil {
+			listLogger.Error("An error happened while synchronising the list", LOG_ERROR, err)
+		}
+	}
+
+	return refreshTop500Flags(ctx, db)
+}
+
+// This function synchronises one edition of a list with the database and logs the companies that entered or dropped out of it
+func syncCompanyList(ctx context.Context, db *pgxpool.Pool, source CompanyListSource) error {
+	logger := loggerFromContext(ctx)
+
+	entries, err := source.Fetch()
+	if err != nil {
+		return err
+	}
+	logger.Info("The companies of the list have been fetched", "companies", len(entries))
+
+	var companiesNames []string
+	for _, entry := range entries {
+		companiesNames = append(companiesNames, entry.CompanyName)
+	}
+
+	newCompaniesNamesList := checkNewCompaniesList(ctx, db, companiesNames)
+
+	for _, companyName := range newCompaniesNamesList {
+		var company Company
+		company.Name = companyName
+		company.IsTop500 = false
+		company.Website = ""
+		company.LinkedInURL = ""
+		company.WTTJURL = ""
+		company.JobsPageURL = ""
+		err := addCompany(ctx, db, company)
+		if err != nil {
+			logger.Error("An error happened while adding the company to the database", LOG_COMPANY, companyName, LOG_ERROR, err)
+		}
+	}
+
+	err = upsertCompanyList(ctx, db, source.ListSlug(), source.ListName())
+	if err != nil {
+		return err
+	}
+
+	err = replaceCompanyListMemberships(ctx, db, source.ListSlug(), source.Year(), entries)
+	if err != nil {
+		return err
+	}
+
+	changes, err := getCompanyListChanges(ctx, db, source.ListSlug(), source.Year())
+	if err != nil {
+		return err
+	}
+	if changes.PreviousYear != 0 {
+		for _, companyName := range changes.Entered {
+			logger.Info("The company entered the list", LOG_COMPANY, companyName)
+		}
+		for _, companyName := range changes.DroppedOut {
+			logger.Info("The company dropped out of the list", LOG_COMPANY, companyName)
+		}
+	}
+
+	return nil
+}
+
+// Based on a list of companies, returns the list of the companies that aren't already present in the database
+func checkNewCompaniesList(ctx context.Context, db *pgxpool.Pool, newCompaniesNamesList []string) []string {
+	var newCompaniesNamesApprovedList []string
+	for _, newCompanyName := range newCompaniesNamesList {
+		_, exist, err := getCompany(ctx, db, newCompanyName)
+		if err != nil {
+			slog.Error("An error happened with the query", LOG_ERROR, err)
+		}
+
+		if !exist {
+			slog.Info("The company does not exist in the database, it will be created", LOG_COMPANY, newCompanyName)
+			newCompaniesNamesApprovedList = append(newCompaniesNamesApprovedList, newCompanyName)
+		}
+	}
+	return newCompaniesNamesApprovedList
+}
+
+func upsertCompanyList(ctx context.Context, db *pgxpool.Pool, slug string, name string) error {
+	query := `INSERT INTO company_lists (slug, name) VALUES (@slug, @name) ON CONFLICT (slug) DO UPDATE SET name = EXCLUDED.name`
+	args := pgx.NamedArgs{
+		"slug": slug,
+		"name": name,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to upsert row: %w", err)
+	}
+
+	return err
+}
+
+// This function replaces the members of one edition of a list in a single transaction, so a failing fetch never leaves a half written edition
+func replaceCompanyListMemberships(ctx context.Context, db *pgxpool.Pool, slug string, year int, entries []CompanyListEntry) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM company_list_memberships WHERE list_slug = @slug AND edition_year = @year`
+	args := pgx.NamedArgs{
+		"slug": slug,
+		"year": year,
+	}
+	_, err = tx.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to delete rows: %w", err)
+	}
+
+	query = `INSERT INTO company_list_memberships (list_slug, edition_year, company_name, rank) VALUES (@slug, @year, @company_name, @rank) ON CONFLICT DO NOTHING`
+	for _, entry := range entries {
+		args := pgx.NamedArgs{
+			"slug":         slug,
+			"year":         year,
+			"company_name": entry.CompanyName,
+			"rank":         entry.Rank,
+		}
+		_, err = tx.Exec(ctx, query, args)
+		if err != nil {
+			return fmt.Errorf("unable to insert row: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return err
+}
+
+// The is_top_500 flag is kept for the existing consumers, it now means "member of the latest Tech500 edition"
+func refreshTop500Flags(ctx context.Context, db *pgxpool.Pool) error {
+	query := `UPDATE companies SET is_top_500 = EXISTS (
+		SELECT 1 FROM company_list_memberships m
+		WHERE m.company_name = companies.name AND m.list_slug = 'tech500'
+		AND m.edition_year = (SELECT max(edition_year) FROM company_list_memberships WHERE list_slug = 'tech500')
+	)`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to update rows: %w", err)
+	}
+
+	return err
+}
+
+func getCompanyLists(ctx context.Context, db *pgxpool.Pool) []CompanyList {
+	var lists []CompanyList
+
+	query := `SELECT l.slug, l.name, coalesce(array_agg(DISTINCT m.edition_year ORDER BY m.edition_year) FILTER (WHERE m.edition_year IS NOT NULL), '{}')
+		FROM company_lists l LEFT JOIN company_list_memberships m ON m.list_slug = l.slug
+		GROUP BY l.slug, l.name ORDER BY l.slug`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		slog.Error("Database query failed", LOG_ERROR, err)
+		return lists
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var list CompanyList
+		err = rows.Scan(&list.Slug, &list.Name, &list.Years)
+		if err != nil {
+			slog.Error("Database query scan failed", LOG_ERROR, err)
+		}
+		lists = append(lists, list)
+	}
+
+	return lists
+}
+
+// This function returns the most recent edition of a list, or false if the list has no edition yet
+func getLatestCompanyListYear(ctx context.Context, db *pgxpool.Pool, slug string) (int, bool, error) {
+	var year *int
+
+	query := `SELECT max(edition_year) FROM company_list_memberships WHERE list_slug = @slug`
+	args := pgx.NamedArgs{
+		"slug": slug,
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&year)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to query row: %w", err)
+	}
+	if year == nil {
+		return 0, false, nil
+	}
+
+	return *year, true, nil
+}
+
+func getCompanyListMembers(ctx context.Context, db *pgxpool.Pool, slug string, year int) ([]CompanyListMembership, error) {
+	var memberships []CompanyListMembership
+
+	query := `SELECT list_slug, edition_year, company_name, rank FROM company_list_memberships WHERE list_slug = @slug AND edition_year = @year ORDER BY rank, company_name`
+	args := pgx.NamedArgs{
+		"slug": slug,
+		"year": year,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return memberships, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var membership CompanyListMembership
+		err = rows.Scan(&membership.ListSlug, &membership.Year, &membership.CompanyName, &membership.Rank)
+		if err != nil {
+			return memberships, fmt.Errorf("unable to scan row: %w", err)
+		}
+		memberships = append(memberships, membership)
+	}
+
+	return memberships, rows.Err()
+}
+
+// This function compares an edition of a list with the previous edition present in the database
+func getCompanyListChanges(ctx context.Context, db *pgxpool.Pool, slug string, year int) (CompanyListChanges, error) {
+	changes := CompanyListChanges{ListSlug: slug, Year: year}
+
+	var previousYear *int
+	query := `SELECT max(edition_year) FROM company_list_memberships WHERE list_slug = @slug AND edition_year < @year`
+	args := pgx.NamedArgs{
+		"slug": slug,
+		"year": year,
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&previousYear)
+	if err != nil {
+		return changes, fmt.Errorf("unable to query row: %w", err)
+	}
+	if previousYear == nil {
+		return changes, nil
+	}
+	changes.PreviousYear = *previousYear
+
+	current, err := getCompanyListMembers(ctx, db, slug, year)
+	if err != nil {
+		return changes, err
+	}
+	previous, err := getCompanyListMembers(ctx, db, slug, changes.PreviousYear)
+	if err != nil {
+		return changes, err
+	}
+
+	previousNames := make(map[string]bool)
+	for _, membership := range previous {
+		previousNames[membership.CompanyName] = true
+	}
+	currentNames := make(map[string]bool)
+	for _, membership := range current {
+		currentNames[membership.CompanyName] = true
+		if !previousNames[membership.CompanyName] {
+			changes.Entered = append(changes.Entered, membership.CompanyName)
+		}
+	}
+	for _, membership := range previous {
+		if !currentNames[membership.CompanyName] {
+			changes.DroppedOut = append(changes.DroppedOut, membership.CompanyName)
+		}
+	}
+
+	return changes, nil
+}
+
+// This function returns the companies that are members of an edition of a list
+func getCompaniesInList(ctx context.Context, db *pgxpool.Pool, slug string, year int) []Company {
+	var companies []Company
+
+	query := `SELECT ` + COMPANY_COLUMNS + `
+		FROM companies c JOIN company_list_memberships m ON m.company_name = c.name
+		WHERE m.list_slug = @slug AND m.edition_year = @year ORDER BY m.rank, c.name`
+	args := pgx.NamedArgs{
+		"slug": slug,
+		"year": year,
+	}
+
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		slog.Error("Database query failed", LOG_ERROR, err)
+		return companies
+	}
+	defer rows.Close()
+	for rows.Next() {
+		company, err := scanCompany(rows)
+		if err != nil {
+			slog.Error("Database query scan failed", LOG_ERROR, err)
+		}
+		companies = append(companies, company)
+	}
+
+	return companies
+}
+
+// This function reads the year query parameter, it defaults to the latest edition of the list
+func parseCompanyListYear(c *gin.Context, slug string) (int, error) {
+	if c.Query("year") != "" {
+		year, err := strconv.Atoi(c.Query("year"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid year %q", c.Query("year"))
+		}
+		return year, nil
+	}
+
+	year, exists, err := getLatestCompanyListYear(c.Request.Context(), dbpoolapi, slug)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fmt.Errorf("the list %s has no edition", slug)
+	}
+
+	return year, nil
+}
+
+func getCompanyListsAPI(c *gin.Context) {
+	lists := getCompanyLists(c.Request.Context(), dbpoolapi)
+	c.JSON(http.StatusOK, gin.H{"data": lists})
+}
+
+func getCompanyListChangesAPI(c *gin.Context) {
+	slug := c.Param("slug")
+	year, err := parseCompanyListYear(c, slug)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	changes, err := getCompanyListChanges(c.Request.Context(), dbpoolapi, slug, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": changes})
+}
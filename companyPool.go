@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// The stage of the failures that are not the failure of a stage, like a panic out of a stage
+const STAGE_UNKNOWN = "unknown"
+
+// This variable stores the error of a stage of a company
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return e.stage + ": " + e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// This variable stores a panic turned into an error, with the stack of the goroutine that panicked
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// This function turns a panic into the error returned by the function deferring it, so one bad page does not kill the process
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = &panicError{value: r, stack: debug.Stack()}
+	}
+}
+
+// This function returns the stages an error is about, the errors of several stages being joined
+func failedStages(err error) []string {
+	var stages []string
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			stages = append(stages, failedStages(e)...)
+		}
+		return stages
+	}
+
+	var stageErr *stageError
+	if errors.As(err, &stageErr) {
+		return []string{stageErr.stage}
+	}
+	return []string{STAGE_UNKNOWN}
+}
+
+// This variable stores the failure of the work on a company
+type companyFailure struct {
+	company string
+	err     error
+}
+
+// This variable stores the outcome of the companies run by a pool
+type poolSummary struct {
+	started  int
+	failures []companyFailure
+}
+
+// This function returns the number of companies a stage failed on
+func (s poolSummary) failedCompanies(stage string) int {
+	count := 0
+	for _, failure := range s.failures {
+		for _, failedStage := range failedStages(failure.err) {
+			if failedStage == stage {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// This function logs the number of companies run and the companies each stage failed on
+func (s poolSummary) log(logger *slog.Logger, message string) {
+	failed := make(map[string][]string)
+	for _, failure := range s.failures {
+		for _, stage := range failedStages(failure.err) {
+			failed[stage] = append(failed[stage], failure.company)
+		}
+	}
+	for _, companies := range failed {
+		sort.Strings(companies)
+	}
+
+	logger.Info(message, "companies", s.started, "failed_companies", len(s.failures), "failures", failed)
+}
+
+// This variable stores a pool running the work on the companies a bounded number at a time. Each company runs in its goroutine,
+// its error or panic is collected without stopping the others, like an errgroup that does not cancel on the first error.
+type companyPool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	summary poolSummary
+}
+
+func newCompanyPool(size int) *companyPool {
+	return &companyPool{slots: make(chan struct{}, size)}
+}
+
+// This function runs the work on a company once a slot is free. It returns false without running it once the context given is done,
+// no company being started when the crawl is stopping.
+func (p *companyPool) Go(ctx context.Context, companyName string, work func() error) bool {
+	if !acquireSlot(ctx, p.slots) {
+		return false
+	}
+
+	p.mu.Lock()
+	p.summary.started++
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+
+		err := func() (err error) {
+			defer recoverPanic(&err)
+			return work()
+		}()
+		if err == nil {
+			return
+		}
+
+		var panicErr *panicError
+		if errors.As(err, &panicErr) {
+			slog.Error("A panic happened while working on the company", LOG_COMPANY, companyName, LOG_ERROR, err, "stack", string(panicErr.stack))
+		}
+
+		p.mu.Lock()
+		p.summary.failures = append(p.summary.failures, companyFailure{company: companyName, err: err})
+		p.mu.Unlock()
+	}()
+
+	return true
+}
+
+// This function waits for the companies started and returns their outcome
+func (p *companyPool) Wait() poolSummary {
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.summary
+}
+
+// This variable stores the slots of the company stages, so a stage opening browser tabs runs fewer at a time than one calling an API
+var stageSlots struct {
+	once  sync.Once
+	slots map[string]chan struct{}
+}
+
+// This function waits for a slot of a stage and returns the function freeing it, it returns an error if the context is done first.
+// The slot is freed once however many times the function is called, so it never frees the slot of another company.
+func acquireStageSlot(ctx context.Context, stage string) (func(), error) {
+	stageSlots.once.Do(func() {
+		stageSlots.slots = map[string]chan struct{}{
+			STAGE_WEBSITE:   make(chan struct{}, appConfig.Crawl.WebsiteConcurrency),
+			STAGE_WTTJ:      make(chan struct{}, appConfig.Crawl.WTTJConcurrency),
+			STAGE_JOBS_PAGE: make(chan struct{}, appConfig.Crawl.JobsPageConcurrency),
+		}
+	})
+
+	slots, ok := stageSlots.slots[stage]
+	if !ok {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		var release sync.Once
+		return func() { release.Do(func() { <-slots }) }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The time after which a test waiting on the pool is considered stuck
+const POOL_TEST_TIMEOUT = 5 * time.Second
+
+// This function waits for the companies of a pool and fails the test if they do not all end in time
+func waitPool(t *testing.T, pool *companyPool) poolSummary {
+	t.Helper()
+
+	done := make(chan poolSummary)
+	go func() { done <- pool.Wait() }()
+	select {
+	case summary := <-done:
+		return summary
+	case <-time.After(POOL_TEST_TIMEOUT):
+		t.Fatal("the companies of the pool did not end")
+		return poolSummary{}
+	}
+}
+
+func TestCompanyPoolBoundsConcurrency(t *testing.T) {
+	const size = 3
+	const companies = 30
+
+	pool := newCompanyPool(size)
+	var running, maxRunning atomic.Int32
+	for i := 0; i < companies; i++ {
+		i := i
+		started := pool.Go(context.Background(), fmt.Sprintf("company %d", i), func() error {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				max := maxRunning.Load()
+				if current <= max || maxRunning.CompareAndSwap(max, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			if i%5 == 0 {
+				return errors.New("the jobs page cannot be read")
+			}
+			return nil
+		})
+		if !started {
+			t.Fatalf("the company %d was not started", i)
+		}
+	}
+	summary := waitPool(t, pool)
+
+	if got := maxRunning.Load(); got > size {
+		t.Errorf("%d companies ran at the same time, the pool allows %d", got, size)
+	}
+	if summary.started != companies {
+		t.Errorf("%d companies were started, want %d", summary.started, companies)
+	}
+	if len(summary.failures) != companies/5 {
+		t.Errorf("%d failures were collected, want %d", len(summary.failures), companies/5)
+	}
+	if len(pool.slots) != 0 {
+		t.Errorf("%d slots are still taken once the companies ended", len(pool.slots))
+	}
+}
+
+func TestCompanyPoolCancellationDrains(t *testing.T) {
+	const size = 2
+
+	pool := newCompanyPool(size)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The companies running hold their slot until they see the crawl stopped
+	var ended atomic.Int32
+	stopped := make(chan struct{})
+	for i := 0; i < size; i++ {
+		pool.Go(ctx, fmt.Sprintf("company %d", i), func() error {
+			defer ended.Add(1)
+			<-stopped
+			return ctx.Err()
+		})
+	}
+
+	// A company waiting for a slot is not started once the crawl stops
+	waiting := make(chan bool)
+	go func() {
+		waiting <- pool.Go(ctx, "waiting company", func() error {
+			t.Error("a company was started after the crawl stopped")
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case started := <-waiting:
+		if started {
+			t.Error("the company waiting for a slot was started after the cancellation")
+		}
+	case <-time.After(POOL_TEST_TIMEOUT):
+		t.Fatal("the company waiting for a slot was not refused after the cancellation")
+	}
+	close(stopped)
+	for i := 0; i < 100; i++ {
+		if pool.Go(ctx, "late company", func() error { return nil }) {
+			t.Fatal("a company was started after the cancellation")
+		}
+	}
+
+	summary := waitPool(t, pool)
+	if ended.Load() != size {
+		t.Errorf("%d companies ended, want %d", ended.Load(), size)
+	}
+	if summary.started != size {
+		t.Errorf("%d companies were started, want %d", summary.started, size)
+	}
+	for _, failure := range summary.failures {
+		if !errors.Is(failure.err, context.Canceled) {
+			t.Errorf("the company %s failed with %v, want the cancellation", failure.company, failure.err)
+		}
+	}
+	if len(pool.slots) != 0 {
+		t.Errorf("%d slots are still taken once the pool drained", len(pool.slots))
+	}
+}
+
+func TestCompanyPoolReleasesSlotsOnce(t *testing.T) {
+	const size = 2
+
+	pool := newCompanyPool(size)
+	works := []func() error{
+		func() error { return nil },
+		func() error { return errors.New("the offers cannot be added") },
+		func() error { panic("unexpected page") },
+	}
+	for round := 0; round < 10; round++ {
+		for i, work := range works {
+			pool.Go(context.Background(), fmt.Sprintf("company %d-%d", round, i), work)
+		}
+	}
+	summary := waitPool(t, pool)
+
+	if len(summary.failures) != 20 {
+		t.Errorf("%d failures were collected, want 20", len(summary.failures))
+	}
+	var panicErr *panicError
+	panics := 0
+	for _, failure := range summary.failures {
+		if errors.As(failure.err, &panicErr) {
+			panics++
+		}
+	}
+	if panics != 10 {
+		t.Errorf("%d panics were collected, want 10", panics)
+	}
+
+	// Every slot is free again, and only once : the pool still runs size companies at a time
+	if len(pool.slots) != 0 {
+		t.Fatalf("%d slots are still taken once the companies ended", len(pool.slots))
+	}
+	for i := 0; i < size; i++ {
+		pool.slots <- struct{}{}
+	}
+	select {
+	case pool.slots <- struct{}{}:
+		t.Error("the pool has more slots than its size")
+	default:
+	}
+}
+
+func TestStageSlotsReleasedOnce(t *testing.T) {
+	appConfig = defaultConfig()
+	appConfig.Crawl.JobsPageConcurrency = 2
+	stageSlots.once = sync.Once{}
+	stageSlots.slots = nil
+
+	ctx := context.Background()
+	release, err := acquireStageSlot(ctx, STAGE_JOBS_PAGE)
+	if err != nil {
+		t.Fatalf("unable to acquire a slot: %v", err)
+	}
+	other, err := acquireStageSlot(ctx, STAGE_JOBS_PAGE)
+	if err != nil {
+		t.Fatalf("unable to acquire a slot: %v", err)
+	}
+
+	// Releasing a slot twice does not free the slot of the other company
+	release()
+	release()
+	if taken := len(stageSlots.slots[STAGE_JOBS_PAGE]); taken != 1 {
+		t.Errorf("%d slots are taken after a double release, want 1", taken)
+	}
+	other()
+
+	// Concurrent companies never hold more slots than the stage allows
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := acquireStageSlot(ctx, STAGE_JOBS_PAGE)
+			if err != nil {
+				t.Errorf("unable to acquire a slot: %v", err)
+				return
+			}
+			defer release()
+			current := running.Add(1)
+			for {
+				max := maxRunning.Load()
+				if current <= max || maxRunning.CompareAndSwap(max, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			release()
+		}()
+	}
+	wg.Wait()
+	if got := maxRunning.Load(); got > 2 {
+		t.Errorf("%d companies held a slot at the same time, the stage allows 2", got)
+	}
+	if taken := len(stageSlots.slots[STAGE_JOBS_PAGE]); taken != 0 {
+		t.Errorf("%d slots are still taken once the companies ended", taken)
+	}
+
+	// A company waiting for a slot gives up when its context is done
+	full, cancel := context.WithCancel(ctx)
+	first, _ := acquireStageSlot(full, STAGE_JOBS_PAGE)
+	second, _ := acquireStageSlot(full, STAGE_JOBS_PAGE)
+	cancel()
+	if _, err := acquireStageSlot(full, STAGE_JOBS_PAGE); !errors.Is(err, context.Canceled) {
+		t.Errorf("waiting for a slot of a full stage returned %v, want the cancellation", err)
+	}
+	first()
+	second()
+}
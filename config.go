@@ -0,0 +1,666 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The configuration file read when neither the -config flag nor the FTJ_CONFIG environment variable is given, it is optional
+const DEFAULT_CONFIG_FILE = "config.yaml"
+
+// This variable stores the configuration of the crawler and the API server.
+// Each setting is read, from the lowest to the highest priority, from its default, the configuration file,
+// its environment variable (env tag) and its flag, named after its path in the file (-crawl.max_concurrent_jobs).
+type Config struct {
+	Database   DatabaseConfig   `yaml:"database"`
+	Server     ServerConfig     `yaml:"server"`
+	Crawl      CrawlConfig      `yaml:"crawl"`
+	Worker     WorkerConfig     `yaml:"worker"`
+	Politeness PolitenessConfig `yaml:"politeness"`
+	Readiness  ReadinessConfig  `yaml:"readiness"`
+	Pagination PaginationConfig `yaml:"pagination"`
+	Browser    BrowserConfig    `yaml:"browser"`
+	Artifacts  ArtifactsConfig  `yaml:"artifacts"`
+	Crunchbase CrunchbaseConfig `yaml:"crunchbase"`
+	SMTP       SMTPConfig       `yaml:"smtp"`
+	Sources    SourcesConfig    `yaml:"sources"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+
+	// The warnings about the configuration, logged once the logger is set
+	warnings []string
+}
+
+// This variable stores the database settings, the DSN is built from the other fields when it is not given
+type DatabaseConfig struct {
+	DSN      string `yaml:"dsn" env:"FTJ_DATABASE_DSN" help:"postgres connection string, replaces the other database settings"`
+	Host     string `yaml:"host" env:"FTJ_DATABASE_HOST" help:"database host"`
+	Port     string `yaml:"port" env:"FTJ_DATABASE_PORT" help:"database port"`
+	User     string `yaml:"user" env:"FTJ_DATABASE_USER" help:"database user"`
+	Password string `yaml:"password" env:"FTJ_DATABASE_PASSWORD" help:"database password"`
+	Name     string `yaml:"name" env:"FTJ_DATABASE_NAME" help:"database name"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr" env:"FTJ_SERVER_ADDR" help:"address the API server listens on"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"FTJ_SERVER_SHUTDOWN_TIMEOUT" help:"time given to the requests in progress when the server is stopped"`
+	PublicURL       string        `yaml:"public_url" env:"FTJ_SERVER_PUBLIC_URL" help:"url the clients reach the API server at, used in the links of the feeds"`
+	TrustedProxies  string        `yaml:"trusted_proxies" env:"FTJ_SERVER_TRUSTED_PROXIES" help:"comma separated addresses or CIDR ranges of the reverse proxies whose X-Forwarded headers are trusted"`
+}
+
+type CrawlConfig struct {
+	MaxConcurrentJobs   int           `yaml:"max_concurrent_jobs" env:"FTJ_CRAWL_MAX_CONCURRENT_JOBS" help:"companies enriched at the same time"`
+	MaxConcurrentOffers int           `yaml:"max_concurrent_offers" env:"FTJ_CRAWL_MAX_CONCURRENT_OFFERS" help:"jobs pages searched for offers at the same time"`
+	WebsiteConcurrency  int           `yaml:"website_concurrency" env:"FTJ_CRAWL_WEBSITE_CONCURRENCY" help:"companies whose website and linkedin urls are searched on crunchbase at the same time"`
+	WTTJConcurrency     int           `yaml:"wttj_concurrency" env:"FTJ_CRAWL_WTTJ_CONCURRENCY" help:"companies searched on Welcome to the Jungle at the same time, each in a browser tab"`
+	JobsPageConcurrency int           `yaml:"jobs_page_concurrency" env:"FTJ_CRAWL_JOBS_PAGE_CONCURRENCY" help:"company websites searched for their jobs page at the same time"`
+	NavigationRetries   int           `yaml:"navigation_retries" env:"FTJ_CRAWL_NAVIGATION_RETRIES" help:"attempts to fetch a page"`
+	NavigationTimeout   time.Duration `yaml:"navigation_timeout" env:"FTJ_CRAWL_NAVIGATION_TIMEOUT" help:"time given to an attempt to fetch a page, its waits included"`
+	RetryBaseDelay      time.Duration `yaml:"retry_base_delay" env:"FTJ_CRAWL_RETRY_BASE_DELAY" help:"time waited before the first retry of a page, doubled at each retry"`
+	RetryMaxDelay       time.Duration `yaml:"retry_max_delay" env:"FTJ_CRAWL_RETRY_MAX_DELAY" help:"longest time waited before retrying a page"`
+	BreakerFailures     int           `yaml:"breaker_failures" env:"FTJ_CRAWL_BREAKER_FAILURES" help:"fetches in a row, of at least two pages, finding a domain down before its pages are no longer fetched"`
+	BreakerCooldown     time.Duration `yaml:"breaker_cooldown" env:"FTJ_CRAWL_BREAKER_COOLDOWN" help:"time before a domain found down is tried again"`
+	JobsPageWait        time.Duration `yaml:"jobs_page_wait" env:"FTJ_CRAWL_JOBS_PAGE_WAIT" help:"time given to a jobs page to load by the sleep readiness strategy"`
+	CareersPageWait     time.Duration `yaml:"careers_page_wait" env:"FTJ_CRAWL_CAREERS_PAGE_WAIT" help:"time given to a company website to load when its careers page is searched by the sleep readiness strategy"`
+	WTTJWait            time.Duration `yaml:"wttj_wait" env:"FTJ_CRAWL_WTTJ_WAIT" help:"time given to a Welcome to the Jungle page to load by the sleep readiness strategy"`
+	OfferFetchTimeout   time.Duration `yaml:"offer_fetch_timeout" env:"FTJ_CRAWL_OFFER_FETCH_TIMEOUT" help:"timeout of the download of an offer page"`
+	ShutdownTimeout     time.Duration `yaml:"shutdown_timeout" env:"FTJ_CRAWL_SHUTDOWN_TIMEOUT" help:"time given to the companies in progress when the crawl is stopped"`
+	ResumeWindow        time.Duration `yaml:"resume_window" env:"FTJ_CRAWL_RESUME_WINDOW" help:"age under which the stages a company went through are skipped by a resumed crawl"`
+}
+
+// This variable stores the settings of the workers consuming the tasks queue
+type WorkerConfig struct {
+	Concurrency       int           `yaml:"concurrency" env:"FTJ_WORKER_CONCURRENCY" help:"tasks run at the same time by a worker"`
+	PollInterval      time.Duration `yaml:"poll_interval" env:"FTJ_WORKER_POLL_INTERVAL" help:"time waited before looking for tasks again when the queue is empty"`
+	LeaseDuration     time.Duration `yaml:"lease_duration" env:"FTJ_WORKER_LEASE_DURATION" help:"time after which a task whose worker stopped sending heartbeats is given to another worker"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env:"FTJ_WORKER_HEARTBEAT_INTERVAL" help:"time between the extensions of the lease of a running task"`
+	MaxAttempts       int           `yaml:"max_attempts" env:"FTJ_WORKER_MAX_ATTEMPTS" help:"attempts of a task before it is marked as failed"`
+	RetryDelay        time.Duration `yaml:"retry_delay" env:"FTJ_WORKER_RETRY_DELAY" help:"time before a failed task is retried, multiplied by the number of attempts"`
+}
+
+// This variable stores the limits of the requests sent to each host, shared by the browser tabs and the http requests
+type PolitenessConfig struct {
+	HostConcurrency    int           `yaml:"host_concurrency" env:"FTJ_POLITENESS_HOST_CONCURRENCY" help:"requests sent to a host at the same time"`
+	HostInterval       time.Duration `yaml:"host_interval" env:"FTJ_POLITENESS_HOST_INTERVAL" help:"minimum time between the starts of two requests to a host"`
+	RespectRobots      bool          `yaml:"respect_robots" env:"FTJ_POLITENESS_RESPECT_ROBOTS" help:"skip the pages disallowed by the robots.txt of their host and follow its Crawl-delay"`
+	RobotsUserAgent    string        `yaml:"robots_user_agent" env:"FTJ_POLITENESS_ROBOTS_USER_AGENT" help:"user agent the robots.txt rules are read for"`
+	RobotsTimeout      time.Duration `yaml:"robots_timeout" env:"FTJ_POLITENESS_ROBOTS_TIMEOUT" help:"timeout of the download of a robots.txt"`
+	MaxCrawlDelay      time.Duration `yaml:"max_crawl_delay" env:"FTJ_POLITENESS_MAX_CRAWL_DELAY" help:"longest Crawl-delay of a robots.txt followed"`
+	MaxPagesPerCompany int           `yaml:"max_pages_per_company" env:"FTJ_POLITENESS_MAX_PAGES_PER_COMPANY" help:"pages fetched for a company in a run, 0 for no limit"`
+}
+
+// This variable stores how the crawler tells that a page opened in the browser is ready to be read, by default and by domain
+type ReadinessConfig struct {
+	Strategy string        `yaml:"strategy" env:"FTJ_READINESS_STRATEGY" help:"default readiness strategy: network_idle, stable_links, selector or sleep"`
+	Selector string        `yaml:"selector" env:"FTJ_READINESS_SELECTOR" help:"css selector waited for by the selector strategy"`
+	Timeout  time.Duration `yaml:"timeout" env:"FTJ_READINESS_TIMEOUT" help:"longest wait for a page to be ready, it is read as it is after"`
+	IdleTime time.Duration `yaml:"idle_time" env:"FTJ_READINESS_IDLE_TIME" help:"time without new request or link after which a page is ready"`
+	// The rules of the pages of a host or a domain, written without www, only read from the configuration file
+	Domains map[string]ReadinessRule `yaml:"domains"`
+}
+
+// This variable stores the readiness of the pages of a domain, its timeout and idle time default to the ones of the readiness section
+type ReadinessRule struct {
+	Strategy string        `yaml:"strategy"`
+	Selector string        `yaml:"selector"`
+	Timeout  time.Duration `yaml:"timeout"`
+	IdleTime time.Duration `yaml:"idle_time"`
+}
+
+// This variable stores how the jobs pages are expanded to show all their offers
+type PaginationConfig struct {
+	MaxScrolls       int    `yaml:"max_scrolls" env:"FTJ_PAGINATION_MAX_SCROLLS" help:"times a jobs page is scrolled down or its load more button clicked, 0 to read it as it loads"`
+	MaxPages         int    `yaml:"max_pages" env:"FTJ_PAGINATION_MAX_PAGES" help:"pages of a jobs list read, its next pages and embedded lists included"`
+	LoadMorePattern  string `yaml:"load_more_pattern" env:"FTJ_PAGINATION_LOAD_MORE_PATTERN" help:"case insensitive regular expression matching the text of the load more buttons"`
+	LoadMoreSelector string `yaml:"load_more_selector" env:"FTJ_PAGINATION_LOAD_MORE_SELECTOR" help:"css selector of the load more buttons, replaces load_more_pattern"`
+}
+
+type BrowserConfig struct {
+	RemoteURL        string `yaml:"remote_url" env:"FTJ_BROWSER_REMOTE_URL" help:"DevTools url of a running chrome to connect to instead of launching one, like ws://chrome:9222 or http://chrome:9222"`
+	Proxy            string `yaml:"proxy" env:"FTJ_BROWSER_PROXY" help:"comma separated http, https or socks5 proxies the pages are fetched through, used in turn when there are several"`
+	UserAgent        string `yaml:"user_agent" env:"FTJ_BROWSER_USER_AGENT" help:"user agent of the headless browser"`
+	Locale           string `yaml:"locale" env:"FTJ_BROWSER_LOCALE" help:"locale of the browser, empty to keep the one of the system"`
+	AcceptLanguage   string `yaml:"accept_language" env:"FTJ_BROWSER_ACCEPT_LANGUAGE" help:"Accept-Language header of the pages requests"`
+	Headless         bool   `yaml:"headless" env:"FTJ_BROWSER_HEADLESS" help:"run the browser without window"`
+	WindowWidth      int    `yaml:"window_width" env:"FTJ_BROWSER_WINDOW_WIDTH" help:"width of the browser window"`
+	WindowHeight     int    `yaml:"window_height" env:"FTJ_BROWSER_WINDOW_HEIGHT" help:"height of the browser window"`
+	Tabs             int    `yaml:"tabs" env:"FTJ_BROWSER_TABS" help:"tabs open at a time in the browser, shared by the pages crawled"`
+	TabMaxUses       int    `yaml:"tab_max_uses" env:"FTJ_BROWSER_TAB_MAX_USES" help:"pages a tab opens before being closed and replaced by a new one"`
+	BlockedResources string `yaml:"blocked_resources" env:"FTJ_BROWSER_BLOCKED_RESOURCES" help:"comma separated resource types the pages do not load, like image, font or media"`
+	BlockedDomains   string `yaml:"blocked_domains" env:"FTJ_BROWSER_BLOCKED_DOMAINS" help:"comma separated domains the pages do not load anything from, like the trackers"`
+	MaxMemoryMB      int    `yaml:"max_memory_mb" env:"FTJ_BROWSER_MAX_MEMORY_MB" help:"memory of the browser processes above which it is restarted, 0 to never restart it"`
+}
+
+// This variable stores the settings of the artifacts, the screenshot, HTML and console errors of the pages captured in the database
+type ArtifactsConfig struct {
+	OnFailure bool          `yaml:"on_failure" env:"FTJ_ARTIFACTS_ON_FAILURE" help:"capture the artifacts of the pages the crawler failed to read"`
+	OnSuccess bool          `yaml:"on_success" env:"FTJ_ARTIFACTS_ON_SUCCESS" help:"capture the artifacts of the pages the crawler read too"`
+	MaxPerRun int           `yaml:"max_per_run" env:"FTJ_ARTIFACTS_MAX_PER_RUN" help:"pages whose artifacts are captured by crawl, or by task of a worker"`
+	Retention time.Duration `yaml:"retention" env:"FTJ_ARTIFACTS_RETENTION" help:"time the artifacts are kept, they are deleted at the start of each crawl and every hour by the workers"`
+}
+
+// This variable stores the crunchbase settings, the website and linkedin urls are not enriched without API key
+type CrunchbaseConfig struct {
+	APIKey        string        `yaml:"api_key" env:"FTJ_CRUNCHBASE_API_KEY" help:"crunchbase API key"`
+	SearchURL     string        `yaml:"search_url" env:"FTJ_CRUNCHBASE_SEARCH_URL" help:"crunchbase organization search endpoint"`
+	LocationID    string        `yaml:"location_id" env:"FTJ_CRUNCHBASE_LOCATION_ID" help:"crunchbase location the organizations are searched in"`
+	RateLimitWait time.Duration `yaml:"rate_limit_wait" env:"FTJ_CRUNCHBASE_RATE_LIMIT_WAIT" help:"time waited when the crunchbase quota is reached"`
+}
+
+// This variable stores the smtp server settings, the emails are not sent without host
+type SMTPConfig struct {
+	Host     string `yaml:"host" env:"FTJ_SMTP_HOST" help:"smtp server host"`
+	Port     string `yaml:"port" env:"FTJ_SMTP_PORT" help:"smtp server port"`
+	Username string `yaml:"username" env:"FTJ_SMTP_USERNAME" help:"smtp username"`
+	Password string `yaml:"password" env:"FTJ_SMTP_PASSWORD" help:"smtp password"`
+	From     string `yaml:"from" env:"FTJ_SMTP_FROM" help:"sender of the emails"`
+}
+
+type SourcesConfig struct {
+	WTTJURL          string `yaml:"wttj_url" env:"FTJ_SOURCES_WTTJ_URL" help:"Welcome to the Jungle website"`
+	CompanyListsFile string `yaml:"company_lists_file" env:"FTJ_SOURCES_COMPANY_LISTS_FILE" help:"file declaring the company lists"`
+}
+
+type LoggingConfig struct {
+	Level string `yaml:"level" env:"FTJ_LOG_LEVEL" help:"log level: debug, info, warn or error"`
+}
+
+type MetricsConfig struct {
+	Addr           string `yaml:"addr" env:"FTJ_METRICS_ADDR" help:"address the crawl metrics are served on while it runs"`
+	PushgatewayURL string `yaml:"pushgateway_url" env:"FTJ_METRICS_PUSHGATEWAY_URL" help:"pushgateway the crawl metrics are pushed to"`
+}
+
+// This variable stores the configuration of the process, it holds the defaults until loadConfig is called
+var appConfig = defaultConfig()
+
+// This function returns the configuration used when nothing is set
+func defaultConfig() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Port: "5432",
+		},
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Crawl: CrawlConfig{
+			MaxConcurrentJobs:   20,
+			MaxConcurrentOffers: 40,
+			WebsiteConcurrency:  20,
+			WTTJConcurrency:     5,
+			JobsPageConcurrency: 10,
+			NavigationRetries:   5,
+			NavigationTimeout:   time.Minute,
+			RetryBaseDelay:      time.Second,
+			RetryMaxDelay:       30 * time.Second,
+			BreakerFailures:     5,
+			BreakerCooldown:     5 * time.Minute,
+			JobsPageWait:        4 * time.Second,
+			CareersPageWait:     2 * time.Second,
+			WTTJWait:            4 * time.Second,
+			OfferFetchTimeout:   30 * time.Second,
+			ShutdownTimeout:     time.Minute,
+			ResumeWindow:        24 * time.Hour,
+		},
+		Worker: WorkerConfig{
+			Concurrency:       5,
+			PollInterval:      5 * time.Second,
+			LeaseDuration:     5 * time.Minute,
+			HeartbeatInterval: time.Minute,
+			MaxAttempts:       3,
+			RetryDelay:        time.Minute,
+		},
+		Politeness: PolitenessConfig{
+			HostConcurrency:    2,
+			HostInterval:       time.Second,
+			RespectRobots:      true,
+			RobotsUserAgent:    "french-top-jobs",
+			RobotsTimeout:      10 * time.Second,
+			MaxCrawlDelay:      30 * time.Second,
+			MaxPagesPerCompany: 100,
+		},
+		Readiness: ReadinessConfig{
+			Strategy: READINESS_STABLE_LINKS,
+			Timeout:  15 * time.Second,
+			IdleTime: 500 * time.Millisecond,
+			Domains: map[string]ReadinessRule{
+				// The pages of Welcome to the Jungle are rendered by scripts loading their content
+				"welcometothejungle.com": {Strategy: READINESS_NETWORK_IDLE, Timeout: 20 * time.Second},
+			},
+		},
+		Pagination: PaginationConfig{
+			MaxScrolls:      20,
+			MaxPages:        10,
+			LoadMorePattern: `(voir|afficher|charger) plus|plus d'offres|load more|show more|see more|view more|more jobs`,
+		},
+		Browser: BrowserConfig{
+			UserAgent:        "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/115.0.0.0 Safari/537.36",
+			Locale:           "fr-FR",
+			AcceptLanguage:   "fr-FR,fr;q=0.9,en;q=0.8",
+			Headless:         true,
+			WindowWidth:      1920,
+			WindowHeight:     1080,
+			Tabs:             10,
+			TabMaxUses:       50,
+			BlockedResources: "image,font,media",
+			BlockedDomains:   "google-analytics.com,googletagmanager.com,doubleclick.net,googlesyndication.com,facebook.net,hotjar.com,segment.io,mixpanel.com,clarity.ms,bat.bing.com,ads.linkedin.com,criteo.com",
+			MaxMemoryMB:      2048,
+		},
+		Artifacts: ArtifactsConfig{
+			OnFailure: true,
+			MaxPerRun: 100,
+			Retention: 7 * 24 * time.Hour,
+		},
+		Crunchbase: CrunchbaseConfig{
+			SearchURL:     "https://api.crunchbase.com/api/v4/searches/organizations",
+			LocationID:    "f134827e-36a1-fd31-a82f-950489e103ef",
+			RateLimitWait: 50 * time.Second,
+		},
+		Sources: SourcesConfig{
+			WTTJURL:          "https://www.welcometothejungle.com",
+			CompanyListsFile: "company-lists.yaml",
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+	}
+}
+
+// This function loads the configuration of a command from its arguments, and returns the arguments left after the flags.
+// The flags that are not settings, only used by the command, are registered by commandFlags.
+func loadConfig(command string, args []string, commandFlags func(flags *flag.FlagSet)) (Config, []string, error) {
+	config := defaultConfig()
+
+	name := "french-top-jobs"
+	if command != "" {
+		name += " " + command
+	}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := flags.String("config", "", "configuration file, "+DEFAULT_CONFIG_FILE+" by default")
+	if commandFlags != nil {
+		commandFlags(flags)
+	}
+
+	// The flags are applied once the file and the environment are read, they have the last word
+	type override struct {
+		path  string
+		value string
+	}
+	var overrides []override
+	walkConfig(&config, func(path string, field reflect.StructField, _ reflect.Value) {
+		usage := field.Tag.Get("help")
+		if env := field.Tag.Get("env"); env != "" {
+			usage += " (" + env + ")"
+		}
+		set := func(value string) error {
+			overrides = append(overrides, override{path: path, value: value})
+			return nil
+		}
+		if field.Type.Kind() == reflect.Bool {
+			flags.BoolFunc(path, usage, set)
+		} else {
+			flags.Func(path, usage, set)
+		}
+	})
+	err := flags.Parse(args)
+	if err != nil {
+		return config, nil, err
+	}
+
+	err = loadLegacySecrets(&config)
+	if err != nil {
+		return config, nil, err
+	}
+
+	err = loadConfigFile(&config, *configPath)
+	if err != nil {
+		return config, nil, err
+	}
+
+	var errs []error
+	walkConfig(&config, func(path string, field reflect.StructField, value reflect.Value) {
+		env := field.Tag.Get("env")
+		raw, ok := os.LookupEnv(env)
+		if env == "" || !ok || raw == "" {
+			return
+		}
+		err := setConfigValue(value, raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", env, err))
+		}
+	})
+	for _, o := range overrides {
+		walkConfig(&config, func(path string, _ reflect.StructField, value reflect.Value) {
+			if path != o.path {
+				return
+			}
+			err := setConfigValue(value, o.value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid -%s: %w", path, err))
+			}
+		})
+	}
+	if len(errs) > 0 {
+		return config, nil, errors.Join(errs...)
+	}
+
+	err = config.validate()
+	if err != nil {
+		return config, nil, err
+	}
+
+	return config, flags.Args(), nil
+}
+
+// This function reads the configuration file given, or the default one if it exists
+func loadConfigFile(config *Config, path string) error {
+	if path == "" {
+		path = os.Getenv("FTJ_CONFIG")
+	}
+	required := path != ""
+	if !required {
+		path = DEFAULT_CONFIG_FILE
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the configuration file: %w", err)
+	}
+
+	// Unknown settings are refused, a typo would otherwise silently leave the default
+	err = yaml.UnmarshalStrict(content, config)
+	if err != nil {
+		return fmt.Errorf("unable to decode the configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// This function reads the secrets files used before the configuration file, so the existing deployments keep working.
+// Their settings are overridden by the configuration file, the environment and the flags.
+func loadLegacySecrets(config *Config) error {
+	legacyFiles := []struct {
+		path  string
+		apply func(data map[string]string)
+	}{
+		{"secrets/db-infos.yaml", func(data map[string]string) {
+			config.Database.Host = data["psql_db_host"]
+			config.Database.Port = data["psql_db_port"]
+			config.Database.User = data["psql_db_user"]
+			config.Database.Password = data["psql_db_password"]
+			config.Database.Name = data["psql_db_name"]
+		}},
+		{"secrets/crunchbase-api-key.yaml", func(data map[string]string) {
+			config.Crunchbase.APIKey = data["api_key"]
+		}},
+		{"secrets/smtp.yaml", func(data map[string]string) {
+			config.SMTP.Host = data["smtp_host"]
+			config.SMTP.Port = data["smtp_port"]
+			config.SMTP.Username = data["smtp_username"]
+			config.SMTP.Password = data["smtp_password"]
+			config.SMTP.From = data["smtp_from"]
+		}},
+	}
+
+	for _, legacy := range legacyFiles {
+		content, err := os.ReadFile(legacy.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", legacy.path, err)
+		}
+
+		var data map[string]string
+		err = yaml.Unmarshal(content, &data)
+		if err != nil {
+			return fmt.Errorf("unable to decode %s: %w", legacy.path, err)
+		}
+		legacy.apply(data)
+		config.warnings = append(config.warnings, legacy.path+" is deprecated, its settings should be moved to the configuration file")
+	}
+
+	return nil
+}
+
+// This function calls a function for each setting of the configuration, with its path in the configuration file
+func walkConfig(config *Config, fn func(path string, field reflect.StructField, value reflect.Value)) {
+	var walk func(value reflect.Value, prefix string)
+	walk = func(value reflect.Value, prefix string) {
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			path := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				walk(value.Field(i), path+".")
+				continue
+			}
+			// The maps are only read from the configuration file
+			if field.Type.Kind() == reflect.Map {
+				continue
+			}
+			fn(path, field, value.Field(i))
+		}
+	}
+	walk(reflect.ValueOf(config).Elem(), "")
+}
+
+// This function sets a setting from its text in an environment variable or a flag
+func setConfigValue(value reflect.Value, raw string) error {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(number))
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(boolean)
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+
+	return nil
+}
+
+// This function checks the configuration, all the problems are returned at once
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Database.DSN != "" || (c.Database.Host != "" && c.Database.Name != ""), "database.dsn, or database.host and database.name, are required")
+	if c.Database.DSN != "" {
+		_, err := url.Parse(c.Database.DSN)
+		check(err == nil, "database.dsn is not a valid url: %v", err)
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.Addr)
+	check(err == nil, "server.addr must be a host:port address: %v", err)
+	check(c.Server.ShutdownTimeout >= 0, "server.shutdown_timeout cannot be negative")
+	check(c.Server.PublicURL == "" || isAbsoluteURL(c.Server.PublicURL), "server.public_url must be an absolute url")
+	for _, proxy := range c.Server.trustedProxies() {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "server.trusted_proxies has the invalid address %q", proxy)
+	}
+
+	check(c.Crawl.MaxConcurrentJobs >= 1, "crawl.max_concurrent_jobs must be at least 1")
+	check(c.Crawl.MaxConcurrentOffers >= 1, "crawl.max_concurrent_offers must be at least 1")
+	check(c.Crawl.WebsiteConcurrency >= 1, "crawl.website_concurrency must be at least 1")
+	check(c.Crawl.WTTJConcurrency >= 1, "crawl.wttj_concurrency must be at least 1")
+	check(c.Crawl.JobsPageConcurrency >= 1, "crawl.jobs_page_concurrency must be at least 1")
+	check(c.Crawl.NavigationRetries >= 1, "crawl.navigation_retries must be at least 1")
+	check(c.Crawl.NavigationTimeout > 0, "crawl.navigation_timeout must be positive")
+	check(c.Crawl.RetryBaseDelay >= 0 && c.Crawl.RetryBaseDelay <= c.Crawl.RetryMaxDelay, "crawl.retry_base_delay cannot be negative nor longer than crawl.retry_max_delay")
+	check(c.Crawl.BreakerFailures >= 1, "crawl.breaker_failures must be at least 1")
+	check(c.Crawl.BreakerCooldown >= 0, "crawl.breaker_cooldown cannot be negative")
+	check(c.Crawl.JobsPageWait >= 0, "crawl.jobs_page_wait cannot be negative")
+	check(c.Crawl.CareersPageWait >= 0, "crawl.careers_page_wait cannot be negative")
+	check(c.Crawl.WTTJWait >= 0, "crawl.wttj_wait cannot be negative")
+	check(c.Crawl.OfferFetchTimeout > 0, "crawl.offer_fetch_timeout must be positive")
+	check(c.Crawl.ShutdownTimeout >= 0, "crawl.shutdown_timeout cannot be negative")
+	check(c.Crawl.ResumeWindow >= 0, "crawl.resume_window cannot be negative")
+
+	check(c.Worker.Concurrency >= 1, "worker.concurrency must be at least 1")
+	check(c.Worker.PollInterval > 0, "worker.poll_interval must be positive")
+	check(c.Worker.HeartbeatInterval > 0 && c.Worker.HeartbeatInterval < c.Worker.LeaseDuration, "worker.heartbeat_interval must be positive and shorter than worker.lease_duration")
+	check(c.Worker.MaxAttempts >= 1, "worker.max_attempts must be at least 1")
+	check(c.Worker.RetryDelay >= 0, "worker.retry_delay cannot be negative")
+
+	check(c.Politeness.HostConcurrency >= 1, "politeness.host_concurrency must be at least 1")
+	check(c.Politeness.HostInterval >= 0, "politeness.host_interval cannot be negative")
+	check(c.Politeness.RobotsUserAgent != "", "politeness.robots_user_agent is required")
+	check(c.Politeness.RobotsTimeout > 0, "politeness.robots_timeout must be positive")
+	check(c.Politeness.MaxCrawlDelay >= 0, "politeness.max_crawl_delay cannot be negative")
+	check(c.Politeness.MaxPagesPerCompany >= 0, "politeness.max_pages_per_company cannot be negative")
+
+	checkReadinessRule := func(name string, rule ReadinessRule) {
+		switch rule.Strategy {
+		case READINESS_NETWORK_IDLE, READINESS_STABLE_LINKS, READINESS_SLEEP:
+		case READINESS_SELECTOR:
+			check(rule.Selector != "", "%s.selector is required by the selector strategy", name)
+		default:
+			check(false, "%s.strategy must be network_idle, stable_links, selector or sleep, not %q", name, rule.Strategy)
+		}
+		check(rule.Timeout >= 0 && rule.Timeout < c.Crawl.NavigationTimeout, "%s.timeout cannot be negative and must be shorter than crawl.navigation_timeout", name)
+		check(rule.IdleTime >= 0, "%s.idle_time cannot be negative", name)
+	}
+	checkReadinessRule("readiness", ReadinessRule{Strategy: c.Readiness.Strategy, Selector: c.Readiness.Selector, Timeout: c.Readiness.Timeout, IdleTime: c.Readiness.IdleTime})
+	check(c.Readiness.Timeout > 0, "readiness.timeout must be positive")
+	for domain, rule := range c.Readiness.Domains {
+		checkReadinessRule("readiness.domains."+domain, rule)
+	}
+
+	check(c.Pagination.MaxScrolls >= 0, "pagination.max_scrolls cannot be negative")
+	check(c.Pagination.MaxPages >= 1, "pagination.max_pages must be at least 1")
+	_, err = regexp.Compile("(?i)" + c.Pagination.LoadMorePattern)
+	check(err == nil, "pagination.load_more_pattern must be a regular expression: %v", err)
+
+	if c.Browser.RemoteURL != "" {
+		remoteURL, err := url.Parse(c.Browser.RemoteURL)
+		check(err == nil && slices.Contains([]string{"ws", "wss", "http", "https"}, remoteURL.Scheme) && remoteURL.Host != "",
+			"browser.remote_url must be a ws://, wss://, http:// or https:// url")
+	}
+	_, err = parseProxies(c.Browser.Proxy)
+	check(err == nil, "browser.proxy is invalid: %v", err)
+	check(c.Browser.UserAgent != "", "browser.user_agent is required")
+	check(c.Browser.WindowWidth > 0 && c.Browser.WindowHeight > 0, "browser.window_width and browser.window_height must be positive")
+	check(c.Browser.Tabs >= 1, "browser.tabs must be at least 1")
+	check(c.Browser.TabMaxUses >= 1, "browser.tab_max_uses must be at least 1")
+	_, err = blockedResourceTypes(c.Browser.BlockedResources)
+	check(err == nil, "browser.blocked_resources is invalid: %v", err)
+	check(c.Browser.MaxMemoryMB >= 0, "browser.max_memory_mb cannot be negative")
+
+	check(c.Artifacts.MaxPerRun >= 0, "artifacts.max_per_run cannot be negative")
+	check(c.Artifacts.Retention > 0, "artifacts.retention must be positive")
+
+	check(isAbsoluteURL(c.Crunchbase.SearchURL), "crunchbase.search_url must be an absolute url")
+	check(c.Crunchbase.RateLimitWait >= 0, "crunchbase.rate_limit_wait cannot be negative")
+
+	if c.SMTP.Host != "" {
+		check(c.SMTP.Port != "" && c.SMTP.From != "", "smtp.port and smtp.from are required with smtp.host")
+	}
+
+	check(isAbsoluteURL(c.Sources.WTTJURL), "sources.wttj_url must be an absolute url")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Logging.Level)) == nil, "logging.level must be debug, info, warn or error")
+
+	if c.Metrics.PushgatewayURL != "" {
+		check(isAbsoluteURL(c.Metrics.PushgatewayURL), "metrics.pushgateway_url must be an absolute url")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func isAbsoluteURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
+
+// This function returns the connection string of the database
+func (d DatabaseConfig) connectionString() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(d.User, d.Password),
+		Host:   net.JoinHostPort(d.Host, d.Port),
+		Path:   "/" + d.Name,
+	}
+	return dsn.String()
+}
+
+// This function returns the addresses and CIDR ranges of the trusted reverse proxies, none by default
+func (s ServerConfig) trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(s.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// This function tells whether a request comes from a trusted reverse proxy, whose X-Forwarded headers tell the url seen by the client
+func (s ServerConfig) isTrustedProxy(remoteIP string) bool {
+	ip := net.ParseIP(remoteIP)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range s.trustedProxies() {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if ip.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
+
+// This function returns the Welcome to the Jungle url of a path
+func (s SourcesConfig) wttjURL(path string) string {
+	return strings.TrimSuffix(s.WTTJURL, "/") + path
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigEnvNames(t *testing.T) {
+	config := defaultConfig()
+	settings := make(map[string]string)
+
+	walkConfig(&config, func(path string, field reflect.StructField, _ reflect.Value) {
+		env := field.Tag.Get("env")
+		if !strings.HasPrefix(env, "FTJ_") {
+			t.Errorf("the environment variable of %s is %q, it must start with FTJ_", path, env)
+		}
+		if other, ok := settings[env]; ok {
+			t.Errorf("%s and %s share the environment variable %s", other, path, env)
+		}
+		settings[env] = path
+	})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The statuses of a crawl run
+const CRAWL_RUN_RUNNING = "running"
+const CRAWL_RUN_COMPLETED = "completed"
+const CRAWL_RUN_INTERRUPTED = "interrupted"
+const CRAWL_RUN_FAILED = "failed"
+
+// This variable stores a crawl run. A run killed without being able to record its end stays running.
+type CrawlRun struct {
+	ID           string
+	StartedAt    time.Time
+	FinishedAt   *time.Time
+	Status       string
+	ResumedRunID string
+	// The number of companies each stage was skipped for, the stage having been done within the resume window
+	Skipped map[string]int
+	// The number of pages whose artifacts were captured, and the url listing them
+	Artifacts    int
+	ArtifactsURL string
+}
+
+// The number of workers whose artifacts are listed in the status, the last ones to capture some first
+const WORKER_ARTIFACTS_LIMIT = 20
+
+// This variable stores the artifacts captured by a worker, whose id is their run id
+type WorkerArtifacts struct {
+	WorkerID       string
+	Artifacts      int
+	LastCapturedAt time.Time
+	ArtifactsURL   string
+}
+
+func createCrawlRun(ctx context.Context, db *pgxpool.Pool, runID string, resumedRunID string) error {
+	query := `INSERT INTO crawl_runs (id, resumed_run_id) VALUES (@id, nullif(@resumed_run_id, ''))`
+	args := pgx.NamedArgs{
+		"id":             runID,
+		"resumed_run_id": resumedRunID,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return err
+}
+
+func finishCrawlRun(ctx context.Context, db *pgxpool.Pool, runID string, status string, skipped map[string]int) error {
+	query := `UPDATE crawl_runs SET finished_at = now(), status = @status, skipped = @skipped WHERE id = @id`
+	args := pgx.NamedArgs{
+		"id":      runID,
+		"status":  status,
+		"skipped": skipped,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return err
+}
+
+// This function returns the last crawl run started, it returns false when no crawl ever ran
+func getLastCrawlRun(ctx context.Context, db *pgxpool.Pool) (CrawlRun, bool, error) {
+	var run CrawlRun
+	query := `SELECT id, started_at, finished_at, status, coalesce(resumed_run_id, ''), skipped,
+		(SELECT count(*) FROM crawl_artifacts WHERE run_id = crawl_runs.id) FROM crawl_runs ORDER BY started_at DESC LIMIT 1`
+	err := db.QueryRow(ctx, query).Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.Status, &run.ResumedRunID, &run.Skipped, &run.Artifacts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return run, false, nil
+	}
+	if err != nil {
+		return run, false, fmt.Errorf("unable to query row: %w", err)
+	}
+	run.ArtifactsURL = "/runs/" + run.ID + "/artifacts"
+
+	return run, true, nil
+}
+
+// This function returns the workers that captured artifacts, the artifacts of a run that is not a crawl run being the ones of a worker
+func getWorkerArtifacts(ctx context.Context, db *pgxpool.Pool, limit int) ([]WorkerArtifacts, error) {
+	query := `SELECT run_id, count(*), max(created_at) FROM crawl_artifacts
+		WHERE NOT EXISTS (SELECT 1 FROM crawl_runs WHERE crawl_runs.id = crawl_artifacts.run_id)
+		GROUP BY run_id ORDER BY max(created_at) DESC LIMIT @limit`
+	rows, err := db.Query(ctx, query, pgx.NamedArgs{"limit": limit})
+	if err != nil {
+		return nil, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+
+	workers := []WorkerArtifacts{}
+	for rows.Next() {
+		var worker WorkerArtifacts
+		err = rows.Scan(&worker.WorkerID, &worker.Artifacts, &worker.LastCapturedAt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		worker.ArtifactsURL = "/runs/" + worker.WorkerID + "/artifacts"
+		workers = append(workers, worker)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	return workers, nil
+}
+
+// This function records that a company went through a stage of a crawl
+func recordCompanyStageDone(ctx context.Context, db *pgxpool.Pool, runID string, companyName string, stage string) error {
+	query := `INSERT INTO crawl_progress (company_name, stage, run_id) VALUES (@company_name, @stage, @run_id)
+		ON CONFLICT (company_name, stage) DO UPDATE SET run_id = @run_id, completed_at = now()`
+	args := pgx.NamedArgs{
+		"company_name": companyName,
+		"stage":        stage,
+		"run_id":       runID,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to upsert row: %w", err)
+	}
+
+	return err
+}
+
+// This function returns the stages each company went through since the time given
+func getCompletedCompanyStages(ctx context.Context, db *pgxpool.Pool, since time.Time) (map[string]map[string]bool, error) {
+	rows, err := db.Query(ctx, `SELECT company_name, stage FROM crawl_progress WHERE completed_at >= @since`, pgx.NamedArgs{"since": since})
+	if err != nil {
+		return nil, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+
+	completed := make(map[string]map[string]bool)
+	for rows.Next() {
+		var companyName, stage string
+		err = rows.Scan(&companyName, &stage)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		if completed[companyName] == nil {
+			completed[companyName] = make(map[string]bool)
+		}
+		completed[companyName][stage] = true
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	return completed, nil
+}
+
+// This variable stores the progress of a crawl run: the company stages it skips when it is resumed, and the ones it went through
+type crawlProgress struct {
+	db    *pgxpool.Pool
+	runID string
+
+	// The stages each company went through within the resume window, empty when the crawl is not resumed
+	completed map[string]map[string]bool
+
+	mu      sync.Mutex
+	skipped map[string]int
+}
+
+// This function returns a progress skipping no stage, recording the stages the companies go through under the run id given
+func newCrawlProgress(db *pgxpool.Pool, runID string) *crawlProgress {
+	return &crawlProgress{db: db, runID: runID, skipped: make(map[string]int)}
+}
+
+// This function starts the progress of a crawl run. A resumed crawl skips the company stages done within crawl.resume_window,
+// by the run it resumes or by any other.
+func startCrawlProgress(ctx context.Context, db *pgxpool.Pool, runID string, resume bool) (*crawlProgress, error) {
+	progress := newCrawlProgress(db, runID)
+	logger := loggerFromContext(ctx)
+
+	resumedRunID := ""
+	if resume {
+		lastRun, found, err := getLastCrawlRun(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if found && lastRun.Status != CRAWL_RUN_COMPLETED {
+			resumedRunID = lastRun.ID
+			logger.Info("Resuming the last crawl", "resumed_run_id", lastRun.ID, "status", lastRun.Status)
+		} else {
+			logger.Info("The last crawl is over, only the stages done within the resume window are skipped")
+		}
+
+		progress.completed, err = getCompletedCompanyStages(ctx, db, time.Now().Add(-appConfig.Crawl.ResumeWindow))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := createCrawlRun(ctx, db, runID, resumedRunID)
+	if err != nil {
+		return nil, err
+	}
+
+	return progress, nil
+}
+
+// This function tells whether a company stage is skipped, as the company went through it within the resume window
+func (p *crawlProgress) skip(companyName string, stage string) bool {
+	if !p.completed[companyName][stage] {
+		return false
+	}
+
+	p.mu.Lock()
+	p.skipped[stage]++
+	p.mu.Unlock()
+	return true
+}
+
+// This function records that a company went through a stage, an error while recording it is only logged as the crawl goes on
+func (p *crawlProgress) done(ctx context.Context, companyName string, stage string) {
+	// A stage cut short by the cancellation of the crawl is done again when it is resumed
+	if ctx.Err() != nil {
+		return
+	}
+
+	err := recordCompanyStageDone(ctx, p.db, p.runID, companyName, stage)
+	if err != nil {
+		loggerFromContext(ctx).Error("Unable to record the progress of the company", LOG_STAGE, stage, LOG_ERROR, err)
+	}
+}
+
+// This function records the end of the run with the stages it skipped, its status is given by the error the crawl returned
+func (p *crawlProgress) finish(ctx context.Context, crawlErr error) {
+	status := CRAWL_RUN_COMPLETED
+	if errors.Is(crawlErr, errCrawlInterrupted) {
+		status = CRAWL_RUN_INTERRUPTED
+	} else if crawlErr != nil {
+		status = CRAWL_RUN_FAILED
+	}
+
+	p.mu.Lock()
+	skipped := make(map[string]int, len(p.skipped))
+	for stage, count := range p.skipped {
+		skipped[stage] = count
+	}
+	p.mu.Unlock()
+
+	logger := loggerFromContext(ctx)
+	if len(skipped) > 0 {
+		logger.Info("Stages skipped as the companies went through them within the resume window", "skipped", skipped)
+	}
+
+	// The end of the run is recorded even when the crawl is being cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CRAWL_STAGE_RECORD_TIMEOUT)
+	defer cancel()
+	err := finishCrawlRun(ctx, p.db, p.runID, status, skipped)
+	if err != nil {
+		logger.Error("Unable to record the end of the crawl", LOG_ERROR, err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The time given to the recording of the outcome of a stage
+const CRAWL_STAGE_RECORD_TIMEOUT = 10 * time.Second
+
+// The stages of a crawl in the order they run, the status endpoint lists them in this order
+var crawlStages = []string{STAGE_LISTS, STAGE_WEBSITE, STAGE_WTTJ, STAGE_JOBS_PAGE, STAGE_OFFERS, STAGE_DEDUP, STAGE_DIGESTS, STAGE_FOLLOW_UPS}
+
+// This variable stores the last outcome of a crawl stage. A stage done for each company succeeds when it went through all of them,
+// the companies it failed on are counted in FailedCompanies.
+type CrawlStageStatus struct {
+	Stage           string
+	LastRunID       string
+	LastSuccessAt   *time.Time
+	LastFailureAt   *time.Time
+	LastError       string
+	FailedCompanies int
+}
+
+// This function records that a stage of a crawl went through
+func recordCrawlStageSuccess(ctx context.Context, db *pgxpool.Pool, runID string, stage string, failedCompanies int) error {
+	query := `INSERT INTO crawl_stages (stage, last_run_id, last_success_at, failed_companies) VALUES (@stage, @run_id, now(), @failed_companies)
+		ON CONFLICT (stage) DO UPDATE SET last_run_id = @run_id, last_success_at = now(), failed_companies = @failed_companies`
+	args := pgx.NamedArgs{
+		"stage":            stage,
+		"run_id":           runID,
+		"failed_companies": failedCompanies,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to upsert row: %w", err)
+	}
+
+	return err
+}
+
+// This function records that a stage of a crawl failed, the time of its last success is kept
+func recordCrawlStageFailure(ctx context.Context, db *pgxpool.Pool, runID string, stage string, stageErr error) error {
+	query := `INSERT INTO crawl_stages (stage, last_run_id, last_failure_at, last_error) VALUES (@stage, @run_id, now(), @last_error)
+		ON CONFLICT (stage) DO UPDATE SET last_run_id = @run_id, last_failure_at = now(), last_error = @last_error`
+	args := pgx.NamedArgs{
+		"stage":      stage,
+		"run_id":     runID,
+		"last_error": stageErr.Error(),
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to upsert row: %w", err)
+	}
+
+	return err
+}
+
+// This function records the outcome of a crawl stage, an error while recording it is only logged as the crawl goes on
+func recordCrawlStage(ctx context.Context, db *pgxpool.Pool, runID string, stage string, failedCompanies int, stageErr error) {
+	// The outcome of a stage is recorded even when the crawl it belongs to is being cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CRAWL_STAGE_RECORD_TIMEOUT)
+	defer cancel()
+
+	var err error
+	if stageErr != nil {
+		err = recordCrawlStageFailure(ctx, db, runID, stage, stageErr)
+	} else {
+		err = recordCrawlStageSuccess(ctx, db, runID, stage, failedCompanies)
+	}
+	if err != nil {
+		loggerFromContext(ctx).Error("Unable to record the outcome of the stage", LOG_STAGE, stage, LOG_ERROR, err)
+	}
+}
+
+// This function runs a stage of a crawl that is not done company by company, in its span and with its logger, and records its outcome
+func runCrawlStage(ctx context.Context, db *pgxpool.Pool, runID string, stage string, run func(ctx context.Context) error) {
+	logger := loggerFromContext(ctx).With(LOG_STAGE, stage)
+	ctx, span := startSpan(withLogger(ctx, logger), "crawl."+stage)
+
+	err := run(ctx)
+	endSpan(span, err)
+	if err != nil {
+		logger.Error("The stage failed", LOG_ERROR, err)
+	}
+	recordCrawlStage(ctx, db, runID, stage, 0, err)
+}
+
+// This function returns the last outcome of every crawl stage, the stages that never ran have no times
+func getCrawlStageStatuses(ctx context.Context, db *pgxpool.Pool) ([]CrawlStageStatus, error) {
+	rows, err := db.Query(ctx, `SELECT stage, last_run_id, last_success_at, last_failure_at, last_error, failed_companies FROM crawl_stages`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+
+	recorded := make(map[string]CrawlStageStatus)
+	for rows.Next() {
+		var status CrawlStageStatus
+		err = rows.Scan(&status.Stage, &status.LastRunID, &status.LastSuccessAt, &status.LastFailureAt, &status.LastError, &status.FailedCompanies)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		recorded[status.Stage] = status
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	statuses := make([]CrawlStageStatus, 0, len(crawlStages))
+	for _, stage := range crawlStages {
+		status, ok := recorded[stage]
+		if !ok {
+			status = CrawlStageStatus{Stage: stage}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// This function returns the last successful time of every crawl stage, the last crawl run with the stages it skipped and its artifacts,
+// and the workers that captured artifacts
+func getStatusAPI(c *gin.Context) {
+	statuses, err := getCrawlStageStatuses(c.Request.Context(), dbpoolapi)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	lastRun, found, err := getLastCrawlRun(c.Request.Context(), dbpoolapi)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	var lastRunData *CrawlRun
+	if found {
+		lastRunData = &lastRun
+	}
+
+	workers, err := getWorkerArtifacts(c.Request.Context(), dbpoolapi, WORKER_ARTIFACTS_LIMIT)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"schemaVersion": SCHEMA_VERSION, "stages": statuses, "lastRun": lastRunData, "workerArtifacts": workers}})
+}
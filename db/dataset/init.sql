@@ -13,4 +13,212 @@ id SERIAL PRIMARY KEY,
 company_name TEXT,
 offer_url TEXT,
 UNIQUE(offer_url)
-);
\ No newline at end of file
+);
+CREATE TABLE company_lists (
+slug TEXT PRIMARY KEY,
+name TEXT
+);
+
+CREATE TABLE company_list_memberships (
+list_slug TEXT REFERENCES company_lists(slug) ON DELETE CASCADE,
+edition_year INTEGER,
+company_name TEXT REFERENCES companies(name) ON UPDATE CASCADE ON DELETE CASCADE,
+rank INTEGER,
+PRIMARY KEY (list_slug, edition_year, company_name)
+);
+
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+CREATE TEXT SEARCH CONFIGURATION french_unaccent ( COPY = french );
+ALTER TEXT SEARCH CONFIGURATION french_unaccent ALTER MAPPING FOR hword, hword_part, word WITH unaccent, french_stem;
+
+ALTER TABLE offers
+ADD COLUMN title TEXT NOT NULL DEFAULT '',
+ADD COLUMN description TEXT NOT NULL DEFAULT '',
+ADD COLUMN category TEXT NOT NULL DEFAULT '',
+ADD COLUMN contract_type TEXT NOT NULL DEFAULT '',
+ADD COLUMN location TEXT NOT NULL DEFAULT '',
+ADD COLUMN remote BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN first_seen TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE offers ADD COLUMN search tsvector GENERATED ALWAYS AS (
+setweight(to_tsvector('french_unaccent', title), 'A') ||
+setweight(to_tsvector('french_unaccent', coalesce(company_name, '')), 'B') ||
+setweight(to_tsvector('french_unaccent', description), 'C')
+) STORED;
+
+CREATE INDEX offers_search_idx ON offers USING GIN (search);
+
+ALTER TABLE companies ADD COLUMN is_contractor BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE saved_searches (
+id SERIAL PRIMARY KEY,
+email TEXT NOT NULL,
+name TEXT NOT NULL,
+keywords TEXT NOT NULL DEFAULT '',
+category TEXT NOT NULL DEFAULT '',
+location TEXT NOT NULL DEFAULT '',
+remote BOOLEAN NOT NULL DEFAULT false,
+exclude_contractors BOOLEAN NOT NULL DEFAULT false,
+frequency TEXT NOT NULL CHECK (frequency IN ('daily', 'weekly')),
+last_sent_at TIMESTAMPTZ,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE companies ADD COLUMN slug TEXT UNIQUE;
+
+CREATE TABLE wishlist (
+company_name TEXT PRIMARY KEY REFERENCES companies(name) ON UPDATE CASCADE ON DELETE CASCADE,
+added_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE users (
+id SERIAL PRIMARY KEY,
+email TEXT NOT NULL UNIQUE,
+password_hash TEXT NOT NULL,
+role TEXT NOT NULL DEFAULT 'reader' CHECK (role IN ('admin', 'reader')),
+created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE sessions (
+token_hash TEXT PRIMARY KEY,
+user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+expires_at TIMESTAMPTZ NOT NULL,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE api_tokens (
+id SERIAL PRIMARY KEY,
+user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+name TEXT NOT NULL,
+token_hash TEXT NOT NULL UNIQUE,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+last_used_at TIMESTAMPTZ
+);
+
+ALTER TABLE wishlist DROP CONSTRAINT wishlist_pkey;
+ALTER TABLE wishlist ADD COLUMN user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE wishlist ADD PRIMARY KEY (user_id, company_name);
+
+ALTER TABLE saved_searches ADD COLUMN user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE applications (
+id SERIAL PRIMARY KEY,
+user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+offer_id INTEGER REFERENCES offers(id) ON DELETE SET NULL,
+company_name TEXT NOT NULL,
+offer_title TEXT NOT NULL DEFAULT '',
+offer_url TEXT NOT NULL DEFAULT '',
+status TEXT NOT NULL DEFAULT 'saved' CHECK (status IN ('saved', 'applied', 'interviewing', 'offer', 'rejected')),
+notes TEXT NOT NULL DEFAULT '',
+applied_at TIMESTAMPTZ,
+follow_up_at TIMESTAMPTZ,
+reminder_sent_at TIMESTAMPTZ,
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+UNIQUE(user_id, offer_id)
+);
+
+CREATE INDEX applications_follow_up_idx ON applications (follow_up_at) WHERE reminder_sent_at IS NULL;
+
+CREATE TABLE application_status_changes (
+id SERIAL PRIMARY KEY,
+application_id INTEGER NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+status TEXT NOT NULL,
+changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE offer_sources (
+id SERIAL PRIMARY KEY,
+offer_id INTEGER NOT NULL REFERENCES offers(id) ON DELETE CASCADE,
+url TEXT NOT NULL,
+canonical_url TEXT NOT NULL UNIQUE,
+first_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+last_seen TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX offer_sources_offer_id_idx ON offer_sources (offer_id);
+
+ALTER TABLE offers ADD COLUMN dedup_key TEXT;
+CREATE INDEX offers_dedup_key_idx ON offers (dedup_key);
+
+CREATE TABLE crawl_stages (
+stage TEXT PRIMARY KEY,
+last_run_id TEXT NOT NULL,
+last_success_at TIMESTAMPTZ,
+last_failure_at TIMESTAMPTZ,
+last_error TEXT NOT NULL DEFAULT '',
+failed_companies INTEGER NOT NULL DEFAULT 0
+);
+
+-- The version of this schema, checked by /readyz, it is bumped with each change of this file
+CREATE TABLE schema_version (
+version INTEGER NOT NULL
+);
+
+INSERT INTO schema_version (version) VALUES (1);
+
+CREATE TABLE crawl_runs (
+id TEXT PRIMARY KEY,
+started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+finished_at TIMESTAMPTZ,
+status TEXT NOT NULL DEFAULT 'running',
+resumed_run_id TEXT REFERENCES crawl_runs(id) ON DELETE SET NULL,
+skipped JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE crawl_progress (
+company_name TEXT NOT NULL REFERENCES companies(name) ON UPDATE CASCADE ON DELETE CASCADE,
+stage TEXT NOT NULL,
+run_id TEXT NOT NULL,
+completed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+PRIMARY KEY (company_name, stage)
+);
+
+UPDATE schema_version SET version = 2;
+
+CREATE TABLE crawl_tasks (
+id BIGSERIAL PRIMARY KEY,
+kind TEXT NOT NULL,
+company_name TEXT NOT NULL REFERENCES companies(name) ON UPDATE CASCADE ON DELETE CASCADE,
+status TEXT NOT NULL DEFAULT 'pending',
+attempts INTEGER NOT NULL DEFAULT 0,
+max_attempts INTEGER NOT NULL,
+run_after TIMESTAMPTZ NOT NULL DEFAULT now(),
+leased_by TEXT,
+lease_expires_at TIMESTAMPTZ,
+last_error TEXT NOT NULL DEFAULT '',
+created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+finished_at TIMESTAMPTZ
+);
+
+-- A company has at most one task of each kind waiting or running
+CREATE UNIQUE INDEX crawl_tasks_active_idx ON crawl_tasks (kind, company_name) WHERE status IN ('pending', 'running');
+CREATE INDEX crawl_tasks_claim_idx ON crawl_tasks (run_after, id) WHERE status = 'pending';
+
+UPDATE schema_version SET version = 3;
+
+-- The screenshot, HTML and console errors of the pages, run_id being a crawl run or a worker id
+CREATE TABLE crawl_artifacts (
+id BIGSERIAL PRIMARY KEY,
+run_id TEXT NOT NULL,
+company_name TEXT NOT NULL DEFAULT '',
+page TEXT NOT NULL,
+url TEXT NOT NULL,
+outcome TEXT NOT NULL,
+reason TEXT NOT NULL DEFAULT '',
+console_errors TEXT[] NOT NULL DEFAULT '{}',
+screenshot BYTEA,
+html TEXT NOT NULL DEFAULT '',
+created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX crawl_artifacts_run_idx ON crawl_artifacts (run_id, created_at);
+CREATE INDEX crawl_artifacts_created_at_idx ON crawl_artifacts (created_at);
+
+UPDATE schema_version SET version = 4;
+
+-- The digests are sent to the email of the account owning the saved search
+ALTER TABLE saved_searches DROP COLUMN email;
+
+UPDATE schema_version SET version = 5;
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.ParseFS(templatesFS, "templates/email/digest.html"))
+var digestTextTemplate = texttemplate.Must(texttemplate.ParseFS(templatesFS, "templates/email/digest.txt"))
+var followUpsHTMLTemplate = htmltemplate.Must(htmltemplate.ParseFS(templatesFS, "templates/email/followUps.html"))
+var followUpsTextTemplate = texttemplate.Must(texttemplate.ParseFS(templatesFS, "templates/email/followUps.txt"))
+
+// This variable stores what is rendered in a saved search digest email
+type Digest struct {
+	Search SavedSearch
+	Since  time.Time
+	Total  int
+	Offers []OfferSearchResult
+}
+
+// This variable stores the smtp server used to send the digests
+type Mailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// This function returns the mailer of the smtp server configured, it returns false when no smtp server is configured
+func loadMailer() (Mailer, bool) {
+	settings := appConfig.SMTP
+	mailer := Mailer{
+		Host:     settings.Host,
+		Port:     settings.Port,
+		Username: settings.Username,
+		Password: settings.Password,
+		From:     settings.From,
+	}
+	return mailer, settings.Host != ""
+}
+
+// This function sends an email with a text and an html version of the same content
+func (m Mailer) send(to string, subject string, text string, html string) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create the email part: %w", err)
+		}
+		_, err = partWriter.Write([]byte(part.content))
+		if err != nil {
+			return fmt.Errorf("unable to write the email part: %w", err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close the email: %w", err)
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", m.From)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	message.Write(body.Bytes())
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	err = smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, message.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to send the email to %s: %w", to, err)
+	}
+
+	return err
+}
+
+// This function renders the text and html versions of a digest
+func renderDigest(digest Digest) (string, string, error) {
+	// The search highlights are html tags that are not wanted in an email
+	for i := range digest.Offers {
+		digest.Offers[i].Snippet = strings.NewReplacer("<mark>", "", "</mark>", "").Replace(digest.Offers[i].Snippet)
+	}
+
+	var text bytes.Buffer
+	err := digestTextTemplate.Execute(&text, digest)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to render the text digest: %w", err)
+	}
+
+	var html bytes.Buffer
+	err = digestHTMLTemplate.Execute(&html, digest)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to render the html digest: %w", err)
+	}
+
+	return text.String(), html.String(), nil
+}
+
+// This function sends the digest of every saved search that is due, with the offers found since its previous digest
+func sendSavedSearchesDigests(ctx context.Context, db *pgxpool.Pool) error {
+	logger := loggerFromContext(ctx)
+
+	mailer, configured := loadMailer()
+	if !configured {
+		logger.Warn("No smtp server is configured, the saved searches digests are not sent")
+		return nil
+	}
+
+	now := time.Now()
+	searches, err := getDueSavedSearches(ctx, db, now)
+	if err != nil {
+		return err
+	}
+
+	for _, search := range searches {
+		searchLogger := logger.With("saved_search_id", search.ID)
+		err = sendSavedSearchDigest(withLogger(ctx, searchLogger), db, mailer, search, now)
+		if err != nil {
+			searchLogger.Error("An error happened while sending the saved search digest", LOG_ERROR, err)
+		}
+	}
+
+	return nil
+}
+
+// This function sends the digest of a saved search if new offers match it, and records until when the offers have been sent
+func sendSavedSearchDigest(ctx context.Context, db *pgxpool.Pool, mailer Mailer, search SavedSearch, now time.Time) error {
+	params := search.searchParams()
+	params.Since = search.CreatedAt
+	if search.LastSentAt != nil {
+		params.Since = *search.LastSentAt
+	}
+
+	response, err := searchOffers(ctx, db, params)
+	if err != nil {
+		return err
+	}
+
+	if response.Total > 0 {
+		digest := Digest{Search: search, Since: params.Since, Total: response.Total, Offers: response.Results}
+		text, html, err := renderDigest(digest)
+		if err != nil {
+			return err
+		}
+
+		subject := fmt.Sprintf("%d new offers for %s", response.Total, search.Name)
+		err = mailer.send(search.Email, subject, text, html)
+		if err != nil {
+			return err
+		}
+		loggerFromContext(ctx).Info("The saved search digest has been sent", "email", search.Email, "offers", response.Total)
+	}
+
+	return updateSavedSearchLastSentAt(ctx, db, search.ID, now)
+}
+
+// This function renders the text and html versions of the follow up reminder of applications
+func renderFollowUps(applications []Application) (string, string, error) {
+	var text bytes.Buffer
+	err := followUpsTextTemplate.Execute(&text, applications)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to render the text follow ups: %w", err)
+	}
+
+	var html bytes.Buffer
+	err = followUpsHTMLTemplate.Execute(&html, applications)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to render the html follow ups: %w", err)
+	}
+
+	return text.String(), html.String(), nil
+}
+
+// This function sends to every user one email listing its applications whose follow up date has passed, each application is only reminded once per follow up date
+func sendFollowUpReminders(ctx context.Context, db *pgxpool.Pool) error {
+	logger := loggerFromContext(ctx)
+
+	mailer, configured := loadMailer()
+	if !configured {
+		logger.Warn("No smtp server is configured, the follow up reminders are not sent")
+		return nil
+	}
+
+	now := time.Now()
+	reminders, err := getUnsentFollowUpReminders(ctx, db, now)
+	if err != nil {
+		return err
+	}
+
+	for email, applications := range reminders {
+		text, html, err := renderFollowUps(applications)
+		if err != nil {
+			logger.Error("An error happened while rendering the follow up reminder", "email", email, LOG_ERROR, err)
+			continue
+		}
+
+		subject := fmt.Sprintf("%d applications to follow up", len(applications))
+		err = mailer.send(email, subject, text, html)
+		if err != nil {
+			logger.Error("An error happened while sending the follow up reminder", "email", email, LOG_ERROR, err)
+			continue
+		}
+
+		var ids []int
+		for _, application := range applications {
+			ids = append(ids, application.ID)
+		}
+		err = markFollowUpRemindersSent(ctx, db, ids, now)
+		if err != nil {
+			logger.Error("An error happened with the query", LOG_ERROR, err)
+			continue
+		}
+		logger.Info("The follow up reminder has been sent", "email", email, "applications", len(applications))
+	}
+
+	return nil
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/chromedp/cdproto/cdp"
@@ -14,94 +14,132 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// Check if the job page of a company is present on their own website
-func checkCompanyJobPage(ctx context.Context, website string) string {
-	jobsPageURL := ""
+// This variable matches the links to a careers page
+var careersLinkRegexp = regexp.MustCompile(".*(jobs|careers|carreers).*")
+
+// This function returns the first link of a page to a careers page on the site of the page or of an applicant tracking system,
+// or an empty string if there is none
+func findCareersPageLink(doc *goquery.Document, base *url.URL, page *url.URL) string {
+	careersPageURL := ""
+
+	doc.Find("a[href]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		href, _ := s.Attr("href")
+		if !careersLinkRegexp.MatchString(href) {
+			return true
+		}
+		link, ok := resolveLink(base, href)
+		if !ok || !isLinkOnSite(page, link) {
+			return true
+		}
+		careersPageURL = removeTrailingSlash(link)
+		return false
+	})
+
+	return careersPageURL
+}
+
+// Check if the job page of a company is present on their own website.
+// It returns an empty url when the website has no link to a careers page or is not fetched to be polite, and an error when it cannot be read.
+func checkCompanyJobPage(ctx context.Context, website string) (jobsPageURL string, err error) {
+	logger := loggerFromContext(ctx).With(LOG_URL, website)
+
+	ctx, span := startSpan(ctx, "careers_page.check", SPAN_URL.String(website))
+	defer func() { endSpan(span, err) }()
 
 	// Create the request context
-	ctx, cancel := createTab(ctx)
+	ctx, cancel, err := createTab(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to open a tab: %w", err)
+	}
 	defer cancel()
 
-	if !isUrlWorking(website) {
-		return jobsPageURL
+	// The website is only fetched by the browser, a website that does not answer failing its navigation within crawl.navigation_timeout
+	release, err := visitPage(ctx, website)
+	if isPageSkipped(err) {
+		logger.Info("The website is not searched for its careers page", LOG_ERROR, err)
+		return "", nil
 	}
+	if err != nil {
+		return "", err
+	}
+	defer release()
 
 	html := ""
-	var err error
-	for i := 0; i < 5; i++ {
-		err := chromedp.Run(ctx,
+	err = retryFetch(ctx, PAGE_CAREERS, website, func(ctx context.Context) error {
+		return runNavigation(ctx, PAGE_CAREERS,
 			// visit the target page
 			chromedp.Navigate(website),
 			// wait for the page to load
-			chromedp.Sleep(2000*time.Millisecond),
+			waitReady(PAGE_CAREERS, website),
 		)
-		if err != nil {
-			i = i + 1
-		} else {
-			break
-		}
-	}
+	})
 
 	if err != nil {
-		log.Fatal("Error while performing the automation logic:", err)
+		captureErrorArtifacts(ctx, PAGE_CAREERS, website, err)
+		return "", fmt.Errorf("unable to read the website: %w", err)
 	}
 
 	c := chromedp.FromContext(ctx)
 	rootNode, err := dom.GetDocument().Do(cdp.WithExecutor(ctx, c.Target))
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("unable to get the page document: %w", err)
 	}
 
 	html, err = dom.GetOuterHTML().WithNodeID(rootNode.NodeID).Do(cdp.WithExecutor(ctx, c.Target))
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("unable to get the page HTML: %w", err)
 	}
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("unable to open the HTML as a goquery document: %w", err)
 	}
 
-	// Find all the href links in the HTML document
-	links := doc.Find("a")
-
-	// Create a regular expression to match the URL of the jobs page
-	regex := regexp.MustCompile(".*(jobs|careers|carreers).*")
-
-	// Iterate over the links and print them to the console
-	for i := 0; i < links.Length(); i++ {
-		link := links.Eq(i)
+	// The relative links are resolved against the url of the website once redirected, or its <base> tag
+	location := website
+	err = chromedp.Run(ctx, chromedp.Location(&location))
+	if err != nil || location == "" {
+		location = website
+	}
+	baseHref, _ := doc.Find("base[href]").First().Attr("href")
+	base, err := pageBaseURL(location, baseHref)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the base url of the page: %w", err)
+	}
+	page, _ := url.Parse(location)
 
-		// Get the href attribute of the link
-		href, ok := link.Attr("href")
-		if ok {
-			if regex.MatchString(href) {
-				jobsPageURL = removeTrailingSlash(getAbsoluteUrl(website, href))
-				break
-			}
-		}
+	jobsPageURL = findCareersPageLink(doc, base, page)
+	if jobsPageURL == "" {
+		captureArtifacts(ctx, PAGE_CAREERS, website, ARTIFACT_FAILURE, "no link to a careers page was found")
+	} else {
+		captureArtifacts(ctx, PAGE_CAREERS, website, ARTIFACT_SUCCESS, "")
 	}
 
-	return jobsPageURL
+	return jobsPageURL, nil
 }
 
-// Enrich the job url for a company
+// Enrich the job url for a company, the wttj or linkedin jobs page being only used when the website has no careers page.
+// It returns an error when the website cannot be read, for the careers page to be searched again by the next crawl.
 func enrichJobURL(ctx context.Context, companyToEnrich Company) (Company, error) {
 
 	var err error
+	logger := loggerFromContext(ctx)
 	if companyToEnrich.Website != "" {
-		companyToEnrich.JobsPageURL = checkCompanyJobPage(ctx, companyToEnrich.Website)
+		companyToEnrich.JobsPageURL, err = checkCompanyJobPage(ctx, companyToEnrich.Website)
+		if err != nil {
+			return companyToEnrich, fmt.Errorf("unable to search the careers page on the company website: %w", err)
+		}
 	}
 	if companyToEnrich.JobsPageURL != "" {
-		log.Printf("%s careers page was found on it's website : %v", companyToEnrich.Name, companyToEnrich)
+		logger.Info("The careers page was found on the company website", LOG_URL, companyToEnrich.JobsPageURL)
 	} else if companyToEnrich.WTTJURL != "" {
 		companyToEnrich.JobsPageURL = companyToEnrich.WTTJURL + "/jobs"
-		log.Printf("%s careers page was not found on it's website, using wttj jobs page: %v", companyToEnrich.Name, companyToEnrich)
+		logger.Info("The careers page was not found on the company website, using the wttj jobs page", LOG_URL, companyToEnrich.JobsPageURL)
 	} else if companyToEnrich.LinkedInURL != "" {
 		companyToEnrich.JobsPageURL = companyToEnrich.LinkedInURL + "/jobs"
-		log.Printf("%s careers page was not found on it's website, using linkedin jobs page : %v", companyToEnrich.Name, companyToEnrich)
+		logger.Info("The careers page was not found on the company website, using the linkedin jobs page", LOG_URL, companyToEnrich.JobsPageURL)
 	} else {
-		log.Printf("%s careers page was not found on it's website, neither wttj or linked pages were found, not enriching job page url : %v", companyToEnrich.Name, companyToEnrich)
+		logger.Warn("The careers page was not found on the company website, neither wttj or linkedin pages were found, not enriching the jobs page url")
 	}
 
 	// Return the company enriched with it's jobs page URL
@@ -111,25 +149,34 @@ func enrichJobURL(ctx context.Context, companyToEnrich Company) (Company, error)
 // Define a function to enrich a list of companies with their welcome to the jungle url.
 func enrichCompanyJobUrl(ctx context.Context, db *pgxpool.Pool, companyName string) error {
 	var err error
+	logger := loggerFromContext(ctx)
 
-	company, exists, err := getCompany(db, companyName)
+	company, exists, err := getCompany(ctx, db, companyName)
 	switch {
 	case err != nil:
-		log.Printf("Database query failed because of : %s", err)
+		logger.Error("Database query failed", LOG_ERROR, err)
 	case !exists:
-		log.Printf("The company does not exist")
+		logger.Warn("The company does not exist")
 	default:
 		if company.JobsPageURL == "" {
 			company, err = enrichJobURL(ctx, company)
 			if err != nil {
+				enrichmentsTotal.WithLabelValues("jobs_page", ENRICHMENT_ERROR).Inc()
 				return err
 			}
-			updateCompany(db, company)
-			if err != nil {
-				log.Printf("An error happened with the query : %s", err)
+			err = updateCompany(ctx, db, company)
+			switch {
+			case err != nil:
+				enrichmentsTotal.WithLabelValues("jobs_page", ENRICHMENT_ERROR).Inc()
+				logger.Error("An error happened with the query", LOG_ERROR, err)
+			case company.JobsPageURL == "":
+				enrichmentsTotal.WithLabelValues("jobs_page", ENRICHMENT_NOT_FOUND).Inc()
+			default:
+				enrichmentsTotal.WithLabelValues("jobs_page", ENRICHMENT_FOUND).Inc()
 			}
 		} else {
-			log.Printf("Company already has it's Job url enriched.")
+			enrichmentsTotal.WithLabelValues("jobs_page", ENRICHMENT_SKIPPED).Inc()
+			logger.Debug("The company already has its jobs page url enriched")
 		}
 	}
 
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestFindCareersPageLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		html     string
+		want     string
+	}{
+		{
+			"relative link",
+			"https://www.example.fr/",
+			`<a href="/a-propos">About</a><a href="/careers/">Careers</a>`,
+			"https://www.example.fr/careers",
+		},
+		{
+			"relative to the redirected page",
+			"https://www.example.com/fr/accueil",
+			`<a href="jobs">Jobs</a>`,
+			"https://www.example.com/fr/jobs",
+		},
+		{
+			"relative to the base tag",
+			"https://www.example.fr/accueil",
+			`<head><base href="https://www.example.fr/site/"></head><a href="careers">Careers</a>`,
+			"https://www.example.fr/site/careers",
+		},
+		{
+			"off site links skipped",
+			"https://www.example.fr/",
+			`<a href="https://www.linkedin.com/company/example/jobs">LinkedIn</a><a href="https://careers.example.fr/jobs">Jobs</a>`,
+			"https://careers.example.fr/jobs",
+		},
+		{
+			"applicant tracking system",
+			"https://www.example.fr/",
+			`<a href="https://twitter.com/example-careers">Twitter</a><a href="https://jobs.lever.co/example">Jobs</a>`,
+			"https://jobs.lever.co/example",
+		},
+		{
+			"only off site links",
+			"https://www.example.fr/",
+			`<a href="https://www.linkedin.com/company/example/jobs">LinkedIn</a>`,
+			"",
+		},
+		{
+			"no careers link",
+			"https://www.example.fr/",
+			`<a href="/contact">Contact</a><a href="javascript:openJobs()">Jobs</a>`,
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(test.html))
+			if err != nil {
+				t.Fatalf("unable to parse the page: %v", err)
+			}
+			baseHref, _ := doc.Find("base[href]").First().Attr("href")
+			base, err := pageBaseURL(test.location, baseHref)
+			if err != nil {
+				t.Fatalf("unable to resolve the base url: %v", err)
+			}
+			page, _ := url.Parse(test.location)
+
+			if got := findCareersPageLink(doc, base, page); got != test.want {
+				t.Errorf("the careers page of %s is %q, want %q", test.location, got, test.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,8 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/chromedp/cdproto/cdp"
@@ -22,23 +20,39 @@ func findCompanyWTTJURL(ctx context.Context, companyName string) (string, error)
 	query := url.QueryEscape(companyName)
 
 	// Build the search URL.
-	searchURL := fmt.Sprintf("https://www.welcometothejungle.com/fr/companies?query=%s", query)
+	searchURL := appConfig.Sources.wttjURL("/fr/companies?query=" + query)
+	logger := loggerFromContext(ctx).With(LOG_URL, searchURL)
+
+	ctx, span := startSpan(ctx, "wttj.search", SPAN_URL.String(searchURL))
+	defer span.End()
 
 	// Create the request context
-	ctx, cancel := createTab(ctx)
+	ctx, cancel, err := createTab(ctx)
+	if err != nil {
+		return "", err
+	}
 	defer cancel()
 
 	selector := "sc-1wqurwm-0 NyiTc ais-Hits-list"
 
+	release, err := visitPage(ctx, searchURL)
+	if isPageSkipped(err) {
+		logger.Warn("The wttj search page is not fetched", LOG_ERROR, err)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
 	var node []cdp.NodeID
 	html := ""
-	var err error
-	for i := 0; i < 5; i++ {
-		err := chromedp.Run(ctx,
+	err = retryFetch(ctx, PAGE_WTTJ_SEARCH, searchURL, func(ctx context.Context) error {
+		return runNavigation(ctx, PAGE_WTTJ_SEARCH,
 			// visit the target page
 			chromedp.Navigate(searchURL),
 			// wait for the page to load
-			chromedp.Sleep(4000*time.Millisecond),
+			waitReady(PAGE_WTTJ_SEARCH, searchURL),
 			chromedp.ActionFunc(func(ctx context.Context) error {
 
 				id, count, err := dom.PerformSearch(selector).Do(ctx)
@@ -54,43 +68,42 @@ func findCompanyWTTJURL(ctx context.Context, companyName string) (string, error)
 				} else {
 					node, err = dom.GetSearchResults(id, 0, count).Do(ctx)
 					if err != nil {
-						log.Print("No enterprises were found")
+						logger.Debug("No enterprises were found")
 						return err
 					}
 				}
 				return nil
 			}),
 		)
-		if err != nil {
-			i = i + 1
-		} else {
-			break
-		}
-	}
+	})
 
 	if err != nil {
-		log.Printf("Error while performing the automation logic : %v", err)
+		captureErrorArtifacts(ctx, PAGE_WTTJ_SEARCH, searchURL, err)
+		return "", fmt.Errorf("unable to search the company on wttj: %w", err)
 	}
 
-	if node != nil {
+	if node == nil {
+		captureArtifacts(ctx, PAGE_WTTJ_SEARCH, searchURL, ARTIFACT_FAILURE, "no element matches the selector "+selector)
+	} else {
 		c := chromedp.FromContext(ctx)
 		html, err = dom.GetOuterHTML().WithNodeID(node[0]).Do(cdp.WithExecutor(ctx, c.Target))
 		if err != nil {
-			log.Printf("Error while getting the wttj search page HTML : %v ", err)
+			return "", fmt.Errorf("unable to get the wttj search page HTML: %w", err)
 		}
 
 		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 		if err != nil {
-			log.Printf("Error while opening the HTML as a goquery document : %v", err)
+			return "", fmt.Errorf("unable to parse the wttj search page HTML: %w", err)
 		}
 
 		link := doc.Find("a")
 		url, exist := link.Attr("href")
 		if !exist {
-			log.Printf("Error while trying to get the content of the href link")
+			logger.Error("Error while trying to get the content of the href link")
 		}
 
-		html = "https://www.welcometothejungle.com" + url
+		html = appConfig.Sources.wttjURL(url)
+		captureArtifacts(ctx, PAGE_WTTJ_SEARCH, searchURL, ARTIFACT_SUCCESS, "")
 	}
 
 	WTTJURL := html
@@ -98,7 +111,7 @@ func findCompanyWTTJURL(ctx context.Context, companyName string) (string, error)
 	// Parse the url to separe it's components
 	url, err := url.Parse(WTTJURL)
 	if err != nil {
-		log.Printf("%s", err)
+		return "", fmt.Errorf("unable to parse the wttj url: %w", err)
 	}
 
 	// Delete the query part
@@ -107,35 +120,50 @@ func findCompanyWTTJURL(ctx context.Context, companyName string) (string, error)
 	// Fetch back the parsed url
 	WTTJURL = url.String()
 
-	if !validateWTTJURL(ctx, WTTJURL, companyName) {
-		WTTJURL = ""
-	}
-
 	return WTTJURL, err
 }
 
 // Define a function to scrape a company Welcome to the Jungle page and verify that the company name is present in its content.
-func validateWTTJURL(ctx context.Context, companyURL string, companyName string) bool {
+// It returns false when the page is not the one of the company or is not fetched to be polite, and an error when it cannot be read.
+func validateWTTJURL(ctx context.Context, companyURL string, companyName string) (valid bool, err error) {
 
 	searchURL, err := url.ParseRequestURI(companyURL)
 	if err != nil {
-		return false
+		return false, nil
 	}
 
+	logger := loggerFromContext(ctx).With(LOG_URL, companyURL)
+
+	ctx, span := startSpan(ctx, "wttj.validate", SPAN_URL.String(companyURL))
+	defer func() { endSpan(span, err) }()
+
 	// Create the request context
-	ctx, cancel := createTab(ctx)
+	ctx, cancel, err := createTab(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to open a tab: %w", err)
+	}
 	defer cancel()
 
 	selector := "sc-gdfaqJ cUUdcw"
 
+	release, err := visitPage(ctx, searchURL.String())
+	if isPageSkipped(err) {
+		logger.Info("The wttj company page is not fetched", LOG_ERROR, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
 	var node []cdp.NodeID
 	html := ""
-	for i := 0; i < 5; i++ {
-		err = chromedp.Run(ctx,
+	err = retryFetch(ctx, PAGE_WTTJ_COMPANY, searchURL.String(), func(ctx context.Context) error {
+		return runNavigation(ctx, PAGE_WTTJ_COMPANY,
 			// visit the target page
 			chromedp.Navigate(searchURL.String()),
 			// wait for the page to load
-			chromedp.Sleep(4000*time.Millisecond),
+			waitReady(PAGE_WTTJ_COMPANY, searchURL.String()),
 			chromedp.ActionFunc(func(ctx context.Context) error {
 
 				id, count, err := dom.PerformSearch(selector).Do(ctx)
@@ -147,7 +175,7 @@ func validateWTTJURL(ctx context.Context, companyURL string, companyName string)
 				}()
 
 				if count < 1 {
-					log.Printf("There is no wttj url for %s", companyName)
+					logger.Debug("There is no wttj url for this company")
 					node = nil
 				} else {
 					node, err = dom.GetSearchResults(id, 0, count).Do(ctx)
@@ -158,27 +186,26 @@ func validateWTTJURL(ctx context.Context, companyURL string, companyName string)
 				return nil
 			}),
 		)
-		if err != nil {
-			i = i + 1
-		} else {
-			break
-		}
-	}
+	})
 	if err != nil {
-		log.Printf("Error while performing the automation logic : %v", err)
+		captureErrorArtifacts(ctx, PAGE_WTTJ_COMPANY, searchURL.String(), err)
+		return false, fmt.Errorf("unable to read the wttj company page: %w", err)
 	}
 
 	title := ""
+	if node == nil {
+		captureArtifacts(ctx, PAGE_WTTJ_COMPANY, searchURL.String(), ARTIFACT_FAILURE, "no element matches the selector "+selector)
+	}
 	if node != nil {
 		c := chromedp.FromContext(ctx)
 		html, err = dom.GetOuterHTML().WithNodeID(node[0]).Do(cdp.WithExecutor(ctx, c.Target))
 		if err != nil {
-			log.Printf("Error while getting the wttj company page HTML : %v ", err)
+			return false, fmt.Errorf("unable to get the wttj company page HTML: %w", err)
 		}
 
 		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
 		if err != nil {
-			log.Printf("Error while opening the company wttj HTML as a goquery document : %v", err)
+			return false, fmt.Errorf("unable to parse the wttj company page HTML: %w", err)
 		}
 
 		title = doc.Find("h1").Text()
@@ -189,44 +216,64 @@ func validateWTTJURL(ctx context.Context, companyURL string, companyName string)
 	companyNameWithoutSpaces := strings.TrimSpace(companyName)
 
 	if (strings.Contains(title, companyName)) || (strings.Contains(title, companyNameWithoutSpaces)) {
-		return true
+		captureArtifacts(ctx, PAGE_WTTJ_COMPANY, searchURL.String(), ARTIFACT_SUCCESS, "")
+		return true, nil
 	} else {
-		return false
+		if node != nil {
+			captureArtifacts(ctx, PAGE_WTTJ_COMPANY, searchURL.String(), ARTIFACT_FAILURE, fmt.Sprintf("the title %q does not contain the company name", title))
+		}
+		return false, nil
 	}
 }
 
 // Define a function to enrich a list of companies with their welcome to the jungle url.
 func enrichCompanyWTTJUrl(ctx context.Context, db *pgxpool.Pool, companyName string) error {
 	var err error
+	logger := loggerFromContext(ctx)
 
-	company, exists, err := getCompany(db, companyName)
+	company, exists, err := getCompany(ctx, db, companyName)
 	switch {
 	case err != nil:
-		log.Printf("Database query failed because of : %s", err)
+		logger.Error("Database query failed", LOG_ERROR, err)
 	case !exists:
-		log.Printf("The company does not exist")
+		logger.Warn("The company does not exist")
 	default:
 		if company.WTTJURL == "" {
 			WTTJURL, err := findCompanyWTTJURL(ctx, companyName)
 			if err != nil {
+				enrichmentsTotal.WithLabelValues("wttj", ENRICHMENT_ERROR).Inc()
 				return err
 			}
 
+			// The company page is opened once the search tab is given back, a company never holding two tabs
+			if WTTJURL != "" {
+				valid, err := validateWTTJURL(ctx, WTTJURL, companyName)
+				if err != nil {
+					enrichmentsTotal.WithLabelValues("wttj", ENRICHMENT_ERROR).Inc()
+					return err
+				}
+				if !valid {
+					WTTJURL = ""
+				}
+			}
+
 			if WTTJURL == "" {
-				log.Printf("%s wttj url has not been found", companyName)
+				enrichmentsTotal.WithLabelValues("wttj", ENRICHMENT_NOT_FOUND).Inc()
+				logger.Info("The wttj url has not been found")
 			} else {
-				log.Printf("WTTJ url has been found for %s", companyName)
-				log.Printf("Updating database")
-				err = updateCompanyWTTJURL(db, companyName, WTTJURL)
+				err = updateCompanyWTTJURL(ctx, db, companyName, WTTJURL)
 				if err != nil {
-					log.Printf("An error happened with the query : %s", err)
+					enrichmentsTotal.WithLabelValues("wttj", ENRICHMENT_ERROR).Inc()
+					logger.Error("An error happened with the query", LOG_ERROR, err)
 					return err
 				}
-				log.Printf("%s has been enriched with it's wttj url : %s", companyName, WTTJURL)
+				enrichmentsTotal.WithLabelValues("wttj", ENRICHMENT_FOUND).Inc()
+				logger.Info("The company has been enriched with its wttj url", LOG_URL, WTTJURL)
 			}
 
 		} else {
-			log.Printf("Company %s already has it's WTTJ url enriched.", companyName)
+			enrichmentsTotal.WithLabelValues("wttj", ENRICHMENT_SKIPPED).Inc()
+			logger.Debug("The company already has its wttj url enriched")
 		}
 	}
 
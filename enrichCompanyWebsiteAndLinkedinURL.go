@@ -2,16 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"gopkg.in/yaml.v2"
 )
 
 // This variable helps to create custom json body to make the call on the crunchbase organization search API
@@ -46,33 +44,47 @@ type SearchResponse struct {
 	} `json:"entities"`
 }
 
-func enrichWebsiteAndLinkedinURL(db *pgxpool.Pool, companyName string) error {
+func enrichWebsiteAndLinkedinURL(ctx context.Context, db *pgxpool.Pool, companyName string) error {
+	logger := loggerFromContext(ctx)
 
-	company, _, err := getCompany(db, companyName)
+	company, _, err := getCompany(ctx, db, companyName)
 	if err != nil {
-		log.Printf("An error occured : %v", err)
+		enrichmentsTotal.WithLabelValues("website", ENRICHMENT_ERROR).Inc()
+		enrichmentsTotal.WithLabelValues("linkedin", ENRICHMENT_ERROR).Inc()
+		return fmt.Errorf("unable to read the company: %w", err)
 	}
 
 	if company.Website != "" && company.LinkedInURL != "" {
+		enrichmentsTotal.WithLabelValues("website", ENRICHMENT_SKIPPED).Inc()
+		enrichmentsTotal.WithLabelValues("linkedin", ENRICHMENT_SKIPPED).Inc()
 		return nil
 	}
 
-	// Open the YAML file containing the API key.
-	file, err := os.Open("secrets/crunchbase-api-key.yaml")
-	if err != nil {
-		log.Printf("Error while opening the yaml file contaning the API key : %v", err)
+	// This function records the outcome of the lookup for the urls the company is missing
+	recordOutcome := func(website string, linkedInURL string, outcome string) {
+		if company.Website == "" {
+			if outcome == ENRICHMENT_FOUND && website == "" {
+				enrichmentsTotal.WithLabelValues("website", ENRICHMENT_NOT_FOUND).Inc()
+			} else {
+				enrichmentsTotal.WithLabelValues("website", outcome).Inc()
+			}
+		}
+		if company.LinkedInURL == "" {
+			if outcome == ENRICHMENT_FOUND && linkedInURL == "" {
+				enrichmentsTotal.WithLabelValues("linkedin", ENRICHMENT_NOT_FOUND).Inc()
+			} else {
+				enrichmentsTotal.WithLabelValues("linkedin", outcome).Inc()
+			}
+		}
 	}
 
-	// Read the YAML file into a map.
-	var data map[string]interface{}
-	err = yaml.NewDecoder(file).Decode(&data)
-	if err != nil {
-		log.Printf(" Error while decoding the yaml file into a map : %v", err)
+	// Without API key the urls are left to the other sources
+	apiKey := appConfig.Crunchbase.APIKey
+	if apiKey == "" {
+		recordOutcome("", "", ENRICHMENT_SKIPPED)
+		return nil
 	}
 
-	// Get the API key from the map.
-	apiKey := data["api_key"].(string)
-
 	// Create a new SearchRequest object
 	searchRequest := SearchRequest{
 		FieldIDs: []string{"identifier", "website_url", "linkedin"},
@@ -92,7 +104,7 @@ func enrichWebsiteAndLinkedinURL(db *pgxpool.Pool, companyName string) error {
 				Type:       "predicate",
 				FieldID:    "location_identifiers",
 				OperatorID: "includes",
-				Values:     []string{"f134827e-36a1-fd31-a82f-950489e103ef"},
+				Values:     []string{appConfig.Crunchbase.LocationID},
 			},
 		},
 		Limit: 1,
@@ -101,22 +113,24 @@ func enrichWebsiteAndLinkedinURL(db *pgxpool.Pool, companyName string) error {
 	// Marshal the SearchRequest object into JSON
 	jsonBytes, err := json.Marshal(searchRequest)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		recordOutcome("", "", ENRICHMENT_ERROR)
+		return fmt.Errorf("unable to encode the crunchbase search request: %w", err)
 	}
 
 	// Create a new HTTPS client
 	client := http.Client{}
 
 	// Create a new HTTP GET request
-	req, err := http.NewRequest("POST", "https://api.crunchbase.com/api/v4/searches/organizations", bytes.NewReader(jsonBytes))
+	ctx, span := startSpan(ctx, "crunchbase.search")
+	defer span.End()
+	req, err := http.NewRequestWithContext(ctx, "POST", appConfig.Crunchbase.SearchURL, bytes.NewReader(jsonBytes))
+	if err != nil {
+		recordOutcome("", "", ENRICHMENT_ERROR)
+		return fmt.Errorf("unable to create the crunchbase search request: %w", err)
+	}
 
 	// Set the API key in the HTTP header
 	req.Header.Add("X-cb-user-key", apiKey)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
 
 	// Set the HTTP request header `Content-Type` to `application/json`
 	req.Header.Set("Content-Type", "application/json")
@@ -125,56 +139,90 @@ func enrichWebsiteAndLinkedinURL(db *pgxpool.Pool, companyName string) error {
 	req.Header.Set("accept", "application/json")
 
 	// Run the request, if the body is not valid then the API limit has been reached so stops for 50 seconds then retry the request.
-	// If the body is valid then append it to the companies list.
+	// If the body is valid then the company is enriched with the first organization found.
+	// The errors are returned for the stage to be recorded as failed, and searched again by the next crawl.
 	for {
-		// Execute the HTTP request
-		resp, err := client.Do(req)
+		// The body of the request is read by each attempt, it is rewound before sending it again
+		req.Body, err = req.GetBody()
 		if err != nil {
-			fmt.Println(err)
-			return nil
+			recordOutcome("", "", ENRICHMENT_ERROR)
+			return fmt.Errorf("unable to create the crunchbase search request: %w", err)
 		}
 
-		// Close the HTTP response body
-		defer resp.Body.Close()
+		// Execute the HTTP request, the crunchbase API sharing the limits of the other hosts
+		release, err := acquireHost(ctx, appConfig.Crunchbase.SearchURL)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		release()
+		if err != nil {
+			crunchbaseRequestsTotal.WithLabelValues("error").Inc()
+			recordOutcome("", "", ENRICHMENT_ERROR)
+			recordSpanError(span, err)
+			return fmt.Errorf("unable to send the crunchbase search request: %w", err)
+		}
 
-		// Read the HTTP response body
+		// Read the HTTP response body, closing it before the next attempt
 		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			fmt.Println(err)
-			return nil
+			crunchbaseRequestsTotal.WithLabelValues("error").Inc()
+			recordOutcome("", "", ENRICHMENT_ERROR)
+			recordSpanError(span, err)
+			return fmt.Errorf("unable to read the crunchbase search response: %w", err)
 		}
 
-		if json.Valid([]byte(body)) {
-			// Create a new SearchResponse variable to store the unmarshaled response
-			var searchResponse SearchResponse
-
-			// Unmarshal the JSON response body into a Company object
-			if err := json.Unmarshal(body, &searchResponse); err != nil {
-				fmt.Println(err)
-				return nil
+		if !json.Valid(body) {
+			crunchbaseRequestsTotal.WithLabelValues("rate_limited").Inc()
+			span.AddEvent("rate_limited")
+			wait := appConfig.Crunchbase.RateLimitWait
+			logger.Warn("The crunchbase API limit might have been reached, pausing the search", "wait", wait.String())
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
 			}
+			crunchbaseRateLimitWaitSeconds.Add(wait.Seconds())
+			logger.Info("Resuming the crunchbase search")
+			continue
+		}
+		crunchbaseRequestsTotal.WithLabelValues("ok").Inc()
 
-			if searchResponse.Count != 0 {
-				if company.Website == "" {
-					WebsiteURL := removeTrailingSlash(searchResponse.Entities[0].Properties.WebsiteURL)
-					updateCompanyWebsiteURL(db, company.Name, WebsiteURL)
-					log.Printf("%s has been enriched with it's website url : %s", companyName, WebsiteURL)
-				}
-				if company.LinkedInURL == "" {
-					LinkedInURL := removeTrailingSlash(searchResponse.Entities[0].Properties.LinkedInURL.Value)
-					updateCompanyLinkedinURL(db, company.Name, LinkedInURL)
-					log.Printf("%s has been enriched with it's wttj url : %s", companyName, LinkedInURL)
-				}
-			}
+		// Create a new SearchResponse variable to store the unmarshaled response
+		var searchResponse SearchResponse
 
-			break
-		} else {
-			// handle the error here
-			fmt.Println("\n The crunchbase API limit might has been reached, stopping for 50 secs")
-			time.Sleep(50 * time.Second)
-			fmt.Println("\n Resuming the search")
+		// Unmarshal the JSON response body into a Company object
+		if err := json.Unmarshal(body, &searchResponse); err != nil {
+			recordOutcome("", "", ENRICHMENT_ERROR)
+			recordSpanError(span, err)
+			return fmt.Errorf("unable to decode the crunchbase search response: %w", err)
 		}
-	}
 
-	return err
+		// The count is the number of organizations matching, the entities being empty when none is returned
+		if searchResponse.Count == 0 || len(searchResponse.Entities) == 0 {
+			recordOutcome("", "", ENRICHMENT_NOT_FOUND)
+			return nil
+		}
+
+		properties := searchResponse.Entities[0].Properties
+		if company.Website == "" {
+			WebsiteURL := removeTrailingSlash(properties.WebsiteURL)
+			if err := updateCompanyWebsiteURL(ctx, db, company.Name, WebsiteURL); err != nil {
+				recordOutcome("", "", ENRICHMENT_ERROR)
+				return fmt.Errorf("unable to update the company website url: %w", err)
+			}
+			logger.Info("The company has been enriched with its website url", LOG_URL, WebsiteURL)
+		}
+		if company.LinkedInURL == "" {
+			LinkedInURL := removeTrailingSlash(properties.LinkedInURL.Value)
+			if err := updateCompanyLinkedinURL(ctx, db, company.Name, LinkedInURL); err != nil {
+				recordOutcome("", "", ENRICHMENT_ERROR)
+				return fmt.Errorf("unable to update the company linkedin url: %w", err)
+			}
+			logger.Info("The company has been enriched with its linkedin url", LOG_URL, LinkedInURL)
+		}
+		recordOutcome(properties.WebsiteURL, properties.LinkedInURL.Value, ENRICHMENT_FOUND)
+		return nil
+	}
 }
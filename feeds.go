@@ -0,0 +1,478 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The number of offers listed in a feed
+const FEED_MAX_ITEMS = 50
+
+// The formats in which the feeds are available, with their content type
+const FEED_RSS = "rss"
+const FEED_ATOM = "atom"
+const FEED_JSON = "json"
+
+var feedContentTypes = map[string]string{
+	FEED_RSS:  "application/rss+xml; charset=utf-8",
+	FEED_ATOM: "application/atom+xml; charset=utf-8",
+	FEED_JSON: "application/feed+json; charset=utf-8",
+}
+
+// This variable stores a feed independently of the format it is rendered in
+type Feed struct {
+	Title       string
+	Description string
+	// The url of the feed itself
+	FeedURL string
+	// The url of the page the feed is about
+	HomeURL string
+	// A private feed belongs to a user, the shared caches must not keep it
+	Private bool
+	Updated time.Time
+	Items   []OfferSearchResult
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       atomLink       `xml:"link"`
+	Author     atomPerson     `xml:"author"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	ContentText   string           `json:"content_text"`
+	DatePublished string           `json:"date_published"`
+	Authors       []jsonFeedAuthor `json:"authors"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// This function returns the tags describing an offer in the feeds
+func offerFeedTags(offer OfferSearchResult) []string {
+	var tags []string
+	for _, tag := range []string{offer.Category, offer.ContractType, offer.Location} {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if offer.Remote {
+		tags = append(tags, "remote")
+	}
+	return tags
+}
+
+// This function returns the text describing an offer in the feeds, without the search highlights
+func offerFeedSummary(offer OfferSearchResult) string {
+	return strings.NewReplacer("<mark>", "", "</mark>", "").Replace(offer.Snippet)
+}
+
+func renderRSSFeed(feed Feed) ([]byte, error) {
+	rss := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       feed.Title,
+			Link:        feed.HomeURL,
+			Description: feed.Description,
+			AtomLink:    rssAtomLink{Href: feed.FeedURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !feed.Updated.IsZero() {
+		rss.Channel.LastBuildDate = feed.Updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, offer := range feed.Items {
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       offer.Title + " - " + offer.CompanyName,
+			Link:        offer.OfferURL,
+			Description: offerFeedSummary(offer),
+			GUID:        rssGUID{IsPermaLink: true, Value: offer.OfferURL},
+			PubDate:     offer.FirstSeen.UTC().Format(time.RFC1123Z),
+			Categories:  offerFeedTags(offer),
+		})
+	}
+
+	output, err := xml.MarshalIndent(rss, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to render the rss feed: %w", err)
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
+
+func renderAtomFeed(feed Feed) ([]byte, error) {
+	// The updated date is mandatory in atom, an empty feed is considered updated now
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+
+	atom := atomFeed{
+		Title:   feed.Title,
+		ID:      feed.FeedURL,
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: feed.FeedURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: feed.HomeURL, Rel: "alternate"},
+		},
+		Author: atomPerson{Name: "french-top-jobs"},
+	}
+
+	for _, offer := range feed.Items {
+		var categories []atomCategory
+		for _, tag := range offerFeedTags(offer) {
+			categories = append(categories, atomCategory{Term: tag})
+		}
+		atom.Entries = append(atom.Entries, atomEntry{
+			Title:      offer.Title,
+			ID:         offer.OfferURL,
+			Updated:    offer.FirstSeen.UTC().Format(time.RFC3339),
+			Published:  offer.FirstSeen.UTC().Format(time.RFC3339),
+			Link:       atomLink{Href: offer.OfferURL, Rel: "alternate"},
+			Author:     atomPerson{Name: offer.CompanyName},
+			Summary:    offerFeedSummary(offer),
+			Categories: categories,
+		})
+	}
+
+	output, err := xml.MarshalIndent(atom, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to render the atom feed: %w", err)
+	}
+
+	return append([]byte(xml.Header), output...), nil
+}
+
+func renderJSONFeed(feed Feed) ([]byte, error) {
+	jsonfeed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feed.Title,
+		Description: feed.Description,
+		HomePageURL: feed.HomeURL,
+		FeedURL:     feed.FeedURL,
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, offer := range feed.Items {
+		jsonfeed.Items = append(jsonfeed.Items, jsonFeedItem{
+			ID:            offer.OfferURL,
+			URL:           offer.OfferURL,
+			Title:         offer.Title,
+			ContentText:   offerFeedSummary(offer),
+			DatePublished: offer.FirstSeen.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedAuthor{{Name: offer.CompanyName}},
+			Tags:          offerFeedTags(offer),
+		})
+	}
+
+	output, err := json.MarshalIndent(jsonfeed, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to render the json feed: %w", err)
+	}
+
+	return output, nil
+}
+
+// This function returns the url of the current request as seen by the client: under server.public_url when it is set, else as
+// told by the X-Forwarded-Proto and X-Forwarded-Host headers of a trusted proxy, else as received
+func requestURL(c *gin.Context) string {
+	if appConfig.Server.PublicURL != "" {
+		return strings.TrimSuffix(appConfig.Server.PublicURL, "/") + c.Request.URL.RequestURI()
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if appConfig.Server.isTrustedProxy(c.RemoteIP()) {
+		if proto := strings.ToLower(c.GetHeader("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := c.GetHeader("X-Forwarded-Host"); forwardedHost != "" {
+			host = strings.TrimSpace(strings.Split(forwardedHost, ",")[0])
+		}
+	}
+	return scheme + "://" + host + c.Request.URL.RequestURI()
+}
+
+// This function returns the query of the current request without the API token a feed reader may send in it, so the token
+// does not end up in the feed links
+func feedQuery(c *gin.Context) url.Values {
+	query := c.Request.URL.Query()
+	query.Del("token")
+	return query
+}
+
+// This function returns the url of the current feed, without the API token of the feed reader
+func feedSelfURL(c *gin.Context) string {
+	selfURL := baseURL(c) + c.Request.URL.EscapedPath()
+	if query := feedQuery(c); len(query) > 0 {
+		selfURL += "?" + query.Encode()
+	}
+	return selfURL
+}
+
+// This function returns the url of the root of the server as seen by the client
+func baseURL(c *gin.Context) string {
+	return strings.TrimSuffix(requestURL(c), c.Request.URL.RequestURI())
+}
+
+// This function returns an etag identifying the offers of a feed, it changes as soon as an offer is added or removed
+func feedETag(feed Feed, format string) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\n%s\n", format, feed.Title)
+	for _, offer := range feed.Items {
+		fmt.Fprintf(hash, "%d %s\n", offer.ID, offer.FirstSeen.UTC().Format(time.RFC3339Nano))
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// This function returns true if the client already has the current version of the feed
+func isFeedNotModified(c *gin.Context, etag string, updated time.Time) bool {
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == etag || candidate == "W/"+etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := c.GetHeader("If-Modified-Since"); ifModifiedSince != "" && !updated.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !updated.Truncate(time.Second).After(since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// This function answers a request with a feed in the requested format, or with a 304 if the client already has it
+func writeFeed(c *gin.Context, feed Feed, format string) {
+	if _, ok := feedContentTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The format must be rss, atom or json"})
+		return
+	}
+
+	for _, offer := range feed.Items {
+		if offer.FirstSeen.After(feed.Updated) {
+			feed.Updated = offer.FirstSeen
+		}
+	}
+
+	etag := feedETag(feed, format)
+	c.Header("ETag", etag)
+	if feed.Private {
+		c.Header("Cache-Control", "private, max-age=300")
+	} else {
+		c.Header("Cache-Control", "public, max-age=300")
+	}
+	if !feed.Updated.IsZero() {
+		c.Header("Last-Modified", feed.Updated.UTC().Format(http.TimeFormat))
+	}
+
+	if isFeedNotModified(c, etag, feed.Updated) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	var output []byte
+	var err error
+	switch format {
+	case FEED_RSS:
+		output, err = renderRSSFeed(feed)
+	case FEED_ATOM:
+		output, err = renderAtomFeed(feed)
+	case FEED_JSON:
+		output, err = renderJSONFeed(feed)
+	}
+	if err != nil {
+		slog.Error("An error happened while rendering the feed", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The feed could not be rendered"})
+		return
+	}
+
+	c.Data(http.StatusOK, feedContentTypes[format], output)
+}
+
+// This function returns a handler serving the offers of a company in the given format
+func companyOffersFeedAPI(format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		company, ok := getCompanyFromPath(c)
+		if !ok {
+			return
+		}
+
+		response, err := searchOffers(c.Request.Context(), dbpoolapi, OfferSearchParams{CompanyName: company.Name, Sort: "date", Limit: FEED_MAX_ITEMS})
+		if err != nil {
+			slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "The search failed"})
+			return
+		}
+
+		homeURL := company.JobsPageURL
+		if homeURL == "" {
+			homeURL = baseURL(c) + "/company?name=" + url.QueryEscape(company.Name)
+		}
+
+		writeFeed(c, Feed{
+			Title:       company.Name + " job offers",
+			Description: "The job offers found on the " + company.Name + " job page",
+			FeedURL:     feedSelfURL(c),
+			HomeURL:     homeURL,
+			Items:       response.Results,
+		}, format)
+	}
+}
+
+// This function serves the offers matching the search given in the query parameters, in the format given by the format parameter
+func searchFeedAPI(c *gin.Context) {
+	params, err := parseOfferSearchParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params.Sort = "date"
+	params.Limit = FEED_MAX_ITEMS
+	params.Offset = 0
+
+	response, err := searchOffers(c.Request.Context(), dbpoolapi, params)
+	if err != nil {
+		slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The search failed"})
+		return
+	}
+
+	title := "Job offers"
+	if params.Query != "" {
+		title = "Job offers matching " + params.Query
+	}
+
+	writeFeed(c, Feed{
+		Title:       title,
+		Description: "The job offers matching a search",
+		FeedURL:     feedSelfURL(c),
+		HomeURL:     baseURL(c) + "/search?" + feedQuery(c).Encode(),
+		Items:       response.Results,
+	}, c.DefaultQuery("format", FEED_ATOM))
+}
+
+// This function serves the offers matching a saved search, in the format given by the format parameter
+func savedSearchFeedAPI(c *gin.Context) {
+	search, ok := getSavedSearchFromPath(c)
+	if !ok {
+		return
+	}
+
+	params := search.searchParams()
+	params.Sort = "date"
+	params.Limit = FEED_MAX_ITEMS
+
+	response, err := searchOffers(c.Request.Context(), dbpoolapi, params)
+	if err != nil {
+		slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The search failed"})
+		return
+	}
+
+	writeFeed(c, Feed{
+		Title:       search.Name,
+		Description: "The job offers matching the saved search " + search.Name,
+		FeedURL:     feedSelfURL(c),
+		HomeURL:     fmt.Sprintf("%s/saved-searches/%d/offers", baseURL(c), search.ID),
+		Private:     true,
+		Items:       response.Results,
+	}, c.DefaultQuery("format", FEED_ATOM))
+}
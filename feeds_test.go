@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		publicURL      string
+		trustedProxies string
+		remoteAddr     string
+		headers        map[string]string
+		want           string
+	}{
+		{"as received", "", "", "203.0.113.7:4000", nil, "http://api.example.com/feeds/search?q=go"},
+		{"untrusted forwarded headers", "", "", "203.0.113.7:4000",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.net"}, "http://api.example.com/feeds/search?q=go"},
+		{"other proxy", "", "10.0.0.0/8", "203.0.113.7:4000",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.net"}, "http://api.example.com/feeds/search?q=go"},
+		{"trusted proxy range", "", "10.0.0.0/8", "10.1.2.3:4000",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "jobs.example.com"}, "https://jobs.example.com/feeds/search?q=go"},
+		{"trusted proxy address", "", "127.0.0.1, 10.0.0.0/8", "127.0.0.1:4000",
+			map[string]string{"X-Forwarded-Proto": "https"}, "https://api.example.com/feeds/search?q=go"},
+		{"invalid forwarded scheme", "", "10.0.0.0/8", "10.1.2.3:4000",
+			map[string]string{"X-Forwarded-Proto": "javascript"}, "http://api.example.com/feeds/search?q=go"},
+		{"public url", "https://jobs.example.com/", "", "203.0.113.7:4000",
+			map[string]string{"X-Forwarded-Host": "evil.example.net"}, "https://jobs.example.com/feeds/search?q=go"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			appConfig = defaultConfig()
+			appConfig.Server.PublicURL = test.publicURL
+			appConfig.Server.TrustedProxies = test.trustedProxies
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "http://api.example.com/feeds/search?q=go", nil)
+			c.Request.RemoteAddr = test.remoteAddr
+			for name, value := range test.headers {
+				c.Request.Header.Set(name, value)
+			}
+
+			if got := requestURL(c); got != test.want {
+				t.Errorf("requestURL() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The version of the schema the code expects, it is bumped with each change of db/dataset/init.sql
+const SCHEMA_VERSION = 5
+
+// The time given to each readiness check
+const READINESS_TIMEOUT = 5 * time.Second
+
+// The time given to the launch of, or the connection to, the browser, and the time its result is kept so the probes do not open one each time
+const CHROME_CHECK_TIMEOUT = 30 * time.Second
+const CHROME_CHECK_CACHE = time.Minute
+
+// This variable stores the result of the last browser check
+var chromeCheck struct {
+	sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// This function returns the version of the schema of the database
+func getSchemaVersion(ctx context.Context, db *pgxpool.Pool) (int, error) {
+	var version int
+	err := db.QueryRow(ctx, `SELECT coalesce(max(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return version, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	return version, nil
+}
+
+// This function checks that a connection of the pool can reach the database
+func checkDatabase(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, READINESS_TIMEOUT)
+	defer cancel()
+
+	err := db.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to reach the database: %w", err)
+	}
+
+	return nil
+}
+
+// This function checks that the database has the schema the code expects
+func checkSchema(ctx context.Context, db *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, READINESS_TIMEOUT)
+	defer cancel()
+
+	version, err := getSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if version < SCHEMA_VERSION {
+		return fmt.Errorf("the schema version is %d, %d is expected", version, SCHEMA_VERSION)
+	}
+
+	return nil
+}
+
+// This function checks that the browser of the crawler answers : the remote browser of browser.remote_url, or a local chrome that
+// can be launched. The result is kept for a minute.
+func checkChrome(ctx context.Context) error {
+	chromeCheck.Lock()
+	defer chromeCheck.Unlock()
+
+	if time.Since(chromeCheck.checkedAt) < CHROME_CHECK_CACHE {
+		return chromeCheck.err
+	}
+
+	// The result is shared by the probes, a probe that hangs up does not cancel the launch
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CHROME_CHECK_TIMEOUT)
+	defer cancel()
+	ctx, cancelAllocator := newBrowserAllocator(ctx)
+	defer cancelAllocator()
+	ctx, cancelBrowser := chromedp.NewContext(ctx)
+	defer cancelBrowser()
+
+	err := chromedp.Run(ctx, chromedp.Navigate("about:blank"))
+	if err != nil && appConfig.Browser.RemoteURL != "" {
+		err = fmt.Errorf("unable to connect to the browser at %s: %w", appConfig.Browser.RemoteURL, err)
+	} else if err != nil {
+		err = fmt.Errorf("unable to launch a headless chrome: %w", err)
+	}
+	chromeCheck.checkedAt = time.Now()
+	chromeCheck.err = err
+
+	return err
+}
+
+// This function tells that the process is alive, it does not check the dependencies so a database outage does not restart it
+func healthzAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// This function tells whether the server can take requests : the database is reachable and has the expected schema
+func readyzAPI(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+
+	err := checkDatabase(c.Request.Context(), dbpoolapi)
+	if err != nil {
+		checks["database"] = err.Error()
+		checks["schema"] = "not checked"
+		ready = false
+	} else {
+		checks["database"] = "ok"
+		err = checkSchema(c.Request.Context(), dbpoolapi)
+		if err != nil {
+			checks["schema"] = err.Error()
+			ready = false
+		} else {
+			checks["schema"] = "ok"
+		}
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
+
+// This function tells whether the browser of the crawler answers. It is reserved to the admins, as it opens a browser.
+func chromeReadyzAPI(c *gin.Context) {
+	err := checkChrome(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": gin.H{"chrome": err.Error()}})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": gin.H{"chrome": "ok"}})
+}
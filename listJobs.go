@@ -2,87 +2,103 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"regexp"
-	"strings"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
-	"github.com/chromedp/cdproto/cdp"
-	"github.com/chromedp/cdproto/dom"
-	"github.com/chromedp/chromedp"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Offer struct {
-	companyName string
-	offerUrl    string
+	id           int
+	companyName  string
+	offerUrl     string
+	title        string
+	description  string
+	category     string
+	contractType string
+	location     string
+	remote       bool
+	firstSeen    time.Time
 }
 
-// This function finds all links on a website and returns them as a list
-func findAllLinks(ctx context.Context, website string) []string {
-
-	var links []string
-
-	// Create the request context
-	ctx, cancel := createTab(ctx)
-	defer cancel()
-
-	html := ""
-	var err error
-	for i := 0; i < 5; i++ {
-		err := chromedp.Run(ctx,
-			// visit the target page
-			chromedp.Navigate(website),
-			// wait for the page to load
-			chromedp.Sleep(4000*time.Millisecond),
-		)
-		if err != nil {
-			i = i + 1
-		} else {
-			break
-		}
-	}
+// This variable stores a link found on a page and the text of its anchor
+type Link struct {
+	URL  string
+	Text string
+}
 
-	if err != nil {
-		log.Printf("Error while performing the automation logic : %v", err)
-	}
+// This function finds all links on a jobs page and returns them as a list. The page is expanded, and its next pages and the job lists
+// it embeds in iframes are read too, up to pagination.max_pages pages, until a page brings no new link.
+// It returns an error when the jobs page itself cannot be read, the next pages and iframes that fail being skipped.
+func findAllLinks(ctx context.Context, website string) (links []Link, err error) {
+	logger := loggerFromContext(ctx).With(LOG_URL, website)
 
-	c := chromedp.FromContext(ctx)
-	rootNode, err := dom.GetDocument().Do(cdp.WithExecutor(ctx, c.Target))
-	if err != nil {
-		log.Print(err)
-	}
+	ctx, span := startSpan(ctx, "jobs_page.find_links", SPAN_URL.String(website))
+	defer func() { endSpan(span, err) }()
 
-	html, err = dom.GetOuterHTML().WithNodeID(rootNode.NodeID).Do(cdp.WithExecutor(ctx, c.Target))
+	// Create the request context
+	ctx, cancel, err := createTab(ctx)
 	if err != nil {
-		log.Print(err)
+		return links, fmt.Errorf("unable to open a tab: %w", err)
 	}
+	defer cancel()
 
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-	if err != nil {
-		log.Print(err)
+	type pageToRead struct {
+		url    string
+		number int
+		action string
 	}
+	queue := []pageToRead{{url: website, number: 1}}
+	read := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for len(queue) > 0 && len(read) < appConfig.Pagination.MaxPages {
+		page := queue[0]
+		queue = queue[1:]
+		if read[page.url] {
+			continue
+		}
+		read[page.url] = true
+
+		content, err := readJobsPage(ctx, page.url, page.number)
+		switch {
+		case err != nil && page.url == website && isPageSkipped(err):
+			logger.Info("The jobs page is not searched for offers", LOG_ERROR, err)
+			return links, nil
+		case err != nil && page.url == website:
+			return links, fmt.Errorf("unable to read the jobs page: %w", err)
+		case err != nil:
+			logger.Warn("Unable to read a page of the jobs list", LOG_URL, page.url, LOG_ERROR, err)
+			continue
+		}
 
-	// Find all the href links in the HTML document
-	hrefs := doc.Find("a")
+		newLinks := 0
+		for _, link := range content.links {
+			if !seen[link.URL] {
+				seen[link.URL] = true
+				links = append(links, link)
+				newLinks++
+			}
+		}
 
-	// Iterate over the links and print them to the console
-	for i := 0; i < hrefs.Length(); i++ {
-		href := hrefs.Eq(i)
-		// Get the href attribute of the link
-		link, ok := href.Attr("href")
-		if ok {
-			link = strings.TrimSpace(link)
-			if strings.Contains(website, "welcometothejungle") {
-				website = "https://www.welcometothejungle.com"
+		// A page bringing no new link ends the pagination
+		if page.url != website {
+			if newLinks == 0 {
+				continue
 			}
-			link = getAbsoluteUrl(website, link)
-			links = append(links, link)
+			paginationRoundsTotal.WithLabelValues(page.action).Inc()
+		}
+		for _, frame := range content.frames {
+			queue = append(queue, pageToRead{url: frame, number: 1, action: PAGINATION_FRAME})
+		}
+		if content.nextPage != "" {
+			queue = append(queue, pageToRead{url: content.nextPage, number: page.number + 1, action: PAGINATION_NEXT_PAGE})
 		}
 	}
 
-	return links
+	return links, nil
 }
 
 // This function verify that the url is about a devops job
@@ -91,33 +107,123 @@ func isDevopsJobUrl(website string) bool {
 	regex := regexp.MustCompile(".*(devops|dev_ops|dev-ops|devsecops|dev_sec_ops|dev-sec-ops|sre|site_reliability_engineer|site-reliability-engineer).*")
 	// pageTitle := getPageTitle(website)
 	if regex.MatchString(website) {
-		log.Printf("This url is a devops job : %s", website)
+		slog.Debug("This url is a devops job", LOG_URL, website)
 		return true
 	} else {
 		return false
 	}
 }
 
-// This function take as parameter a company and add to the database the devops jobs url found on it's job page
-func addJobs(ctx context.Context, db *pgxpool.Pool, company Company) {
+// This function take as parameter a company and add to the database the devops jobs url found on it's job page.
+// It returns an error when the jobs page cannot be read or some offers cannot be added, so the search is done again.
+func addJobs(ctx context.Context, db *pgxpool.Pool, company Company) error {
+	logger := loggerFromContext(ctx)
 
 	if company.JobsPageURL == "" {
-		log.Printf("%s does not have a job page url, exiting", company.Name)
-		return
+		logger.Info("The company does not have a jobs page url, no offer is searched")
+		return nil
 	}
 
-	log.Printf("%s has this job url : %s", company.Name, company.JobsPageURL)
+	logger.Info("Searching the offers on the jobs page", LOG_URL, company.JobsPageURL)
 
-	links := findAllLinks(ctx, company.JobsPageURL)
+	links, err := findAllLinks(ctx, company.JobsPageURL)
+	if err != nil {
+		return err
+	}
 
+	failed := 0
 	for _, link := range links {
-		if isDevopsJobUrl(link) {
-			newOffer := Offer{company.Name, link}
-			err := createJobOffer(db, newOffer)
+		if isDevopsJobUrl(link.URL) {
+			linkLogger := logger.With(LOG_URL, link.URL)
+			err := addJobOffer(withLogger(ctx, linkLogger), db, company, link)
 			if err != nil {
-				log.Printf("An error happened with the query : %s", err)
+				linkLogger.Error("An error happened while adding the offer", LOG_ERROR, err)
+				failed++
 			}
 		}
 	}
+	if failed > 0 {
+		return fmt.Errorf("unable to add %d offers", failed)
+	}
+
+	return nil
+}
+
+// This function adds the offer of a link, unless it is a new url of an offer already known, in which case the url is attached to it as a source
+func addJobOffer(ctx context.Context, db *pgxpool.Pool, company Company, link Link) (err error) {
+	logger := loggerFromContext(ctx)
+
+	ctx, span := startSpan(ctx, "offer.add", SPAN_URL.String(link.URL))
+	defer func() { endSpan(span, err) }()
+
+	canonicalURL, err := canonicalizeOfferURL(link.URL)
+	if err != nil {
+		return err
+	}
+	_, exists, err := touchOfferSource(ctx, db, canonicalURL)
+	if err != nil || exists {
+		if exists {
+			offersTotal.WithLabelValues(OFFER_KNOWN_SOURCE).Inc()
+		}
+		return err
+	}
+
+	newOffer := Offer{companyName: company.Name, offerUrl: link.URL, title: link.Text, category: "devops"}
+
+	// Complete the offer with the details found on its page, the anchor text is kept as title if the page has none
+	details, err := fetchOfferDetails(ctx, link.URL)
+	if err != nil {
+		logger.Warn("An error happened while fetching the offer details", LOG_ERROR, err)
+	}
+	if details.Title != "" {
+		newOffer.title = details.Title
+	}
+	newOffer.description = details.Description
+	newOffer.contractType = details.ContractType
+	newOffer.location = details.Location
+	newOffer.remote = details.Remote
+
+	// The link may redirect to a url that is already known, like a short link to the company ATS
+	sources := map[string]string{canonicalURL: link.URL}
+	if details.FinalURL != "" {
+		finalCanonicalURL, err := canonicalizeOfferURL(details.FinalURL)
+		if err == nil && finalCanonicalURL != canonicalURL {
+			sources[finalCanonicalURL] = details.FinalURL
+			newOffer.offerUrl = details.FinalURL
+
+			offerID, exists, err := touchOfferSource(ctx, db, finalCanonicalURL)
+			if err != nil {
+				return err
+			}
+			if exists {
+				offersTotal.WithLabelValues(OFFER_KNOWN_SOURCE).Inc()
+				return addOfferSource(ctx, db, offerID, link.URL, canonicalURL)
+			}
+		}
+	}
+
+	// The same posting may already have been found on another source, like WTTJ and the company ATS
+	offerID, exists, err := findOfferByDedupKey(ctx, db, offerDedupKey(newOffer.companyName, newOffer.title, newOffer.location))
+	if err != nil {
+		return err
+	}
+	if exists {
+		offersTotal.WithLabelValues(OFFER_OTHER_SOURCE).Inc()
+		logger.Info("The offer is already known from another source", "offer_id", offerID)
+	} else {
+		offerID, err = createJobOffer(ctx, db, newOffer)
+		if err != nil {
+			return err
+		}
+		logger.Info("A new offer has been added", "offer_id", offerID)
+	}
+
+	for sourceCanonicalURL, sourceURL := range sources {
+		err = addOfferSource(ctx, db, offerID, sourceURL, sourceCanonicalURL)
+		if err != nil {
+			return err
+		}
+	}
 
+	return nil
 }
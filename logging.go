@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The attributes attached to the log messages, they are the same everywhere so the logs can be filtered on them
+const LOG_RUN_ID = "run_id"
+const LOG_COMPANY = "company"
+const LOG_STAGE = "stage"
+const LOG_URL = "url"
+const LOG_ERROR = "error"
+const LOG_TRACE_ID = "trace_id"
+const LOG_WORKER_ID = "worker_id"
+const LOG_TASK_ID = "task_id"
+
+// The stages of a crawl, used as the stage attribute of its log messages
+const STAGE_LISTS = "lists"
+const STAGE_WEBSITE = "website"
+const STAGE_WTTJ = "wttj"
+const STAGE_JOBS_PAGE = "jobs_page"
+const STAGE_OFFERS = "offers"
+const STAGE_DEDUP = "dedup"
+const STAGE_DIGESTS = "digests"
+const STAGE_FOLLOW_UPS = "follow_ups"
+
+type loggerContextKey struct{}
+
+// This function sets the default logger, it writes json lines on the standard output at the level given (debug, info, warn or error, info by default)
+func initLogger(levelName string) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(levelName)))
+	if err != nil {
+		level = slog.LevelInfo
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+}
+
+// This function returns a random identifier for a crawl run, it is attached to every message of the run
+func newRunID() string {
+	bytes := make([]byte, 8)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return time.Now().UTC().Format("20060102150405")
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// This function returns a context carrying a logger, the functions it is given to log with its attributes
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// This function returns the logger carried by a context, or the default logger
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// This middleware logs every request of the API server as a json line, in place of the gin text logger
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		attributes := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attributes = append(attributes, LOG_ERROR, c.Errors.String())
+		}
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+			attributes = append(attributes, LOG_TRACE_ID, spanContext.TraceID().String())
+		}
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "request", attributes...)
+	}
+}
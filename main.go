@@ -1,128 +1,446 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// change this for your situation, 20 or 30, 1,000 or 10,000 may be too high
-const MAX_CONCURRENT_JOBS = 20
-
 var dbpoolapi *pgxpool.Pool
 
 func main() {
+	// The crawl, enqueue and create-user commands run once, the worker runs until it is stopped, without command the API server is started.
+	// The flags of the configuration come after the command.
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if command != "" && command != "crawl" && command != "worker" && command != "enqueue" && command != "create-user" && command != "serve" {
+		fmt.Fprintf(os.Stderr, "unknown command %q, the commands are crawl, worker, enqueue, create-user and serve\n", command)
+		os.Exit(2)
+	}
+
+	// With -resume, the crawl skips the stages the companies went through within crawl.resume_window
+	var resume bool
+	config, args, err := loadConfig(command, args, func(flags *flag.FlagSet) {
+		if command == "crawl" {
+			flags.BoolVar(&resume, "resume", false, "skip the stages the companies went through within crawl.resume_window")
+		}
+	})
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	appConfig = config
+
+	initLogger(appConfig.Logging.Level)
+	for _, warning := range appConfig.warnings {
+		slog.Warn(warning)
+	}
 
-	var err error
+	// The commands stop at the first SIGINT or SIGTERM, after finishing the work in progress
+	ctx, stop := notifyShutdown()
+	defer stop()
+
+	switch command {
+	case "crawl":
+		err = enrichmentEngine(ctx, resume)
+	case "worker":
+		err = runWorker(ctx)
+	case "enqueue":
+		err = enqueueCommand(ctx, args)
+	case "create-user":
+		err = createUserCommand(ctx, args)
+	default:
+		err = runServer(ctx)
+	}
+	if err != nil {
+		slog.Error("The command failed", LOG_ERROR, err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+// This function runs the API server until the context given is canceled, the requests in progress are then given time to finish
+func runServer(ctx context.Context) error {
+	shutdownTracer, err := initTracer(ctx, "api")
+	if err != nil {
+		return fmt.Errorf("unable to initialise the tracing: %w", err)
+	}
+	defer shutdownTracer(context.WithoutCancel(ctx))
 
 	// Initiate db connection
-	dbpoolapi, err = initDbConnection()
+	dbpoolapi, err = initDbConnection(ctx)
 	if err != nil {
-		log.Fatalf("Connection initialisation failed because of : %s", err)
+		return err
 	}
 	defer dbpoolapi.Close()
 
-	r := gin.Default()
-
+	r := gin.New()
+	// The forwarded headers, like the X-Forwarded-For of the client address, are only trusted from the configured proxies
+	err = r.SetTrustedProxies(appConfig.Server.trustedProxies())
+	if err != nil {
+		return fmt.Errorf("unable to set the trusted proxies: %w", err)
+	}
+	r.Use(tracingMiddleware(), requestLogger(), metricsMiddleware(), gin.Recovery())
+	r.Use(authenticate())
+
+	// Public routes
+	r.GET("/metrics", metricsAPI())
+	r.GET("/healthz", healthzAPI)
+	r.GET("/readyz", readyzAPI)
+	r.GET("/status", getStatusAPI)
 	r.GET("/companies", getAllCompaniesAPI)
 	r.GET("/company", getCompanyAPI)
+	r.GET("/lists", getCompanyListsAPI)
+	r.GET("/lists/:slug/changes", getCompanyListChangesAPI)
+	r.GET("/search", searchOffersAPI)
+	r.GET("/offers/:id/sources", getOfferSourcesAPI)
+	r.GET("/companies/:slug/offers.rss", companyOffersFeedAPI(FEED_RSS))
+	r.GET("/companies/:slug/offers.atom", companyOffersFeedAPI(FEED_ATOM))
+	r.GET("/companies/:slug/offers.json", companyOffersFeedAPI(FEED_JSON))
+	r.GET("/feeds/search", searchFeedAPI)
+
+	// The feeds of the connected users, which feed readers open with the token query parameter
+	r.GET("/saved-searches/:id/feed", authenticateFeedReader(), requireUser(), savedSearchFeedAPI)
+
+	// Routes of the connected users
+	users := r.Group("/", requireUser())
+	users.GET("/me", getCurrentUserAPI)
+	users.GET("/tokens", getAPITokensAPI)
+	users.POST("/tokens", createAPITokenAPI)
+	users.DELETE("/tokens/:id", deleteAPITokenAPI)
+	users.GET("/saved-searches", getSavedSearchesAPI)
+	users.POST("/saved-searches", createSavedSearchAPI)
+	users.GET("/saved-searches/:id", getSavedSearchAPI)
+	users.PUT("/saved-searches/:id", updateSavedSearchAPI)
+	users.DELETE("/saved-searches/:id", deleteSavedSearchAPI)
+	users.GET("/saved-searches/:id/offers", getSavedSearchOffersAPI)
+	users.GET("/wishlist", getWishlistAPI)
+	users.PUT("/wishlist/:slug", addCompanyToWishlistAPI)
+	users.DELETE("/wishlist/:slug", removeCompanyFromWishlistAPI)
+	users.GET("/applications", getApplicationsAPI)
+	users.POST("/applications", createApplicationAPI)
+	users.GET("/applications/:id", getApplicationAPI)
+	users.PUT("/applications/:id", updateApplicationAPI)
+	users.DELETE("/applications/:id", deleteApplicationAPI)
+	users.GET("/follow-ups", getDueFollowUpsAPI)
+
+	// Admin routes
+	admins := r.Group("/", requireRole(ROLE_ADMIN))
+	admins.GET("/readyz/chrome", chromeReadyzAPI)
+	admins.GET("/users", getUsersAPI)
+	admins.POST("/users", createUserAPI)
+	admins.DELETE("/users/:id", deleteUserAPI)
+	admins.DELETE("/companies/:slug", deleteCompanyAPI)
+	admins.PUT("/companies/:slug/contractor", updateCompanyContractorAPI)
+	admins.GET("/tasks", getTasksAPI)
+	admins.POST("/tasks", enqueueTasksAPI)
+	admins.GET("/runs/:id/artifacts", getCrawlArtifactsAPI)
+	admins.GET("/artifacts/:id/screenshot", getCrawlArtifactContentAPI(ARTIFACT_SCREENSHOT))
+	admins.GET("/artifacts/:id/html", getCrawlArtifactContentAPI(ARTIFACT_HTML))
+
+	// Web interface
+	r.GET("/", companiesPage)
+	r.GET("/board/companies/:slug", companyPage)
+	r.GET("/board/offers", offersPage)
+	r.GET("/login", loginPage)
+	r.POST("/login", login)
+	r.POST("/logout", verifyCSRFToken(), logout)
+	r.POST("/board/wishlist/:slug", requireUser(), verifyCSRFToken(), toggleWishlistPage)
+	r.GET("/board/applications", requireUser(), applicationsPage)
+	r.POST("/board/applications", requireUser(), verifyCSRFToken(), trackOfferPage)
+	r.POST("/board/applications/:id", requireUser(), verifyCSRFToken(), updateApplicationPage)
+	r.POST("/board/applications/:id/delete", requireUser(), verifyCSRFToken(), deleteApplicationPage)
+
+	server := &http.Server{Addr: appConfig.Server.Addr, Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("The server is listening", "addr", appConfig.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		return fmt.Errorf("unable to serve the API: %w", err)
+	case <-ctx.Done():
+	}
 
-	r.Run()
+	slog.Info("Stopping the server, the requests in progress are given time to finish", "timeout", appConfig.Server.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), appConfig.Server.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		return fmt.Errorf("unable to stop the server: %w", err)
+	}
 
+	return nil
 }
 
-func enrichmentEngine() {
-	// Initiate db connection
-	dbpool, err := initDbConnection()
-	if err != nil {
-		log.Fatalf("Connection initialisation failed because of : %s", err)
+// This function runs a crawl. When the context given is canceled the crawl stops taking new companies,
+// the companies in progress are given crawl.shutdown_timeout to finish and the stages left are not run.
+// A resumed crawl skips the stages each company went through within crawl.resume_window.
+func enrichmentEngine(stopping context.Context, resume bool) (err error) {
+	// Every message of the run carries its id, even the ones logged without a context
+	runID := newRunID()
+	logger := slog.Default().With(LOG_RUN_ID, runID)
+	slog.SetDefault(logger)
+	logger.Info("The crawl is starting")
+	if appConfig.Crunchbase.APIKey == "" {
+		logger.Warn("No crunchbase API key is configured, the companies website and linkedin urls are not enriched")
 	}
-	defer dbpool.Close()
+	serveCrawlMetrics(logger)
+	defer pushCrawlMetrics(logger)
 
-	// Create chrome browser initial context
-	ctx, cancel := createBrowser()
+	ctx, cancel := drainContext(stopping, appConfig.Crawl.ShutdownTimeout, logger)
 	defer cancel()
 
-	// Retrieve the list of the names of the companies to add to the database
-	addTop500Companies(dbpool)
-
-	companiesList := getAllCompanies(dbpool)
-
-	var wg sync.WaitGroup
-	wg.Add(len(companiesList))
-
-	waitChan := make(chan struct{}, MAX_CONCURRENT_JOBS)
+	shutdownTracer, err := initTracer(ctx, "crawl")
+	if err != nil {
+		return fmt.Errorf("unable to initialise the tracing: %w", err)
+	}
+	flushCtx := context.WithoutCancel(ctx)
+	defer func() {
+		err := shutdownTracer(flushCtx)
+		if err != nil {
+			logger.Error("Unable to flush the traces", LOG_ERROR, err)
+		}
+	}()
 
-	// Enrich and add the companies that are not present in the database
-	for _, company := range companiesList {
+	// Initiate db connection
+	dbpool, err := initDbConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbpool.Close()
 
-		waitChan <- struct{}{}
-		go func(company Company) {
+	// Create chrome browser initial context, the browser is closed when the crawl returns
+	browserCtx, cancelBrowser, err := createBrowser(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancelBrowser()
+	ctx, crawlSpan := startSpan(browserCtx, "crawl", SPAN_RUN_ID.String(runID))
+	defer crawlSpan.End()
+	logger = withTraceID(logger, crawlSpan)
+	ctx = withLogger(ctx, logger)
 
-			fmt.Println("")
-			log.Println("Currently working on :", company.Name)
+	progress, err := startCrawlProgress(ctx, dbpool, runID, resume)
+	if err != nil {
+		return err
+	}
+	defer func() { progress.finish(ctx, err) }()
 
-			// Multi threading the 2 next enrich functions
-			var wg2 sync.WaitGroup
-			wg2.Add(2)
+	// The pages budget of a company is shared by its enrichment and its offers search
+	ctx = withPageBudgets(ctx)
 
-			go func() {
-				defer wg2.Done()
-				err = enrichWebsiteAndLinkedinURL(dbpool, company.Name)
-				if err != nil {
-					log.Printf("An error happened while enriching the company website url : %v", err)
-				}
-			}()
+	// The pages the crawl fails to read are captured as artifacts of the run
+	cleanExpiredArtifacts(ctx, dbpool)
+	ctx = withArtifacts(ctx, dbpool, runID)
 
-			go func() {
-				defer wg2.Done()
-				err = enrichCompanyWTTJUrl(ctx, dbpool, company.Name)
-				if err != nil {
-					log.Printf("An error happened while enriching the company WTTJ url : %v", err)
-				}
-			}()
+	// Retrieve the companies lists and add their companies to the database
+	runCrawlStage(ctx, dbpool, runID, STAGE_LISTS, func(ctx context.Context) error {
+		return syncCompanyLists(ctx, dbpool)
+	})
+	if stopping.Err() != nil {
+		return errCrawlInterrupted
+	}
 
-			// Waiting for the 2 functions to end before enriching the company job page url
-			wg2.Wait()
+	companiesList := getAllCompanies(ctx, dbpool)
 
-			err = enrichCompanyJobUrl(ctx, dbpool, company.Name)
-			if err != nil {
-				log.Printf("An error happened while enriching the company job's page url : %v", err)
-			}
+	// Enrich and add the companies that are not present in the database, no company is started once the crawl is stopping
+	enrichPool := newCompanyPool(appConfig.Crawl.MaxConcurrentJobs)
+	for _, company := range companiesList {
+		company := company
+		if !enrichPool.Go(stopping, company.Name, func() error {
+			return enrichCompany(ctx, dbpool, company, progress)
+		}) {
+			break
+		}
+	}
+	enrichSummary := enrichPool.Wait()
+	enrichSummary.log(logger, "The enrichment of the companies is over")
 
-			wg.Done()
-			<-waitChan
-		}(company)
+	// A stage that did not go through all the companies is recorded as failed
+	var enrichErr error
+	if stopping.Err() != nil {
+		enrichErr = errCrawlInterrupted
+	}
+	for _, stage := range []string{STAGE_WEBSITE, STAGE_WTTJ, STAGE_JOBS_PAGE} {
+		recordCrawlStage(ctx, dbpool, runID, stage, enrichSummary.failedCompanies(stage), enrichErr)
+	}
+	if enrichErr != nil {
+		return enrichErr
 	}
-	wg.Wait()
 
 	// Update companies list
-	companiesListUpdated := getAllCompanies(dbpool)
-
-	// Creating waitgroup for offers discovery concurrence search
-	var wgOffers sync.WaitGroup
-	wgOffers.Add(len(companiesListUpdated))
-	waitChanOffers := make(chan struct{}, 2*MAX_CONCURRENT_JOBS)
+	companiesListUpdated := getAllCompanies(ctx, dbpool)
 
 	// Adding jobs urls to the offers table in the database by looping through all the companies
+	offersPool := newCompanyPool(appConfig.Crawl.MaxConcurrentOffers)
 	for _, company := range companiesListUpdated {
+		company := company
+		if !offersPool.Go(stopping, company.Name, func() error {
+			return crawlCompanyOffers(ctx, dbpool, company, progress)
+		}) {
+			break
+		}
+	}
+	offersSummary := offersPool.Wait()
+	offersSummary.log(logger, "The offers of the companies have been crawled")
+	if stopping.Err() != nil {
+		recordCrawlStage(ctx, dbpool, runID, STAGE_OFFERS, offersSummary.failedCompanies(STAGE_OFFERS), errCrawlInterrupted)
+		return errCrawlInterrupted
+	}
+	recordCrawlStage(ctx, dbpool, runID, STAGE_OFFERS, offersSummary.failedCompanies(STAGE_OFFERS), nil)
+
+	// Merge the offers found on several sources
+	runCrawlStage(ctx, dbpool, runID, STAGE_DEDUP, func(ctx context.Context) error {
+		return dedupOffers(ctx, dbpool)
+	})
+
+	// Send the new offers to the saved searches that are due for a digest
+	runCrawlStage(ctx, dbpool, runID, STAGE_DIGESTS, func(ctx context.Context) error {
+		return sendSavedSearchesDigests(ctx, dbpool)
+	})
+
+	// Remind the users of the applications they wanted to follow up
+	runCrawlStage(ctx, dbpool, runID, STAGE_FOLLOW_UPS, func(ctx context.Context) error {
+		return sendFollowUpReminders(ctx, dbpool)
+	})
+	if stopping.Err() != nil {
+		return errCrawlInterrupted
+	}
 
-		waitChanOffers <- struct{}{}
-		go func(company Company) {
+	logger.Info("The crawl is over")
+	return nil
+}
 
-			log.Printf("Now working on %s jobs", company.Name)
+// This function enriches a company with its website, linkedin, WTTJ and jobs page urls, in a trace of its own.
+// The stages the progress tells to skip are not run, the errors of the stages that failed are returned joined, each with its stage.
+func enrichCompany(ctx context.Context, db *pgxpool.Pool, company Company, progress *crawlProgress) error {
+	ctx = withCompanyPageBudget(ctx, company.Name)
+	ctx = withArtifactsCompany(ctx, company.Name)
+	companyCtx, companySpan := startCompanySpan(ctx, "enrich_company", company.Name)
+	defer companySpan.End()
+	companyLogger := withTraceID(loggerFromContext(ctx).With(LOG_COMPANY, company.Name), companySpan)
+	companyLogger.Info("Enriching the company")
+
+	failures := make(map[string]error)
+	var mu sync.Mutex
+	runStage := func(stage string, message string, enrich func(context.Context, *pgxpool.Pool, string) error) {
+		if progress.skip(company.Name, stage) {
+			return
+		}
+		stageLogger := companyLogger.With(LOG_STAGE, stage)
+
+		// The stages run a bounded number at a time, a panic fails the stage of the company and not the crawl
+		err := func() (err error) {
+			defer recoverPanic(&err)
+			release, err := acquireStageSlot(companyCtx, stage)
 			if err != nil {
-				log.Printf("An error happened with the query : %s", err)
+				return err
+			}
+			defer release()
+
+			stageCtx, span := startSpan(withLogger(companyCtx, stageLogger), "enrich."+stage)
+			err = enrich(stageCtx, db, company.Name)
+			endSpan(span, err)
+			return err
+		}()
+		if err != nil {
+			var panicErr *panicError
+			if errors.As(err, &panicErr) {
+				stageLogger.Error(message, LOG_ERROR, err, "stack", string(panicErr.stack))
+			} else {
+				stageLogger.Error(message, LOG_ERROR, err)
 			}
-			addJobs(ctx, dbpool, company)
+			mu.Lock()
+			failures[stage] = &stageError{stage: stage, err: err}
+			mu.Unlock()
+			return
+		}
+		progress.done(companyCtx, company.Name, stage)
+	}
+
+	// Multi threading the 2 next enrich functions
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runStage(STAGE_WEBSITE, "An error happened while enriching the company website url", enrichWebsiteAndLinkedinURL)
+	}()
+	go func() {
+		defer wg.Done()
+		runStage(STAGE_WTTJ, "An error happened while enriching the company WTTJ url", enrichCompanyWTTJUrl)
+	}()
+
+	// Waiting for the 2 functions to end before enriching the company job page url
+	wg.Wait()
+	runStage(STAGE_JOBS_PAGE, "An error happened while enriching the company job's page url", enrichCompanyJobUrl)
+
+	var errs []error
+	for _, stage := range []string{STAGE_WEBSITE, STAGE_WTTJ, STAGE_JOBS_PAGE} {
+		if failures[stage] != nil {
+			errs = append(errs, failures[stage])
+		}
+	}
+	return errors.Join(errs...)
+}
 
-			wgOffers.Done()
-			<-waitChanOffers
-		}(company)
+// This function adds the offers found on the jobs page of a company, in a trace of its own.
+// It returns an error when the offers could not be searched or it was cut short by the cancellation of the context, the stage
+// being only recorded as done once the search succeeded.
+func crawlCompanyOffers(ctx context.Context, db *pgxpool.Pool, company Company, progress *crawlProgress) error {
+	if progress.skip(company.Name, STAGE_OFFERS) {
+		return nil
 	}
-	wgOffers.Wait()
 
+	ctx = withCompanyPageBudget(ctx, company.Name)
+	ctx = withArtifactsCompany(ctx, company.Name)
+	companyCtx, companySpan := startCompanySpan(ctx, "crawl."+STAGE_OFFERS, company.Name)
+	defer companySpan.End()
+	companyCtx = withLogger(companyCtx, withTraceID(loggerFromContext(ctx).With(LOG_COMPANY, company.Name, LOG_STAGE, STAGE_OFFERS), companySpan))
+
+	err := addJobs(companyCtx, db, company)
+	if companyCtx.Err() != nil {
+		return &stageError{stage: STAGE_OFFERS, err: companyCtx.Err()}
+	}
+	if err != nil {
+		loggerFromContext(companyCtx).Error("An error happened while searching the offers", LOG_ERROR, err)
+		return &stageError{stage: STAGE_OFFERS, err: err}
+	}
+	progress.done(companyCtx, company.Name, STAGE_OFFERS)
+	return nil
+}
+
+// This function waits for a free slot in a channel limiting the concurrency, it returns false without slot once the crawl is stopping
+func acquireSlot(stopping context.Context, slots chan struct{}) bool {
+	if stopping.Err() != nil {
+		return false
+	}
+	select {
+	case slots <- struct{}{}:
+		// A slot freed by a company cancelled with the crawl is given back
+		if stopping.Err() != nil {
+			<-slots
+			return false
+		}
+		return true
+	case <-stopping.Done():
+		return false
+	}
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The prefix of the metrics names
+const METRICS_NAMESPACE = "french_top_jobs"
+
+// The pages opened by the crawler, used as the page label of the navigation metrics
+const PAGE_CAREERS = "careers"
+const PAGE_JOBS = "jobs"
+const PAGE_WTTJ_SEARCH = "wttj_search"
+const PAGE_WTTJ_COMPANY = "wttj_company"
+const PAGE_OFFER = "offer"
+
+// The outcomes of an enrichment, used as the outcome label of the enrichment metric
+const ENRICHMENT_FOUND = "found"
+const ENRICHMENT_NOT_FOUND = "not_found"
+const ENRICHMENT_SKIPPED = "skipped"
+const ENRICHMENT_ERROR = "error"
+
+// The outcomes of an offer found on a jobs page, used as the outcome label of the offers metric
+const OFFER_INSERTED = "inserted"
+const OFFER_DUPLICATE_URL = "duplicate_url"
+const OFFER_KNOWN_SOURCE = "known_source"
+const OFFER_OTHER_SOURCE = "other_source"
+
+// The outcomes of a task run by a worker, used as the outcome label of the tasks metric
+const TASK_DONE = "done"
+const TASK_RETRIED = "retried"
+const TASK_FAILED = "failed"
+const TASK_RELEASED = "released"
+const TASK_LOST = "lost"
+
+// This variable stores the pages fetched by the crawler, by page and result
+var pagesFetchedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "pages_fetched_total",
+	Help:      "Pages fetched by the crawler, by page and result.",
+}, []string{"page", "result"})
+
+// This variable stores the pages the crawler did not fetch, by reason (robots or budget)
+var pagesSkippedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "pages_skipped_total",
+	Help:      "Pages the crawler did not fetch to be polite with their host, by reason.",
+}, []string{"reason"})
+
+// This variable stores the retries of the failed fetches, by page
+var fetchRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "fetch_retries_total",
+	Help:      "Retries of the pages whose fetch failed, by page.",
+}, []string{"page"})
+
+// This variable stores the times a domain was found down and its circuit breaker opened
+var circuitBreakerTripsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "circuit_breaker_trips_total",
+	Help:      "Times the circuit breaker of a domain opened.",
+})
+
+// This variable stores the pages read before being ready, by page and readiness strategy
+var pageReadinessTimeoutsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "page_readiness_timeouts_total",
+	Help:      "Pages that were not ready within the readiness timeout, by page and strategy.",
+}, []string{"page", "strategy"})
+
+// This variable stores the rounds of the pagination of the jobs pages that found new links, by action (scroll, load_more, next_page or frame)
+var paginationRoundsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "pagination_rounds_total",
+	Help:      "Rounds of the pagination of the jobs pages that found new links, by action.",
+}, []string{"action"})
+
+// This variable stores the tabs of the pool that were closed, by reason (recycled or unhealthy)
+var browserTabsClosedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "browser_tabs_closed_total",
+	Help:      "Tabs of the browser pool closed, by reason.",
+}, []string{"reason"})
+
+// This variable stores the requests of the pages the browser did not send, by kind (a resource type or tracker)
+var browserRequestsBlockedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "browser_requests_blocked_total",
+	Help:      "Requests of the pages blocked by the browser, by kind.",
+}, []string{"kind"})
+
+// This variable stores the times the browser was restarted as it used too much memory
+var browserRestartsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "browser_restarts_total",
+	Help:      "Times the browser was restarted as it used more than browser.max_memory_mb.",
+})
+
+// This variable stores the memory used by the browser processes when it was last measured
+var browserMemoryBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "browser_memory_bytes",
+	Help:      "Resident memory of the browser processes.",
+})
+
+// This variable stores the pages whose screenshot and HTML were captured, by page and outcome (failure or success)
+var artifactsCapturedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "artifacts_captured_total",
+	Help:      "Pages whose screenshot, HTML and console errors were captured, by page and outcome.",
+}, []string{"page", "outcome"})
+
+// This variable stores the tasks run by the workers, by kind and outcome
+var tasksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "tasks_total",
+	Help:      "Tasks of the queue run by the workers, by kind and outcome.",
+}, []string{"kind", "outcome"})
+
+// This variable stores the duration of the chromedp navigations, retries included in the count but not in the duration
+var navigationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "chromedp_navigation_duration_seconds",
+	Help:      "Duration of the chromedp navigations, by page and result.",
+	Buckets:   []float64{1, 2, 4, 6, 8, 12, 20, 30, 60},
+}, []string{"page", "result"})
+
+// This variable stores the outcome of the enrichments, by source (website, linkedin, wttj or jobs_page)
+var enrichmentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "enrichments_total",
+	Help:      "Company enrichments, by source and outcome.",
+}, []string{"source", "outcome"})
+
+// This variable stores the offer links found on the jobs pages, by what became of them
+var offersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "offers_total",
+	Help:      "Offer links found on the jobs pages, by outcome.",
+}, []string{"outcome"})
+
+// This variable stores the calls to the crunchbase search API, by result (ok, rate_limited or error)
+var crunchbaseRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "crunchbase_requests_total",
+	Help:      "Calls to the crunchbase search API, by result.",
+}, []string{"result"})
+
+// This variable stores the time spent waiting for the crunchbase quota to be restored
+var crunchbaseRateLimitWaitSeconds = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "crunchbase_rate_limit_wait_seconds_total",
+	Help:      "Time spent waiting for the crunchbase API quota.",
+})
+
+// This variable stores the latency of the API requests, by method, route and status
+var apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: METRICS_NAMESPACE,
+	Name:      "api_request_duration_seconds",
+	Help:      "Latency of the API requests, by method, route and status.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"method", "route", "status"})
+
+// This function returns the result label of an operation
+func metricResult(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// This function runs chromedp actions opening a page, and records the navigation duration and result in the metrics and in a span
+func runNavigation(ctx context.Context, page string, actions ...chromedp.Action) error {
+	ctx, span := startSpan(ctx, "chromedp.navigate", attribute.String("page", page))
+	start := time.Now()
+	resp, err := chromedp.RunResponse(ctx, actions...)
+	if err == nil && resp != nil && resp.Status >= 400 {
+		err = &httpStatusError{url: resp.URL, status: int(resp.Status)}
+	}
+	endSpan(span, err)
+	result := metricResult(err)
+	navigationDuration.WithLabelValues(page, result).Observe(time.Since(start).Seconds())
+	pagesFetchedTotal.WithLabelValues(page, result).Inc()
+	return err
+}
+
+// This middleware records the latency of the API requests, the route is the gin pattern so the ids do not make new series
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		apiRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// This function returns the handler of the /metrics endpoint
+func metricsAPI() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// This function exposes the metrics of a crawl while it runs, on the address given by metrics.addr in the configuration
+func serveCrawlMetrics(logger *slog.Logger) {
+	addr := appConfig.Metrics.Addr
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Error("The metrics server stopped", LOG_ERROR, err)
+		}
+	}()
+}
+
+// This function pushes the metrics of a crawl to the pushgateway given by metrics.pushgateway_url in the configuration, so they outlive the crawl.
+// Each crawl replaces the metrics pushed by the previous one.
+func pushCrawlMetrics(logger *slog.Logger) {
+	gateway := appConfig.Metrics.PushgatewayURL
+	if gateway == "" {
+		return
+	}
+
+	err := push.New(gateway, "french_top_jobs_crawl").
+		Gatherer(prometheus.DefaultGatherer).
+		Push()
+	if err != nil {
+		logger.Error("Unable to push the crawl metrics", LOG_ERROR, err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// This variable stores the information found on an offer page
+type OfferDetails struct {
+	Title        string
+	Description  string
+	ContractType string
+	Location     string
+	Remote       bool
+	// The url of the page once the redirections have been followed
+	FinalURL string
+}
+
+// The schema.org employment types mapped to the contract types used on the french market. FULL_TIME and PART_TIME tell the working time
+// and not the contract, a full time offer can be a cdd or an apprenticeship, so they are not mapped.
+var employmentTypesContracts = map[string]string{
+	"CONTRACTOR": "freelance",
+	"TEMPORARY":  "cdd",
+	"INTERN":     "internship",
+}
+
+// The contract types that can be found in an offer title or description, the first match wins
+var contractTypesRegexps = []struct {
+	contractType string
+	regex        *regexp.Regexp
+}{
+	{"apprenticeship", regexp.MustCompile(`(?i)\b(alternance|alternant|apprenti|apprentissage)\b`)},
+	{"internship", regexp.MustCompile(`(?i)\b(stage|stagiaire|internship|intern)\b`)},
+	{"freelance", regexp.MustCompile(`(?i)\b(freelance|free-lance|indépendant)\b`)},
+	{"cdd", regexp.MustCompile(`(?i)\bcdd\b`)},
+	{"cdi", regexp.MustCompile(`(?i)\bcdi\b`)},
+}
+
+// The words meaning that an offer can be done remotely
+var remoteRegexp = regexp.MustCompile(`(?i)(t[ée]l[ée]travail|full[ -]remote|remote)`)
+
+// This function downloads an offer page and extracts its details from the JobPosting structured data, falling back on the page metadata
+func fetchOfferDetails(ctx context.Context, offerURL string) (details OfferDetails, err error) {
+	ctx, span := startSpan(ctx, "offer.fetch_details", SPAN_URL.String(offerURL))
+	defer func() { endSpan(span, err) }()
+
+	release, err := visitPage(ctx, offerURL)
+	if err != nil {
+		return details, err
+	}
+	defer release()
+
+	// The page is read within the attempt, its context being canceled once it returns
+	var doc *goquery.Document
+	var finalURL string
+	err = retryFetch(ctx, PAGE_OFFER, offerURL, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, offerURL, nil)
+		if err != nil {
+			return fmt.Errorf("unable to create the request for %s: %w", offerURL, err)
+		}
+		setPageHeaders(req)
+
+		resp, err := crawlerClient(appConfig.Crawl.OfferFetchTimeout).Do(req)
+		if err != nil {
+			pagesFetchedTotal.WithLabelValues(PAGE_OFFER, metricResult(err)).Inc()
+			return fmt.Errorf("unable to get %s: %w", offerURL, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err = &httpStatusError{url: offerURL, status: resp.StatusCode}
+			pagesFetchedTotal.WithLabelValues(PAGE_OFFER, metricResult(err)).Inc()
+			return err
+		}
+		pagesFetchedTotal.WithLabelValues(PAGE_OFFER, metricResult(nil)).Inc()
+
+		doc, err = goquery.NewDocumentFromReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %w", offerURL, err)
+		}
+		finalURL = resp.Request.URL.String()
+		return nil
+	})
+	if err != nil {
+		return details, err
+	}
+
+	details = parseOfferDetails(doc)
+	details.FinalURL = finalURL
+
+	return details, nil
+}
+
+// This function extracts the offer details from an html document
+func parseOfferDetails(doc *goquery.Document) OfferDetails {
+	var details OfferDetails
+
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		var data any
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return true
+		}
+		posting := findJobPosting(data)
+		if posting == nil {
+			return true
+		}
+		details = jobPostingDetails(posting)
+		return false
+	})
+
+	if details.Title == "" {
+		details.Title = strings.TrimSpace(doc.Find("h1").First().Text())
+	}
+	if details.Title == "" {
+		details.Title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	if details.Description == "" {
+		details.Description, _ = doc.Find(`meta[name="description"]`).Attr("content")
+		details.Description = strings.TrimSpace(details.Description)
+	}
+
+	text := details.Title + " " + details.Description
+	if details.ContractType == "" {
+		details.ContractType = findContractType(text)
+	}
+	if !details.Remote {
+		details.Remote = remoteRegexp.MatchString(text)
+	}
+
+	return details
+}
+
+// This function looks for a schema.org JobPosting object in a decoded json-ld document, which can be a list or use a @graph
+func findJobPosting(data any) map[string]any {
+	switch value := data.(type) {
+	case []any:
+		for _, item := range value {
+			if posting := findJobPosting(item); posting != nil {
+				return posting
+			}
+		}
+	case map[string]any:
+		if isJSONLDType(value["@type"], "JobPosting") {
+			return value
+		}
+		if graph, ok := value["@graph"]; ok {
+			return findJobPosting(graph)
+		}
+	}
+	return nil
+}
+
+func isJSONLDType(value any, expected string) bool {
+	switch t := value.(type) {
+	case string:
+		return t == expected
+	case []any:
+		for _, item := range t {
+			if s, ok := item.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// This function converts a JobPosting object to offer details
+func jobPostingDetails(posting map[string]any) OfferDetails {
+	var details OfferDetails
+
+	details.Title, _ = posting["title"].(string)
+	details.Title = strings.TrimSpace(details.Title)
+
+	// The description is html, only its text is kept
+	description, _ := posting["description"].(string)
+	descriptionDoc, err := goquery.NewDocumentFromReader(strings.NewReader(description))
+	if err == nil {
+		description = descriptionDoc.Text()
+	}
+	details.Description = strings.Join(strings.Fields(description), " ")
+
+	// A contract type written in the offer title, then in its description, is more precise than the schema.org one
+	details.ContractType = findContractType(details.Title)
+	if details.ContractType == "" {
+		details.ContractType = findContractType(details.Description)
+	}
+	for _, employmentType := range jsonLDStrings(posting["employmentType"]) {
+		if details.ContractType != "" {
+			break
+		}
+		details.ContractType = employmentTypesContracts[strings.ToUpper(employmentType)]
+	}
+
+	details.Location = jobPostingLocation(posting["jobLocation"])
+
+	locationType, _ := posting["jobLocationType"].(string)
+	details.Remote = strings.EqualFold(locationType, "TELECOMMUTE")
+
+	return details
+}
+
+// This function returns the values of a json-ld property that can be a string or a list of strings
+func jsonLDStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// This function returns the city of the first location of a JobPosting
+func jobPostingLocation(value any) string {
+	switch v := value.(type) {
+	case []any:
+		for _, item := range v {
+			if location := jobPostingLocation(item); location != "" {
+				return location
+			}
+		}
+	case map[string]any:
+		address, ok := v["address"].(map[string]any)
+		if !ok {
+			return ""
+		}
+		locality, _ := address["addressLocality"].(string)
+		return strings.TrimSpace(locality)
+	}
+	return ""
+}
+
+// This function returns the contract type written in a text, or an empty string if there is none
+func findContractType(text string) string {
+	for _, contract := range contractTypesRegexps {
+		if contract.regex.MatchString(text) {
+			return contract.contractType
+		}
+	}
+	return ""
+}
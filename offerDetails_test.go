@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestJobPostingContractType(t *testing.T) {
+	tests := []struct {
+		name           string
+		title          string
+		description    string
+		employmentType any
+		want           string
+	}{
+		{"full time cdi", "Développeur Go (CDI)", "", "FULL_TIME", "cdi"},
+		{"full time cdd", "Ingénieur DevOps - CDD 12 mois", "", "FULL_TIME", "cdd"},
+		{"full time apprenticeship", "Alternance - Ingénieur cloud", "", "FULL_TIME", "apprenticeship"},
+		{"contract in the description", "Ingénieur SRE", "<p>Poste en CDD de 6 mois</p>", "FULL_TIME", "cdd"},
+		{"full time only", "Ingénieur SRE", "Rejoignez l'équipe plateforme", "FULL_TIME", ""},
+		{"part time only", "Ingénieur SRE", "", []any{"PART_TIME"}, ""},
+		{"schema.org contractor", "Ingénieur SRE", "", "CONTRACTOR", "freelance"},
+		{"schema.org list", "Ingénieur SRE", "", []any{"FULL_TIME", "INTERN"}, "internship"},
+		{"schema.org lower case", "Ingénieur SRE", "", "temporary", "cdd"},
+		{"title over schema.org", "Stage - Ingénieur DevOps", "", "TEMPORARY", "internship"},
+		{"no employment type", "Ingénieur SRE", "", nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			posting := map[string]any{"title": test.title, "description": test.description}
+			if test.employmentType != nil {
+				posting["employmentType"] = test.employmentType
+			}
+			if got := jobPostingDetails(posting).ContractType; got != test.want {
+				t.Errorf("the contract type of %q is %q, want %q", test.title, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// This variable stores a url where an offer has been found, an offer published on the company ATS and on WTTJ has two sources
+type OfferSource struct {
+	ID           int
+	OfferID      int
+	URL          string
+	CanonicalURL string
+	FirstSeen    time.Time
+	LastSeen     time.Time
+}
+
+// This function records that an offer source url has been seen again, and returns its offer, or false if the url is not known
+func touchOfferSource(ctx context.Context, db *pgxpool.Pool, canonicalURL string) (int, bool, error) {
+	var offerID int
+
+	query := `UPDATE offer_sources SET last_seen = now() WHERE canonical_url = @canonical_url RETURNING offer_id`
+	args := pgx.NamedArgs{
+		"canonical_url": canonicalURL,
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&offerID)
+	switch {
+	case err == pgx.ErrNoRows:
+		return offerID, false, nil
+	case err != nil:
+		return offerID, false, fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return offerID, true, nil
+}
+
+// This function attaches a source url to an offer, a url already attached to an offer is left to it
+func addOfferSource(ctx context.Context, db *pgxpool.Pool, offerID int, sourceURL string, canonicalURL string) error {
+	query := `INSERT INTO offer_sources (offer_id, url, canonical_url) VALUES (@offer_id, @url, @canonical_url)
+		ON CONFLICT (canonical_url) DO UPDATE SET last_seen = now()`
+	args := pgx.NamedArgs{
+		"offer_id":      offerID,
+		"url":           sourceURL,
+		"canonical_url": canonicalURL,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return err
+}
+
+// This function returns the oldest offer sharing a dedup key, or false if there is none
+func findOfferByDedupKey(ctx context.Context, db *pgxpool.Pool, dedupKey string) (int, bool, error) {
+	var offerID int
+
+	if dedupKey == "" {
+		return offerID, false, nil
+	}
+
+	query := `SELECT id FROM offers WHERE dedup_key = @dedup_key ORDER BY first_seen, id LIMIT 1`
+	args := pgx.NamedArgs{
+		"dedup_key": dedupKey,
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&offerID)
+	switch {
+	case err == pgx.ErrNoRows:
+		return offerID, false, nil
+	case err != nil:
+		return offerID, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	return offerID, true, nil
+}
+
+func getOfferSources(ctx context.Context, db *pgxpool.Pool, offerID int) ([]OfferSource, error) {
+	var sources []OfferSource
+
+	query := `SELECT id, offer_id, url, canonical_url, first_seen, last_seen FROM offer_sources WHERE offer_id = @offer_id ORDER BY first_seen, id`
+	args := pgx.NamedArgs{
+		"offer_id": offerID,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return sources, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source OfferSource
+		err = rows.Scan(&source.ID, &source.OfferID, &source.URL, &source.CanonicalURL, &source.FirstSeen, &source.LastSeen)
+		if err != nil {
+			return sources, fmt.Errorf("unable to scan row: %w", err)
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, rows.Err()
+}
+
+// This function merges a duplicate offer into the offer that is kept : its sources are moved to the kept offer,
+// the applications to it follow them unless the user already tracks the kept offer, and the duplicate is deleted
+func mergeOffers(ctx context.Context, db *pgxpool.Pool, keptID int, duplicateID int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	args := pgx.NamedArgs{
+		"kept_id":      keptID,
+		"duplicate_id": duplicateID,
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE offer_sources SET offer_id = @kept_id WHERE offer_id = @duplicate_id`, args)
+	if err != nil {
+		return fmt.Errorf("unable to update rows: %w", err)
+	}
+
+	// The applications left on the duplicate keep their copy of the offer when it is deleted
+	_, err = tx.Exec(ctx, `UPDATE applications a SET offer_id = @kept_id WHERE a.offer_id = @duplicate_id
+		AND NOT EXISTS (SELECT 1 FROM applications k WHERE k.user_id = a.user_id AND k.offer_id = @kept_id)`, args)
+	if err != nil {
+		return fmt.Errorf("unable to update rows: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE offers SET first_seen = least(first_seen, (SELECT first_seen FROM offers WHERE id = @duplicate_id)) WHERE id = @kept_id`, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM offers WHERE id = @duplicate_id`, args)
+	if err != nil {
+		return fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return err
+}
+
+// This function computes the dedup key of the offers added before it existed
+func fillMissingOfferDedupKeys(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT id, coalesce(company_name, ''), title, location FROM offers WHERE dedup_key IS NULL`)
+	if err != nil {
+		return fmt.Errorf("unable to query rows: %w", err)
+	}
+	keys := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var companyName, title, location string
+		err = rows.Scan(&id, &companyName, &title, &location)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("unable to scan row: %w", err)
+		}
+		keys[id] = offerDedupKey(companyName, title, location)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	for id, key := range keys {
+		_, err = db.Exec(ctx, `UPDATE offers SET dedup_key = @dedup_key WHERE id = @id`, pgx.NamedArgs{"id": id, "dedup_key": key})
+		if err != nil {
+			return fmt.Errorf("unable to update row: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// This function attaches their own url to the offers added before the sources existed.
+// When this url is already the source of another offer, both are the same offer and the newest is merged into the oldest.
+func fillMissingOfferSources(ctx context.Context, db *pgxpool.Pool) error {
+	rows, err := db.Query(ctx, `SELECT id, offer_url FROM offers o WHERE NOT EXISTS (SELECT 1 FROM offer_sources s WHERE s.offer_id = o.id) ORDER BY first_seen, id`)
+	if err != nil {
+		return fmt.Errorf("unable to query rows: %w", err)
+	}
+	type offerURL struct {
+		id  int
+		url string
+	}
+	var offers []offerURL
+	for rows.Next() {
+		var offer offerURL
+		err = rows.Scan(&offer.id, &offer.url)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("unable to scan row: %w", err)
+		}
+		offers = append(offers, offer)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	for _, offer := range offers {
+		canonicalURL, err := canonicalizeOfferURL(offer.url)
+		if err != nil {
+			slog.Warn("An error happened while canonicalizing the offer url", LOG_URL, offer.url, LOG_ERROR, err)
+			continue
+		}
+
+		existingID, found, err := touchOfferSource(ctx, db, canonicalURL)
+		if err != nil {
+			return err
+		}
+		if found && existingID != offer.id {
+			err = mergeOffers(ctx, db, existingID, offer.id)
+		} else {
+			err = addOfferSource(ctx, db, offer.id, offer.url, canonicalURL)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// This function merges the offers sharing a dedup key into the oldest of them, so each posting is one offer with all its sources
+func dedupOffers(ctx context.Context, db *pgxpool.Pool) error {
+	err := fillMissingOfferDedupKeys(ctx, db)
+	if err != nil {
+		return err
+	}
+	err = fillMissingOfferSources(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(ctx, `SELECT array_agg(id ORDER BY first_seen, id) FROM offers WHERE dedup_key <> '' GROUP BY dedup_key HAVING count(*) > 1`)
+	if err != nil {
+		return fmt.Errorf("unable to query rows: %w", err)
+	}
+	clusters, err := pgx.CollectRows(rows, pgx.RowTo[[]int32])
+	if err != nil {
+		return fmt.Errorf("unable to collect rows: %w", err)
+	}
+
+	merged := 0
+	for _, cluster := range clusters {
+		for _, duplicateID := range cluster[1:] {
+			err = mergeOffers(ctx, db, int(cluster[0]), int(duplicateID))
+			if err != nil {
+				return err
+			}
+			merged++
+		}
+	}
+	if merged > 0 {
+		loggerFromContext(ctx).Info("The duplicate offers have been merged", "merged", merged)
+	}
+
+	return nil
+}
+
+// This function returns the urls where an offer has been found
+func getOfferSourcesAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	sources, err := getOfferSources(c.Request.Context(), dbpoolapi, id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if len(sources) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sources})
+}
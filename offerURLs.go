@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The query parameters only used to track where a visitor comes from, they never change the offer displayed. Generic names
+// like ref, source or from are kept, some job boards identifying the offer with them.
+var trackingParams = map[string]bool{
+	"gclid":        true,
+	"fbclid":       true,
+	"msclkid":      true,
+	"igshid":       true,
+	"mc_cid":       true,
+	"mc_eid":       true,
+	"_hsenc":       true,
+	"_hsmi":        true,
+	"trk":          true,
+	"trackingid":   true,
+	"gh_src":       true,
+	"lever-source": true,
+}
+
+// The language prefix of a path, like /fr/, /en-gb/ or /fr_FR/
+var languagePrefixRegexp = regexp.MustCompile(`(?i)^/(fr|en|de|es|it|nl|pt)([-_][a-z]{2})?(/|$)`)
+
+// The gender mentions added to the french offers titles, like (H/F), F/H, (H/F/X) or (m/f/d)
+var genderMentionRegexp = regexp.MustCompile(`(?i)\(?\b[hfm]\s*/\s*[hfmwdx](\s*/\s*[hfmwdx])?\b\)?|\((e|ne|se|euse|rice)\)`)
+
+// The words of a location that do not help telling two locations apart
+var locationNoiseRegexp = regexp.MustCompile(`(?i)\b(\d{5}|\d{2}|cedex|france|fr)\b`)
+
+// This function returns the form of an offer url used to recognise the same offer reached through different links.
+// The scheme is always https, the host is lower cased without www, the language prefix, trailing slash, fragment
+// and tracking parameters are removed, and the remaining parameters are sorted.
+func canonicalizeOfferURL(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse %s: %w", rawURL, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("unable to canonicalize %s: the url is not absolute", rawURL)
+	}
+
+	u.Scheme = "https"
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	cleanPath := path.Clean("/" + u.Path)
+	cleanPath = languagePrefixRegexp.ReplaceAllString(cleanPath, "/")
+	cleanPath = strings.TrimSuffix(cleanPath, "/")
+	u.Path = cleanPath
+	u.RawPath = ""
+
+	query := u.Query()
+	for key := range query {
+		lowerKey := strings.ToLower(key)
+		if trackingParams[lowerKey] || strings.HasPrefix(lowerKey, "utm_") {
+			query.Del(key)
+		}
+	}
+	// Encode sorts the parameters by key
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// This function lower cases a text, removes its accents and replaces its punctuation by single spaces
+func normalizeText(text string) string {
+	return strings.ReplaceAll(companySlug(text), "-", " ")
+}
+
+// This function returns the key shared by the offers that are the same posting published on several sources :
+// the company, the title without its gender mention and the location without postal code or country.
+// It is empty when the offer has no title, such offers are never merged.
+func offerDedupKey(companyName string, title string, location string) string {
+	normalizedTitle := normalizeText(genderMentionRegexp.ReplaceAllString(title, " "))
+	if normalizedTitle == "" {
+		return ""
+	}
+	normalizedLocation := normalizeText(locationNoiseRegexp.ReplaceAllString(normalizeText(location), " "))
+
+	return normalizeText(companyName) + "|" + normalizedTitle + "|" + normalizedLocation
+}
@@ -4,41 +4,64 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func createJobOffer(db *pgxpool.Pool, offer Offer) error {
-	query := `INSERT INTO offers (company_name, offer_url) VALUES (@company_name, @offer_url)`
+// The columns read when scanning an offer with scanOffer
+const OFFER_COLUMNS = `id, company_name, offer_url, title, description, category, contract_type, location, remote, first_seen`
+
+// This function adds an offer and returns its id, the id of the existing offer is returned when its url is already present
+func createJobOffer(ctx context.Context, db *pgxpool.Pool, offer Offer) (int, error) {
+	var id int
+
+	query := `INSERT INTO offers (company_name, offer_url, title, description, category, contract_type, location, remote, dedup_key)
+		VALUES (@company_name, @offer_url, @title, @description, @category, @contract_type, @location, @remote, @dedup_key)
+		RETURNING id`
 	args := pgx.NamedArgs{
-		"company_name": offer.companyName,
-		"offer_url":    offer.offerUrl,
+		"company_name":  offer.companyName,
+		"offer_url":     offer.offerUrl,
+		"title":         offer.title,
+		"description":   offer.description,
+		"category":      offer.category,
+		"contract_type": offer.contractType,
+		"location":      offer.location,
+		"remote":        offer.remote,
+		"dedup_key":     offerDedupKey(offer.companyName, offer.title, offer.location),
 	}
-	_, err := db.Exec(context.TODO(), query, args)
+	err := db.QueryRow(ctx, query, args).Scan(&id)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
 			switch {
 			case pgErr.Code == "23505":
-				log.Println("The entry already exist, not adding it")
-				return nil
+				offersTotal.WithLabelValues(OFFER_DUPLICATE_URL).Inc()
+				slog.Debug("The offer already exists, not adding it", LOG_URL, offer.offerUrl)
+				err = db.QueryRow(ctx, `SELECT id FROM offers WHERE offer_url = @offer_url`, args).Scan(&id)
+				if err != nil {
+					return id, fmt.Errorf("unable to query row: %w", err)
+				}
+				return id, nil
 			default:
-				return fmt.Errorf("unable to insert row: %w", err)
+				return id, fmt.Errorf("unable to insert row: %w", err)
 			}
 		}
+		return id, err
 	}
-	return err
+
+	offersTotal.WithLabelValues(OFFER_INSERTED).Inc()
+	return id, nil
 }
 
-func deleteJobOffer(db *pgxpool.Pool, offer_url string) error {
+func deleteJobOffer(ctx context.Context, db *pgxpool.Pool, offer_url string) error {
 	query := `DELETE FROM offers WHERE offer_url = @offerIdToDelete`
 	args := pgx.NamedArgs{
 		"offerIdToDelete": offer_url,
 	}
-	_, err := db.Exec(context.Background(), query, args)
+	_, err := db.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to delete row: %w", err)
 	}
@@ -46,26 +69,25 @@ func deleteJobOffer(db *pgxpool.Pool, offer_url string) error {
 	return err
 }
 
-func getCompanyOffers(db *pgxpool.Pool, companyName string) []Offer {
+func getCompanyOffers(ctx context.Context, db *pgxpool.Pool, companyName string) []Offer {
 	var offers []Offer
 
-	query := "select * from offers where name = @companyName"
+	query := "select " + OFFER_COLUMNS + " from offers where company_name = @companyName"
 	args := pgx.NamedArgs{
 		"companyName": companyName,
 	}
-	rows, err := db.Query(context.TODO(), query, args)
+	rows, err := db.Query(ctx, query, args)
 
 	switch {
 	case err == pgx.ErrNoRows:
 		return offers
 	case err != nil:
-		log.Printf("Database query failed because of %s :", err)
+		slog.Error("Database query failed", LOG_ERROR, err)
 	default:
 		for rows.Next() {
-			var offer Offer
-			err = rows.Scan(&offer.companyName, &offer.offerUrl)
+			offer, err := scanOffer(rows)
 			if err != nil {
-				log.Printf("Database query scan failed because of %s :", err)
+				slog.Error("Database query scan failed", LOG_ERROR, err)
 			}
 			offers = append(offers, offer)
 		}
@@ -74,23 +96,22 @@ func getCompanyOffers(db *pgxpool.Pool, companyName string) []Offer {
 	return offers
 }
 
-func getAllOffers(db *pgxpool.Pool) []Offer {
+func getAllOffers(ctx context.Context, db *pgxpool.Pool) []Offer {
 	var offers []Offer
 
-	query := "select * from offers"
-	rows, err := db.Query(context.TODO(), query)
+	query := "select " + OFFER_COLUMNS + " from offers"
+	rows, err := db.Query(ctx, query)
 
 	switch {
 	case err == pgx.ErrNoRows:
 		return offers
 	case err != nil:
-		log.Printf("Database query failed because of %s :", err)
+		slog.Error("Database query failed", LOG_ERROR, err)
 	default:
 		for rows.Next() {
-			var offer Offer
-			err = rows.Scan(&offer.companyName, &offer.offerUrl)
+			offer, err := scanOffer(rows)
 			if err != nil {
-				log.Printf("Database query scan failed because of %s :", err)
+				slog.Error("Database query scan failed", LOG_ERROR, err)
 			}
 			offers = append(offers, offer)
 		}
@@ -98,3 +119,10 @@ func getAllOffers(db *pgxpool.Pool) []Offer {
 
 	return offers
 }
+
+// This function scans a row selected with the OFFER_COLUMNS columns
+func scanOffer(row pgx.Row) (Offer, error) {
+	var offer Offer
+	err := row.Scan(&offer.id, &offer.companyName, &offer.offerUrl, &offer.title, &offer.description, &offer.category, &offer.contractType, &offer.location, &offer.remote, &offer.firstSeen)
+	return offer, err
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Default and maximum number of results returned by one search page
+const SEARCH_DEFAULT_LIMIT = 20
+const SEARCH_MAX_LIMIT = 100
+
+// This variable stores the filters of an offers search
+type OfferSearchParams struct {
+	Query        string
+	Category     string
+	CompanyName  string
+	ContractType string
+	Location     string
+	// Only return the offers that can be done remotely
+	Remote bool
+	// Do not return the offers of the companies flagged as contractors
+	ExcludeContractors bool
+	// Only return the offers first seen after this date, the zero value disables the filter
+	Since time.Time
+	// The results are ordered by relevance, or from the newest to the oldest when it is set to "date"
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// This variable stores an offer matching a search, with the matching words highlighted in its title and description snippet
+type OfferSearchResult struct {
+	ID             int
+	CompanyName    string
+	CompanySlug    string
+	OfferURL       string
+	Title          string
+	Category       string
+	ContractType   string
+	Location       string
+	Remote         bool
+	FirstSeen      time.Time
+	Rank           float32
+	TitleHighlight string
+	Snippet        string
+}
+
+// This variable stores the number of offers matching a search for each value of a field
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// This variable stores a page of search results and the facet counts of the whole search
+type OfferSearchResponse struct {
+	Total   int
+	Results []OfferSearchResult
+	Facets  map[string][]FacetCount
+}
+
+// The filters shared by the results and facets queries, an empty parameter disables its filter
+const OFFER_SEARCH_FILTERS = `(@query = '' OR o.search @@ websearch_to_tsquery('french_unaccent', @query))
+	AND (@category = '' OR o.category = @category)
+	AND (@company_name = '' OR o.company_name = @company_name)
+	AND (@contract_type = '' OR o.contract_type = @contract_type)
+	AND (@location = '' OR unaccent(o.location) ILIKE '%' || unaccent(@location) || '%')
+	AND (NOT @remote OR o.remote)
+	AND (NOT @exclude_contractors OR NOT EXISTS (SELECT 1 FROM companies c WHERE c.name = o.company_name AND c.is_contractor))
+	AND (@since::timestamptz IS NULL OR o.first_seen > @since::timestamptz)`
+
+// The fields for which facet counts are returned, with the offers column holding them
+var offerSearchFacets = []struct {
+	name   string
+	column string
+}{
+	{"category", "category"},
+	{"company", "company_name"},
+	{"contract_type", "contract_type"},
+	{"location", "location"},
+}
+
+// This variable escapes the wildcards of a LIKE pattern, so the user input is matched as it is
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// This function returns a text to look for in a LIKE pattern, its wildcards and escape character being escaped
+func escapeLikePattern(text string) string {
+	return likePatternEscaper.Replace(text)
+}
+
+// This function runs a full text search over the offers titles, descriptions and companies names
+func searchOffers(ctx context.Context, db *pgxpool.Pool, params OfferSearchParams) (OfferSearchResponse, error) {
+	response := OfferSearchResponse{Facets: make(map[string][]FacetCount)}
+
+	var since *time.Time
+	if !params.Since.IsZero() {
+		since = &params.Since
+	}
+
+	args := pgx.NamedArgs{
+		"query":               params.Query,
+		"category":            params.Category,
+		"company_name":        params.CompanyName,
+		"contract_type":       params.ContractType,
+		"location":            escapeLikePattern(params.Location),
+		"remote":              params.Remote,
+		"exclude_contractors": params.ExcludeContractors,
+		"since":               since,
+		"sort":                params.Sort,
+		"limit":               params.Limit,
+		"offset":              params.Offset,
+	}
+
+	query := `SELECT o.id, o.company_name, coalesce((SELECT c.slug FROM companies c WHERE c.name = o.company_name), ''), o.offer_url, o.title, o.category, o.contract_type, o.location, o.remote, o.first_seen,
+		ts_rank(o.search, q) AS rank,
+		CASE WHEN @query = '' THEN o.title ELSE ts_headline('french_unaccent', o.title, q, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') END,
+		CASE WHEN @query = '' THEN left(o.description, 200) ELSE ts_headline('french_unaccent', o.description, q, 'MaxFragments=2, MaxWords=30, MinWords=10, StartSel=<mark>, StopSel=</mark>') END,
+		count(*) OVER ()
+		FROM offers o, websearch_to_tsquery('french_unaccent', @query) q
+		WHERE ` + OFFER_SEARCH_FILTERS + `
+		ORDER BY CASE WHEN @sort = 'date' THEN 0 ELSE ts_rank(o.search, q) END DESC, o.first_seen DESC, o.id DESC
+		LIMIT @limit OFFSET @offset`
+
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return response, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result OfferSearchResult
+		err = rows.Scan(&result.ID, &result.CompanyName, &result.CompanySlug, &result.OfferURL, &result.Title, &result.Category, &result.ContractType, &result.Location, &result.Remote, &result.FirstSeen,
+			&result.Rank, &result.TitleHighlight, &result.Snippet, &response.Total)
+		if err != nil {
+			return response, fmt.Errorf("unable to scan row: %w", err)
+		}
+		response.Results = append(response.Results, result)
+	}
+	if rows.Err() != nil {
+		return response, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	for _, facet := range offerSearchFacets {
+		counts, err := countOfferSearchFacet(ctx, db, facet.column, args)
+		if err != nil {
+			return response, err
+		}
+		response.Facets[facet.name] = counts
+	}
+
+	return response, nil
+}
+
+// This function counts the offers matching a search for each value of a column
+func countOfferSearchFacet(ctx context.Context, db *pgxpool.Pool, column string, args pgx.NamedArgs) ([]FacetCount, error) {
+	var counts []FacetCount
+
+	query := `SELECT o.` + column + `, count(*) FROM offers o
+		WHERE ` + OFFER_SEARCH_FILTERS + ` AND o.` + column + ` <> ''
+		GROUP BY o.` + column + ` ORDER BY count(*) DESC, o.` + column
+
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return counts, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var count FacetCount
+		err = rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return counts, fmt.Errorf("unable to scan row: %w", err)
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// This function reads the search parameters of a request
+func parseOfferSearchParams(c *gin.Context) (OfferSearchParams, error) {
+	params := OfferSearchParams{
+		Query:        c.Query("q"),
+		Category:     c.Query("category"),
+		CompanyName:  c.Query("company"),
+		ContractType: c.Query("contract_type"),
+		Location:     c.Query("location"),
+		Remote:       c.Query("remote") == "true",
+		Sort:         c.Query("sort"),
+		Limit:        SEARCH_DEFAULT_LIMIT,
+
+		ExcludeContractors: c.Query("exclude_contractors") == "true",
+	}
+
+	if params.Sort != "" && params.Sort != "relevance" && params.Sort != "date" {
+		return params, fmt.Errorf("sort must be relevance or date")
+	}
+
+	var err error
+	if c.Query("limit") != "" {
+		params.Limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || params.Limit < 1 || params.Limit > SEARCH_MAX_LIMIT {
+			return params, fmt.Errorf("limit must be between 1 and %d", SEARCH_MAX_LIMIT)
+		}
+	}
+	if c.Query("offset") != "" {
+		params.Offset, err = strconv.Atoi(c.Query("offset"))
+		if err != nil || params.Offset < 0 {
+			return params, fmt.Errorf("offset must be a positive number")
+		}
+	}
+
+	return params, nil
+}
+
+func searchOffersAPI(c *gin.Context) {
+	params, err := parseOfferSearchParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := searchOffers(c.Request.Context(), dbpoolapi, params)
+	if err != nil {
+		slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/chromedp"
+)
+
+// The script returning the number of links of the page
+const LINKS_COUNT_SCRIPT = `document.links.length`
+
+// The script scrolling to the bottom of the page, which makes the infinite lists load their next offers
+const SCROLL_SCRIPT = `window.scrollTo(0, document.body.scrollHeight)`
+
+// The ways a page showed more offers, used as the action label of the pagination metric
+const PAGINATION_SCROLL = "scroll"
+const PAGINATION_LOAD_MORE = "load_more"
+const PAGINATION_NEXT_PAGE = "next_page"
+const PAGINATION_FRAME = "frame"
+
+// The texts of the links going to the next page of a list
+var nextPageRegexp = regexp.MustCompile(`(?i)^(suivant|suivante|page suivante|next|next page|›|»|>)$`)
+
+// This variable stores a page of a jobs list once expanded: its links, the url of its next page and the urls of the job lists it embeds
+type jobsPage struct {
+	links    []Link
+	nextPage string
+	frames   []string
+}
+
+// This function returns the script clicking the first visible "load more" button of the page, matching pagination.load_more_selector
+// or, without selector, whose text matches pagination.load_more_pattern. The links leaving the page are not clicked, they are pages of their own.
+func loadMoreScript() (string, error) {
+	selector, err := json.Marshal(appConfig.Pagination.LoadMoreSelector)
+	if err != nil {
+		return "", fmt.Errorf("unable to quote the load more selector: %w", err)
+	}
+	pattern, err := json.Marshal(appConfig.Pagination.LoadMorePattern)
+	if err != nil {
+		return "", fmt.Errorf("unable to quote the load more pattern: %w", err)
+	}
+
+	return fmt.Sprintf(`(() => {
+	const selector = %s;
+	const pattern = new RegExp(%s, "i");
+	const candidates = document.querySelectorAll(selector || "button, a, [role=button]");
+	for (const element of candidates) {
+		if (element.offsetParent === null || element.disabled) {
+			continue;
+		}
+		const text = (element.innerText || element.getAttribute("aria-label") || "").trim();
+		if (!selector && !pattern.test(text)) {
+			continue;
+		}
+		const href = element.getAttribute("href");
+		if (element.tagName === "A" && href && href !== "#" && !href.startsWith("javascript:")) {
+			continue;
+		}
+		element.scrollIntoView();
+		element.click();
+		return true;
+	}
+	return false;
+})()`, selector, pattern), nil
+}
+
+// This function makes a page show all its offers: it scrolls to its bottom and clicks its "load more" button until no new link appears,
+// up to pagination.max_scrolls times. Each round waits for the links to stop changing, as the stable_links readiness strategy.
+func expandPage(ctx context.Context, pageURL string) error {
+	clickScript, err := loadMoreScript()
+	if err != nil {
+		return err
+	}
+	rule := readinessRuleFor(pageURL)
+
+	for round := 0; round < appConfig.Pagination.MaxScrolls; round++ {
+		var before int
+		err = chromedp.Evaluate(LINKS_COUNT_SCRIPT, &before).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to count the links of the page: %w", err)
+		}
+
+		var clicked bool
+		err = chromedp.Run(ctx,
+			chromedp.Evaluate(SCROLL_SCRIPT, nil),
+			chromedp.Evaluate(clickScript, &clicked),
+		)
+		if err != nil {
+			return fmt.Errorf("unable to scroll the page: %w", err)
+		}
+
+		// The links not settled within the timeout are read as they are
+		waitCtx, cancel := context.WithTimeout(ctx, rule.Timeout)
+		_ = waitStable(waitCtx, readinessScripts[READINESS_STABLE_LINKS], rule.IdleTime)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var after int
+		err = chromedp.Evaluate(LINKS_COUNT_SCRIPT, &after).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to count the links of the page: %w", err)
+		}
+		if after <= before {
+			return nil
+		}
+		action := PAGINATION_SCROLL
+		if clicked {
+			action = PAGINATION_LOAD_MORE
+		}
+		paginationRoundsTotal.WithLabelValues(action).Inc()
+	}
+
+	return nil
+}
+
+// This function opens a page of a jobs list in the tab of the context, expands it and returns its links. The number of the page
+// is the one of its link to the next page when the list has numbered pages.
+func readJobsPage(ctx context.Context, pageURL string, number int) (jobsPage, error) {
+	var content jobsPage
+	logger := loggerFromContext(ctx).With(LOG_URL, pageURL)
+
+	release, err := visitPage(ctx, pageURL)
+	if err != nil {
+		return content, err
+	}
+	defer release()
+
+	err = retryFetch(ctx, PAGE_JOBS, pageURL, func(ctx context.Context) error {
+		return runNavigation(ctx, PAGE_JOBS,
+			// visit the target page
+			chromedp.Navigate(pageURL),
+			// wait for the page to load
+			waitReady(PAGE_JOBS, pageURL),
+		)
+	})
+	if err != nil {
+		captureErrorArtifacts(ctx, PAGE_JOBS, pageURL, err)
+		return content, err
+	}
+
+	// A page that cannot be expanded is read with the offers it shows
+	err = expandPage(ctx, pageURL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return content, ctx.Err()
+		}
+		logger.Warn("Unable to expand the page", LOG_ERROR, err)
+	}
+
+	c := chromedp.FromContext(ctx)
+	rootNode, err := dom.GetDocument().Do(cdp.WithExecutor(ctx, c.Target))
+	if err != nil {
+		return content, fmt.Errorf("unable to get the page document: %w", err)
+	}
+
+	html, err := dom.GetOuterHTML().WithNodeID(rootNode.NodeID).Do(cdp.WithExecutor(ctx, c.Target))
+	if err != nil {
+		return content, fmt.Errorf("unable to get the page HTML: %w", err)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return content, fmt.Errorf("unable to open the HTML as a goquery document: %w", err)
+	}
+
+	// The relative links are resolved against the url of the page once redirected, or its <base> tag
+	location := pageURL
+	err = chromedp.Run(ctx, chromedp.Location(&location))
+	if err != nil || location == "" {
+		location = pageURL
+	}
+	baseHref, _ := doc.Find("base[href]").First().Attr("href")
+	base, err := pageBaseURL(location, baseHref)
+	if err != nil {
+		return content, fmt.Errorf("unable to resolve the base url of the page: %w", err)
+	}
+	page, _ := url.Parse(location)
+
+	// Find all the href links in the HTML document
+	hrefs := doc.Find("a")
+
+	// Iterate over the links and keep the ones leading to a page of the company website or of an applicant tracking system
+	for i := 0; i < hrefs.Length(); i++ {
+		href := hrefs.Eq(i)
+		// Get the href attribute of the link
+		link, ok := href.Attr("href")
+		if !ok {
+			continue
+		}
+		link, ok = resolveLink(base, link)
+		if !ok || !isLinkOnSite(page, link) {
+			continue
+		}
+		text := strings.Join(strings.Fields(href.Text()), " ")
+		content.links = append(content.links, Link{URL: link, Text: text})
+	}
+
+	content.nextPage = findNextPage(doc, base, page, number)
+	content.frames = findJobsFrames(doc, base, page)
+
+	if len(content.links) == 0 && len(content.frames) == 0 {
+		captureArtifacts(ctx, PAGE_JOBS, pageURL, ARTIFACT_FAILURE, "no link to a page of the site was found")
+	} else {
+		captureArtifacts(ctx, PAGE_JOBS, pageURL, ARTIFACT_SUCCESS, "")
+	}
+
+	return content, nil
+}
+
+// This function returns the url of the next page of a list: its rel="next" link, else a link labelled as next, else the link numbered after the page.
+// It returns an empty string when the list has no next page on the site.
+func findNextPage(doc *goquery.Document, base *url.URL, page *url.URL, number int) string {
+	nextNumber := strconv.Itoa(number + 1)
+	isNextPage := []func(s *goquery.Selection) bool{
+		func(s *goquery.Selection) bool {
+			rel, _ := s.Attr("rel")
+			return slices.Contains(strings.Fields(strings.ToLower(rel)), "next")
+		},
+		func(s *goquery.Selection) bool {
+			label, _ := s.Attr("aria-label")
+			return nextPageRegexp.MatchString(strings.TrimSpace(s.Text())) || nextPageRegexp.MatchString(strings.TrimSpace(label))
+		},
+		func(s *goquery.Selection) bool {
+			return strings.TrimSpace(s.Text()) == nextNumber
+		},
+	}
+
+	for _, isNext := range isNextPage {
+		next := ""
+		doc.Find("a[href], link[href]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+			if !isNext(s) {
+				return true
+			}
+			href, _ := s.Attr("href")
+			link, ok := resolveLink(base, href)
+			if !ok || !isLinkOnSite(page, link) || link == page.String() {
+				return true
+			}
+			next = link
+			return false
+		})
+		if next != "" {
+			return next
+		}
+	}
+
+	return ""
+}
+
+// This function returns the urls of the iframes of a page that embed a job list, the ones on the site of the page or of an applicant tracking system
+func findJobsFrames(doc *goquery.Document, base *url.URL, page *url.URL) []string {
+	var frames []string
+
+	doc.Find("iframe[src]").Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		link, ok := resolveLink(base, src)
+		if !ok || !isLinkOnSite(page, link) {
+			return
+		}
+		frames = append(frames, link)
+	})
+
+	return frames
+}
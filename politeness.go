@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+// The time after which the robots.txt of a host is downloaded again, a worker running for days sees its changes
+const ROBOTS_CACHE_DURATION = 24 * time.Hour
+
+// The reasons a page is not fetched, used as the reason label of the skipped pages metric
+const PAGE_SKIPPED_ROBOTS = "robots"
+const PAGE_SKIPPED_BUDGET = "budget"
+
+// This variable stores the error of a page the robots.txt of its host does not allow to crawl
+var errDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// This variable stores the error of a page not fetched as its company already used the pages it is given by run
+var errPageBudgetExceeded = errors.New("the pages budget of the company is exceeded")
+
+// This variable stores the requests to a host: the ones running, the time the next one can start and its robots.txt
+type politeHost struct {
+	slots chan struct{}
+
+	mu        sync.Mutex
+	nextStart time.Time
+	interval  time.Duration
+
+	// The robots.txt is downloaded by the first request to the host, the others waiting for it
+	robotsMu        sync.Mutex
+	robots          *robotstxt.RobotsData
+	robotsFetchedAt time.Time
+}
+
+// This variable stores the hosts the crawler sent requests to, by host name
+var politeHosts = struct {
+	mu    sync.Mutex
+	hosts map[string]*politeHost
+}{hosts: make(map[string]*politeHost)}
+
+// This function returns the requests to the host of an url, every fetch to a host sharing them
+func politeHostFor(u *url.URL) *politeHost {
+	host := strings.ToLower(u.Host)
+
+	politeHosts.mu.Lock()
+	defer politeHosts.mu.Unlock()
+
+	h, ok := politeHosts.hosts[host]
+	if !ok {
+		h = &politeHost{
+			slots:    make(chan struct{}, appConfig.Politeness.HostConcurrency),
+			interval: appConfig.Politeness.HostInterval,
+		}
+		politeHosts.hosts[host] = h
+	}
+	return h
+}
+
+// This function waits until a request can be sent to the host: politeness.host_concurrency requests run at a time, started at least
+// politeness.host_interval apart, or the Crawl-delay of the robots.txt if it is longer. It returns the function to call once the request is done.
+func (h *politeHost) acquire(ctx context.Context) (func(), error) {
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-h.slots }
+
+	// Each request reserves the next start, so the ones waiting start one interval apart
+	h.mu.Lock()
+	now := time.Now()
+	start := h.nextStart
+	if start.Before(now) {
+		start = now
+	}
+	h.nextStart = start.Add(h.interval)
+	h.mu.Unlock()
+
+	wait := time.Until(start)
+	if wait <= 0 {
+		return release, nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// This function tells whether the robots.txt of the host allows the crawler to fetch an url, it is downloaded once and kept ROBOTS_CACHE_DURATION
+func (h *politeHost) allows(ctx context.Context, u *url.URL) (bool, error) {
+	h.robotsMu.Lock()
+	defer h.robotsMu.Unlock()
+
+	if h.robots == nil || time.Since(h.robotsFetchedAt) > ROBOTS_CACHE_DURATION {
+		robots, err := h.fetchRobots(ctx, u)
+		if err != nil {
+			return false, err
+		}
+		h.robots = robots
+		h.robotsFetchedAt = time.Now()
+
+		group := robots.FindGroup(appConfig.Politeness.RobotsUserAgent)
+		if group.CrawlDelay > 0 {
+			delay := min(group.CrawlDelay, appConfig.Politeness.MaxCrawlDelay)
+			h.mu.Lock()
+			h.interval = max(appConfig.Politeness.HostInterval, delay)
+			h.mu.Unlock()
+		}
+	}
+
+	return h.robots.TestAgent(u.RequestURI(), appConfig.Politeness.RobotsUserAgent), nil
+}
+
+// This function downloads the robots.txt of the host of an url. A missing robots.txt allows everything and a server error disallows everything,
+// a host that cannot be reached is allowed as the page request will fail anyway.
+func (h *politeHost) fetchRobots(ctx context.Context, u *url.URL) (*robotstxt.RobotsData, error) {
+	logger := loggerFromContext(ctx)
+	robotsURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+
+	release, err := h.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the request for %s: %w", robotsURL.String(), err)
+	}
+	req.Header.Set("User-Agent", appConfig.Politeness.RobotsUserAgent)
+
+	resp, err := crawlerClient(appConfig.Politeness.RobotsTimeout).Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger.Warn("Unable to get the robots.txt, every page of the host is allowed", LOG_URL, robotsURL.String(), LOG_ERROR, err)
+		return robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+	}
+	defer resp.Body.Close()
+
+	robots, err := robotstxt.FromResponse(resp)
+	if err != nil {
+		logger.Warn("Unable to parse the robots.txt, every page of the host is allowed", LOG_URL, robotsURL.String(), LOG_ERROR, err)
+		return robotstxt.FromStatusAndBytes(http.StatusNotFound, nil)
+	}
+	return robots, nil
+}
+
+// This function waits until a request can be sent to the host of an url, without checking its robots.txt nor taking from the pages budget.
+// It is used for the API calls, the pages use visitPage.
+func acquireHost(ctx context.Context, rawURL string) (func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", rawURL, err)
+	}
+	return politeHostFor(u).acquire(ctx)
+}
+
+// This function is called before fetching a page, by the browser or by http. It returns an error if the robots.txt of the host does not allow it
+// or if the company already used its pages budget, otherwise it waits until the host can be sent a request and returns the function to call once it is done.
+func visitPage(ctx context.Context, rawURL string) (func(), error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", rawURL, err)
+	}
+	host := politeHostFor(u)
+
+	if appConfig.Politeness.RespectRobots {
+		allowed, err := host.allows(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			pagesSkippedTotal.WithLabelValues(PAGE_SKIPPED_ROBOTS).Inc()
+			return nil, fmt.Errorf("unable to get %s: %w", rawURL, errDisallowedByRobots)
+		}
+	}
+
+	if !takePageFromBudget(ctx) {
+		pagesSkippedTotal.WithLabelValues(PAGE_SKIPPED_BUDGET).Inc()
+		return nil, fmt.Errorf("unable to get %s: %w", rawURL, errPageBudgetExceeded)
+	}
+
+	return host.acquire(ctx)
+}
+
+// This function tells whether an error returned by visitPage is a page skipped to be polite, rather than a failure
+func isPageSkipped(err error) bool {
+	return errors.Is(err, errDisallowedByRobots) || errors.Is(err, errPageBudgetExceeded)
+}
+
+// This variable stores the pages a company can still fetch in a run
+type pageBudget struct {
+	left atomic.Int32
+}
+
+// This variable stores the pages budgets of the companies of a run, by company name
+type pageBudgets struct {
+	mu      sync.Mutex
+	budgets map[string]*pageBudget
+}
+
+type pageBudgetsKey struct{}
+type pageBudgetKey struct{}
+
+// This function returns a context whose companies share their pages budget for the rest of the run, their stages being run one after the other
+func withPageBudgets(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pageBudgetsKey{}, &pageBudgets{budgets: make(map[string]*pageBudget)})
+}
+
+// This function returns a context whose pages are taken from the budget of a company, politeness.max_pages_per_company by run.
+// Without run, like for the tasks of a worker, the company is given a new budget.
+func withCompanyPageBudget(ctx context.Context, companyName string) context.Context {
+	if appConfig.Politeness.MaxPagesPerCompany == 0 {
+		return ctx
+	}
+
+	newBudget := func() *pageBudget {
+		budget := &pageBudget{}
+		budget.left.Store(int32(appConfig.Politeness.MaxPagesPerCompany))
+		return budget
+	}
+
+	budgets, ok := ctx.Value(pageBudgetsKey{}).(*pageBudgets)
+	if !ok {
+		return context.WithValue(ctx, pageBudgetKey{}, newBudget())
+	}
+
+	budgets.mu.Lock()
+	defer budgets.mu.Unlock()
+	budget, ok := budgets.budgets[companyName]
+	if !ok {
+		budget = newBudget()
+		budgets.budgets[companyName] = budget
+	}
+	return context.WithValue(ctx, pageBudgetKey{}, budget)
+}
+
+// This function takes a page from the budget of the company of a context, it returns false once there is none left
+func takePageFromBudget(ctx context.Context) bool {
+	budget, ok := ctx.Value(pageBudgetKey{}).(*pageBudget)
+	if !ok {
+		return true
+	}
+	return budget.left.Add(-1) >= 0
+}
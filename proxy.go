@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The schemes of the proxies supported by both chrome and the http client
+var proxySchemes = []string{"http", "https", "socks5"}
+
+// This function returns the proxies of a comma separated list, like browser.proxy
+func parseProxies(list string) ([]*url.URL, error) {
+	var proxies []*url.URL
+
+	for _, rawURL := range strings.Split(list, ",") {
+		rawURL = strings.TrimSpace(rawURL)
+		if rawURL == "" {
+			continue
+		}
+		proxy, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the proxy %s: %w", rawURL, err)
+		}
+		if !slices.Contains(proxySchemes, proxy.Scheme) || proxy.Host == "" {
+			return nil, fmt.Errorf("the proxy %s must be an http://, https:// or socks5:// url with a host", proxy.Redacted())
+		}
+		// chrome does not send credentials to the socks proxies
+		if proxy.Scheme == "socks5" && proxy.User != nil {
+			return nil, fmt.Errorf("the socks5 proxy %s cannot have credentials", proxy.Redacted())
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	return proxies, nil
+}
+
+// This variable stores the proxies of browser.proxy, the configuration being validated before they are used
+var crawlerProxies = sync.OnceValue(func() []*url.URL {
+	proxies, _ := parseProxies(appConfig.Browser.Proxy)
+	return proxies
+})
+
+// This variable stores the number of proxies given, the next one being the one after it
+var proxyRotation atomic.Uint64
+
+// This function returns the proxy of the next tab or request, the proxies being used in turn. It returns nil without proxy.
+func nextProxy() *url.URL {
+	proxies := crawlerProxies()
+	if len(proxies) == 0 {
+		return nil
+	}
+	return proxies[(proxyRotation.Add(1)-1)%uint64(len(proxies))]
+}
+
+// This function returns the proxy server of a proxy as chrome expects it, without its credentials
+func proxyServer(proxy *url.URL) string {
+	return proxy.Scheme + "://" + proxy.Host
+}
+
+// This variable stores the transport of the http requests of the crawler to the sites, through the proxies of browser.proxy or,
+// without them, the ones of the environment
+var crawlerTransport = sync.OnceValue(func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(crawlerProxies()) > 0 {
+		transport.Proxy = func(*http.Request) (*url.URL, error) {
+			return nextProxy(), nil
+		}
+	}
+	return transport
+})
+
+// This function returns an http client for the requests of the crawler to the sites, like the offer pages and the robots.txt
+func crawlerClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: crawlerTransport()}
+}
+
+// This function sets the headers of an http request of the crawler to a page, so the site answers as to the browser
+func setPageHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", appConfig.Browser.UserAgent)
+	if appConfig.Browser.AcceptLanguage != "" {
+		req.Header.Set("Accept-Language", appConfig.Browser.AcceptLanguage)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// The strategies telling when a page opened in the browser is ready to be read
+const READINESS_SLEEP = "sleep"
+const READINESS_NETWORK_IDLE = "network_idle"
+const READINESS_SELECTOR = "selector"
+const READINESS_STABLE_LINKS = "stable_links"
+
+// The time between two checks of the readiness of a page
+const READINESS_POLL_INTERVAL = 100 * time.Millisecond
+
+// This variable stores the scripts returning a number that stops changing once the page is ready, or -1 while the page is loading
+var readinessScripts = map[string]string{
+	READINESS_NETWORK_IDLE: `document.readyState === "complete" ? performance.getEntriesByType("resource").length : -1`,
+	READINESS_STABLE_LINKS: `document.readyState === "loading" ? -1 : document.links.length`,
+}
+
+// This function returns the readiness rule of an url: the one of its host, or of its domain, or the default one.
+// The timeout and the idle time the rule does not give are the default ones.
+func readinessRuleFor(rawURL string) ReadinessRule {
+	defaults := appConfig.Readiness
+	rule := ReadinessRule{Strategy: defaults.Strategy, Selector: defaults.Selector}
+
+	if u, err := url.Parse(rawURL); err == nil {
+		host := strings.ToLower(strings.TrimPrefix(u.Hostname(), "www."))
+		domainRule, ok := defaults.Domains[host]
+		if !ok {
+			domainRule, ok = defaults.Domains[registrableDomain(host)]
+		}
+		if ok {
+			rule = domainRule
+		}
+	}
+
+	if rule.Timeout == 0 {
+		rule.Timeout = defaults.Timeout
+	}
+	if rule.IdleTime == 0 {
+		rule.IdleTime = defaults.IdleTime
+	}
+	return rule
+}
+
+// This function returns the fixed wait of a page, used by the sleep strategy
+func pageWait(page string) time.Duration {
+	switch page {
+	case PAGE_CAREERS:
+		return appConfig.Crawl.CareersPageWait
+	case PAGE_WTTJ_SEARCH, PAGE_WTTJ_COMPANY:
+		return appConfig.Crawl.WTTJWait
+	}
+	return appConfig.Crawl.JobsPageWait
+}
+
+// This function returns the action waiting for a page to be ready, with the readiness rule of its url. A page that is not ready
+// within the timeout of the rule is read as it is, only the cancellation of the navigation is an error.
+func waitReady(page string, rawURL string) chromedp.Action {
+	rule := readinessRuleFor(rawURL)
+
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if rule.Strategy == READINESS_SLEEP {
+			return chromedp.Sleep(pageWait(page)).Do(ctx)
+		}
+
+		readyCtx, cancel := context.WithTimeout(ctx, rule.Timeout)
+		defer cancel()
+
+		var err error
+		if rule.Strategy == READINESS_SELECTOR {
+			err = waitSelector(readyCtx, rule.Selector)
+		} else {
+			err = waitStable(readyCtx, readinessScripts[rule.Strategy], rule.IdleTime)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			pageReadinessTimeoutsTotal.WithLabelValues(page, rule.Strategy).Inc()
+			loggerFromContext(ctx).Debug("The page was not ready in time, reading it as it is", LOG_URL, rawURL, "strategy", rule.Strategy, "timeout", rule.Timeout.String())
+		}
+		return nil
+	})
+}
+
+// This function waits until the number returned by a script stays the same for the idle time given
+func waitStable(ctx context.Context, script string, idleTime time.Duration) error {
+	last := -1
+	var stableSince time.Time
+
+	for {
+		var value int
+		err := chromedp.Evaluate(script, &value).Do(ctx)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// A failed evaluation, like during a redirection, counts as a page still loading
+		switch {
+		case err != nil || value < 0:
+			last = -1
+		case value != last:
+			last = value
+			stableSince = time.Now()
+		case time.Since(stableSince) >= idleTime:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(READINESS_POLL_INTERVAL):
+		}
+	}
+}
+
+// This function waits until an element of the page matches a css selector
+func waitSelector(ctx context.Context, selector string) error {
+	quoted, err := json.Marshal(selector)
+	if err != nil {
+		return fmt.Errorf("unable to quote the selector %s: %w", selector, err)
+	}
+	script := fmt.Sprintf(`document.querySelector(%s) !== null`, quoted)
+
+	for {
+		var found bool
+		err := chromedp.Evaluate(script, &found).Do(ctx)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if found {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(READINESS_POLL_INTERVAL):
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This variable stores the error of a fetch not attempted as the circuit breaker of its domain is open
+var errCircuitOpen = errors.New("the circuit breaker of the domain is open")
+
+// This variable stores the error of a page answered with an http error status
+type httpStatusError struct {
+	url    string
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unable to get %s: status %d", e.url, e.status)
+}
+
+// The errors of the chrome network stack meaning the host does not answer, they are retried and counted by the circuit breaker
+var chromeHostErrors = []string{
+	"net::ERR_CONNECTION_REFUSED",
+	"net::ERR_CONNECTION_RESET",
+	"net::ERR_CONNECTION_CLOSED",
+	"net::ERR_CONNECTION_TIMED_OUT",
+	"net::ERR_TIMED_OUT",
+	"net::ERR_ADDRESS_UNREACHABLE",
+	"net::ERR_EMPTY_RESPONSE",
+}
+
+// The errors of the chrome network stack that retrying does not fix
+var chromePermanentErrors = []string{
+	"net::ERR_CERT_",
+	"net::ERR_SSL_",
+	"net::ERR_INVALID_URL",
+	"net::ERR_UNKNOWN_URL_SCHEME",
+	"net::ERR_BLOCKED_BY_",
+	"net::ERR_TOO_MANY_REDIRECTS",
+}
+
+// This function tells whether a failed fetch is worth retrying, and whether it means its host is down, in which case it is counted by the circuit breaker.
+// A host that does not exist is down but not retried, a 404 is neither.
+func classifyFetchError(err error) (retryable bool, hostDown bool) {
+	var statusErr *httpStatusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return false, false
+	case errors.Is(err, context.Canceled), errors.Is(err, errCircuitOpen), isPageSkipped(err):
+		return false, false
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.status == http.StatusTooManyRequests, statusErr.status == http.StatusRequestTimeout:
+			return true, false
+		case statusErr.status >= 500:
+			return true, true
+		}
+		return false, false
+	case errors.As(err, &dnsErr):
+		return !dnsErr.IsNotFound, true
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return true, true
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return true, true
+	case strings.Contains(err.Error(), "net::ERR_NAME_NOT_RESOLVED"):
+		return false, true
+	}
+
+	message := err.Error()
+	for _, hostError := range chromeHostErrors {
+		if strings.Contains(message, hostError) {
+			return true, true
+		}
+	}
+	for _, permanentError := range chromePermanentErrors {
+		if strings.Contains(message, permanentError) {
+			return false, false
+		}
+	}
+
+	// The other errors, like a tab crashing, are retried
+	return true, false
+}
+
+// This function returns the time to wait before the retry following an attempt: crawl.retry_base_delay doubled at each attempt up to crawl.retry_max_delay,
+// half of it being random so the companies failing together do not retry together
+func retryDelay(attempt int) time.Duration {
+	delay := appConfig.Crawl.RetryBaseDelay
+	for i := 1; i < attempt && delay < appConfig.Crawl.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, appConfig.Crawl.RetryMaxDelay)
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// This function fetches an url, with the browser or over http, retrying the failures worth it up to crawl.navigation_retries attempts.
+// Each attempt is given crawl.navigation_timeout, and none is made while the circuit breaker of the url domain is open.
+func retryFetch(ctx context.Context, page string, rawURL string, fetch func(ctx context.Context) error) error {
+	logger := loggerFromContext(ctx).With(LOG_URL, rawURL)
+	breaker := circuitBreakerFor(rawURL)
+
+	for attempt := 1; ; attempt++ {
+		allowed, probe := breaker.allow()
+		if !allowed {
+			return fmt.Errorf("unable to get %s: %w", rawURL, errCircuitOpen)
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, appConfig.Crawl.NavigationTimeout)
+		err := fetch(attemptCtx)
+		cancel()
+
+		// The cancellation of the crawl says nothing about the host
+		if ctx.Err() != nil {
+			breaker.record(ctx, rawURL, probe, false)
+			if err == nil {
+				return nil
+			}
+			return err
+		}
+
+		retryable, hostDown := classifyFetchError(err)
+		breaker.record(ctx, rawURL, probe, hostDown)
+		if err == nil || !retryable || attempt >= appConfig.Crawl.NavigationRetries {
+			return err
+		}
+
+		delay := retryDelay(attempt)
+		fetchRetriesTotal.WithLabelValues(page).Inc()
+		logger.Warn("The fetch failed, retrying it", "attempt", attempt, "delay", delay.String(), LOG_ERROR, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// The number of distinct urls the failures of a domain must come from to open its circuit breaker, so a page failing all its retries does not open it alone
+const BREAKER_MIN_FAILED_URLS = 2
+
+// This variable matches the path segments giving the language of a page, like "fr" or "en-gb", which do not tell a tenant of an applicant tracking system
+var languageSegmentRegexp = regexp.MustCompile(`^[a-z]{2}([-_][a-z]{2})?$`)
+
+// This variable stores the state of the circuit breaker of a domain. It opens after crawl.breaker_failures fetches in a row, of at least
+// BREAKER_MIN_FAILED_URLS urls, found the domain down, and lets one fetch try it again after crawl.breaker_cooldown, closing if it succeeds
+// and opening again if it fails.
+type circuitBreaker struct {
+	mu         sync.Mutex
+	failures   int
+	failedURLs map[string]bool
+	openUntil  time.Time
+	// Whether the fetch trying the domain once the cooldown is over is running, only this fetch deciding the state of the breaker
+	probing bool
+}
+
+// This variable stores the circuit breakers, by registrable domain, or by tenant for the applicant tracking systems
+var circuitBreakers = struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}{breakers: make(map[string]*circuitBreaker)}
+
+// This function returns the key of the circuit breaker of an url. The hosts of a domain, like jobs.example.com and www.example.com, share it,
+// while the companies hosted on an applicant tracking system each have their own, by host and first path segment, as jobs.lever.co/example.
+func circuitBreakerKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return rawURL
+	}
+	host := strings.ToLower(u.Hostname())
+	domain := registrableDomain(host)
+	if !atsDomains[domain] {
+		return domain
+	}
+
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "" || languageSegmentRegexp.MatchString(strings.ToLower(segment)) {
+			continue
+		}
+		return host + "/" + segment
+	}
+	return host
+}
+
+// This function returns the circuit breaker of an url, see circuitBreakerKey for the urls sharing it
+func circuitBreakerFor(rawURL string) *circuitBreaker {
+	key := circuitBreakerKey(rawURL)
+
+	circuitBreakers.mu.Lock()
+	defer circuitBreakers.mu.Unlock()
+
+	breaker, ok := circuitBreakers.breakers[key]
+	if !ok {
+		breaker = &circuitBreaker{}
+		circuitBreakers.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// This function tells whether a fetch can be made, it returns false while the breaker is open or while another fetch is trying the domain.
+// It also tells whether the fetch is the one trying the domain once the cooldown is over, whose outcome must be recorded as the probe.
+func (b *circuitBreaker) allow() (allowed bool, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true, false
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// This function records the outcome of a fetch. While the breaker is closed, a fetch that did not find the domain down resets the failures.
+// Once it opened, only the fetch allowed as the probe decides: the breaker closes if it succeeded and opens again if it found the domain down,
+// the fetches that were running when it opened being ignored.
+func (b *circuitBreaker) record(ctx context.Context, rawURL string, probe bool, hostDown bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	} else if !b.openUntil.IsZero() {
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if !hostDown {
+		b.failures = 0
+		b.failedURLs = nil
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.failures++
+	if b.failedURLs == nil {
+		b.failedURLs = make(map[string]bool)
+	}
+	b.failedURLs[rawURL] = true
+	if !probe && (b.failures < appConfig.Crawl.BreakerFailures || len(b.failedURLs) < BREAKER_MIN_FAILED_URLS) {
+		return
+	}
+	circuitBreakerTripsTotal.Inc()
+	loggerFromContext(ctx).Warn("The domain looks down, its circuit breaker is open", LOG_URL, rawURL, "failures", b.failures, "cooldown", appConfig.Crawl.BreakerCooldown.String())
+	b.openUntil = time.Now().Add(appConfig.Crawl.BreakerCooldown)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		rawURL string
+		want   string
+	}{
+		{"company website", "https://www.example.fr/carrieres", "example.fr"},
+		{"company subdomain", "https://jobs.example.fr/offres/42", "example.fr"},
+		{"multi part suffix", "https://careers.example.co.uk/jobs", "example.co.uk"},
+		{"ats tenant", "https://jobs.lever.co/example/123", "jobs.lever.co/example"},
+		{"other ats tenant", "https://jobs.lever.co/other/123", "jobs.lever.co/other"},
+		{"ats tenant after the language", "https://www.welcometothejungle.com/fr/companies/example", "www.welcometothejungle.com/companies"},
+		{"ats tenant host", "https://example.teamtailor.com/jobs", "example.teamtailor.com/jobs"},
+		{"ats without path", "https://boards.greenhouse.io", "boards.greenhouse.io"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := circuitBreakerKey(test.rawURL); got != test.want {
+				t.Errorf("circuitBreakerKey(%q) = %q, want %q", test.rawURL, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerOpensOnDistinctURLs(t *testing.T) {
+	appConfig = defaultConfig()
+	ctx := context.Background()
+	breaker := &circuitBreaker{}
+
+	// A page failing all its retries does not open the breaker alone
+	for i := 0; i < appConfig.Crawl.BreakerFailures*2; i++ {
+		breaker.record(ctx, "https://jobs.example.fr/offres/1", false, true)
+	}
+	if allowed, _ := breaker.allow(); !allowed {
+		t.Fatal("the failures of a single page opened the breaker")
+	}
+
+	breaker.record(ctx, "https://jobs.example.fr/offres/2", false, true)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Fatal("the failures of two pages did not open the breaker")
+	}
+
+	// A success resets the failures
+	other := &circuitBreaker{}
+	for i := 0; i < appConfig.Crawl.BreakerFailures-1; i++ {
+		other.record(ctx, fmt.Sprintf("https://jobs.example.fr/offres/%d", i), false, true)
+	}
+	other.record(ctx, "https://jobs.example.fr", false, false)
+	other.record(ctx, "https://jobs.example.fr/offres/z", false, true)
+	if allowed, _ := other.allow(); !allowed {
+		t.Error("the failures before a success opened the breaker")
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	appConfig = defaultConfig()
+	ctx := context.Background()
+
+	// This function returns a breaker whose cooldown is over
+	cooledDown := func() *circuitBreaker {
+		return &circuitBreaker{failures: appConfig.Crawl.BreakerFailures, openUntil: time.Now().Add(-time.Second)}
+	}
+
+	breaker := cooledDown()
+	allowed, probe := breaker.allow()
+	if !allowed || !probe {
+		t.Fatalf("the first fetch after the cooldown is allowed %v as the probe %v, want both", allowed, probe)
+	}
+
+	// A fetch started before the breaker opened neither ends the probe nor decides the state
+	breaker.record(ctx, "https://www.example.fr/a", false, false)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("a second fetch was allowed while the probe runs")
+	}
+	breaker.record(ctx, "https://www.example.fr/b", false, true)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("a second fetch was allowed while the probe runs")
+	}
+
+	// The probe finding the domain down opens the breaker again
+	breaker.record(ctx, "https://www.example.fr/c", true, true)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("the breaker did not open again after the probe failed")
+	}
+	if breaker.openUntil.Before(time.Now()) {
+		t.Error("the breaker was not given a new cooldown after the probe failed")
+	}
+
+	// The probe succeeding closes the breaker
+	breaker = cooledDown()
+	_, probe = breaker.allow()
+	breaker.record(ctx, "https://www.example.fr/c", probe, false)
+	allowed, probe = breaker.allow()
+	if !allowed || probe {
+		t.Errorf("after the probe succeeded a fetch is allowed %v as a probe %v, want allowed and not a probe", allowed, probe)
+	}
+
+	// A probe cut short by the cancellation lets another fetch probe the domain
+	breaker = cooledDown()
+	_, probe = breaker.allow()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	breaker.record(cancelled, "https://www.example.fr/c", probe, true)
+	if allowed, probe := breaker.allow(); !allowed || !probe {
+		t.Errorf("after a cancelled probe a fetch is allowed %v as the probe %v, want both", allowed, probe)
+	}
+}
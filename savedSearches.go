@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The frequencies at which a saved search digest can be sent
+const DIGEST_DAILY = "daily"
+const DIGEST_WEEKLY = "weekly"
+
+// This variable stores an offers query saved by someone who wants to receive its new matches by email
+type SavedSearch struct {
+	ID     int
+	UserID int
+	// The email of the user, where the digests are sent
+	Email              string
+	Name               string `binding:"required"`
+	Keywords           string
+	Category           string
+	Location           string
+	Remote             bool
+	ExcludeContractors bool
+	Frequency          string `binding:"required,oneof=daily weekly"`
+	LastSentAt         *time.Time
+	CreatedAt          time.Time
+}
+
+// The columns read when scanning a saved search with scanSavedSearch
+const SAVED_SEARCH_COLUMNS = `id, user_id, (SELECT u.email FROM users u WHERE u.id = saved_searches.user_id), name, keywords, category, location, remote, exclude_contractors, frequency, last_sent_at, created_at`
+
+// This function scans a row selected with the SAVED_SEARCH_COLUMNS columns
+func scanSavedSearch(row pgx.Row) (SavedSearch, error) {
+	var search SavedSearch
+	err := row.Scan(&search.ID, &search.UserID, &search.Email, &search.Name, &search.Keywords, &search.Category, &search.Location, &search.Remote, &search.ExcludeContractors, &search.Frequency, &search.LastSentAt, &search.CreatedAt)
+	return search, err
+}
+
+// This function returns the offers search corresponding to a saved search
+func (search SavedSearch) searchParams() OfferSearchParams {
+	return OfferSearchParams{
+		Query:              search.Keywords,
+		Category:           search.Category,
+		Location:           search.Location,
+		Remote:             search.Remote,
+		ExcludeContractors: search.ExcludeContractors,
+		Limit:              SEARCH_MAX_LIMIT,
+	}
+}
+
+func createSavedSearch(ctx context.Context, db *pgxpool.Pool, search SavedSearch) (SavedSearch, error) {
+	query := `INSERT INTO saved_searches (user_id, name, keywords, category, location, remote, exclude_contractors, frequency)
+		VALUES (@user_id, @name, @keywords, @category, @location, @remote, @exclude_contractors, @frequency)
+		RETURNING ` + SAVED_SEARCH_COLUMNS
+	args := pgx.NamedArgs{
+		"user_id":             search.UserID,
+		"name":                search.Name,
+		"keywords":            search.Keywords,
+		"category":            search.Category,
+		"location":            search.Location,
+		"remote":              search.Remote,
+		"exclude_contractors": search.ExcludeContractors,
+		"frequency":           search.Frequency,
+	}
+	search, err := scanSavedSearch(db.QueryRow(ctx, query, args))
+	if err != nil {
+		return search, fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return search, err
+}
+
+// This function updates the query of a saved search, and returns false if it does not exist or belongs to another user
+func updateSavedSearch(ctx context.Context, db *pgxpool.Pool, search SavedSearch) (SavedSearch, bool, error) {
+	query := `UPDATE saved_searches SET name = @name, keywords = @keywords, category = @category, location = @location,
+		remote = @remote, exclude_contractors = @exclude_contractors, frequency = @frequency
+		WHERE id = @id AND user_id = @user_id RETURNING ` + SAVED_SEARCH_COLUMNS
+	args := pgx.NamedArgs{
+		"id":                  search.ID,
+		"user_id":             search.UserID,
+		"name":                search.Name,
+		"keywords":            search.Keywords,
+		"category":            search.Category,
+		"location":            search.Location,
+		"remote":              search.Remote,
+		"exclude_contractors": search.ExcludeContractors,
+		"frequency":           search.Frequency,
+	}
+	search, err := scanSavedSearch(db.QueryRow(ctx, query, args))
+	switch {
+	case err == pgx.ErrNoRows:
+		return search, false, nil
+	case err != nil:
+		return search, false, fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return search, true, nil
+}
+
+// This function records the date until which the new matches of a saved search have been sent
+func updateSavedSearchLastSentAt(ctx context.Context, db *pgxpool.Pool, id int, lastSentAt time.Time) error {
+	query := `UPDATE saved_searches SET last_sent_at = @last_sent_at WHERE id = @id`
+	args := pgx.NamedArgs{
+		"id":           id,
+		"last_sent_at": lastSentAt,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return err
+}
+
+// This function returns a saved search of a user, or false if it does not exist or belongs to another user
+func getSavedSearch(ctx context.Context, db *pgxpool.Pool, userID int, id int) (SavedSearch, bool, error) {
+	query := `SELECT ` + SAVED_SEARCH_COLUMNS + ` FROM saved_searches WHERE id = @id AND user_id = @user_id`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": userID,
+	}
+	search, err := scanSavedSearch(db.QueryRow(ctx, query, args))
+	switch {
+	case err == pgx.ErrNoRows:
+		return search, false, nil
+	case err != nil:
+		return search, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	return search, true, nil
+}
+
+// This function returns the saved searches of a user
+func getSavedSearches(ctx context.Context, db *pgxpool.Pool, userID int) ([]SavedSearch, error) {
+	var searches []SavedSearch
+
+	query := `SELECT ` + SAVED_SEARCH_COLUMNS + ` FROM saved_searches WHERE user_id = @user_id ORDER BY id`
+	args := pgx.NamedArgs{
+		"user_id": userID,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return searches, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return searches, fmt.Errorf("unable to scan row: %w", err)
+		}
+		searches = append(searches, search)
+	}
+
+	return searches, rows.Err()
+}
+
+// This function returns the saved searches whose digest has not been sent for a day or a week depending on their frequency
+func getDueSavedSearches(ctx context.Context, db *pgxpool.Pool, now time.Time) ([]SavedSearch, error) {
+	var searches []SavedSearch
+
+	query := `SELECT ` + SAVED_SEARCH_COLUMNS + ` FROM saved_searches
+		WHERE last_sent_at IS NULL
+		OR (frequency = 'daily' AND last_sent_at <= @now - interval '1 day')
+		OR (frequency = 'weekly' AND last_sent_at <= @now - interval '7 days')
+		ORDER BY id`
+	args := pgx.NamedArgs{
+		"now": now,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return searches, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return searches, fmt.Errorf("unable to scan row: %w", err)
+		}
+		searches = append(searches, search)
+	}
+
+	return searches, rows.Err()
+}
+
+func deleteSavedSearch(ctx context.Context, db *pgxpool.Pool, userID int, id int) (bool, error) {
+	query := `DELETE FROM saved_searches WHERE id = @id AND user_id = @user_id`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": userID,
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, err
+}
+
+// This function reads the saved search id of the request path, and answers with an error if it is not valid or the saved search does not exist for the current user
+func getSavedSearchFromPath(c *gin.Context) (SavedSearch, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return SavedSearch{}, false
+	}
+
+	search, exists, err := getSavedSearch(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return search, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return search, false
+	}
+
+	return search, true
+}
+
+func getSavedSearchesAPI(c *gin.Context) {
+	searches, err := getSavedSearches(c.Request.Context(), dbpoolapi, currentUserID(c))
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": searches})
+}
+
+func getSavedSearchAPI(c *gin.Context) {
+	search, ok := getSavedSearchFromPath(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": search})
+}
+
+func createSavedSearchAPI(c *gin.Context) {
+	var search SavedSearch
+	if err := c.ShouldBindJSON(&search); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, _ := currentUser(c)
+	search.UserID = user.ID
+
+	search, err := createSavedSearch(c.Request.Context(), dbpoolapi, search)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": search})
+}
+
+func updateSavedSearchAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	var search SavedSearch
+	if err := c.ShouldBindJSON(&search); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, _ := currentUser(c)
+	search.ID = id
+	search.UserID = user.ID
+
+	search, exists, err := updateSavedSearch(c.Request.Context(), dbpoolapi, search)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": search})
+}
+
+func deleteSavedSearchAPI(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return
+	}
+
+	exists, err := deleteSavedSearch(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// This function returns the offers currently matching a saved search
+func getSavedSearchOffersAPI(c *gin.Context) {
+	search, ok := getSavedSearchFromPath(c)
+	if !ok {
+		return
+	}
+
+	response, err := searchOffers(c.Request.Context(), dbpoolapi, search.searchParams())
+	if err != nil {
+		slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
@@ -2,45 +2,44 @@ package main
 
 import (
 	"context"
-	"log"
-
-	"github.com/chromedp/chromedp"
+	"fmt"
+	"sync"
 )
 
-func createBrowser() (context.Context, context.CancelFunc) {
-	var cancelFuncs []context.CancelFunc
-	// browserURL := "ws://0.0.0.0:9222/devtools/browser/27ac1994-a333-4241-90d6-74ed95b29723"
-	// ctx, cancel := chromedp.NewRemoteAllocator(context.Background(), browserURL)
-	// cancelFuncs = append(cancelFuncs, cancel)
-
-	// define the proxy settings
-	options := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.UserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/115.0.0.0 Safari/537.36"),
-		chromedp.Flag("headless", true),
-		chromedp.WindowSize(1920, 1080),
-	)
-
-	// specify a new context set up for NewContext
-	ctx, cancel := chromedp.NewExecAllocator(context.Background(), options...)
-	cancelFuncs = append(cancelFuncs, cancel)
-
-	ctx, cancel = chromedp.NewContext(ctx)
-	if err := chromedp.Run(ctx); err != nil {
-		log.Fatal("couldn't create browser context: %w", err)
+// This function launches the browser of the crawl, it is closed when the context given is canceled or when the function returned is called.
+// The browser is reached through the tab pool of the context returned.
+func createBrowser(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	pool, err := newTabPool(loggerFromContext(ctx))
+	if err != nil {
+		return nil, nil, err
 	}
-	cancelFuncs = append(cancelFuncs, cancel)
 
-	cancel = func() {
-		for _, c := range cancelFuncs {
-			c()
-		}
+	stop := context.AfterFunc(ctx, pool.close)
+	cancel := func() {
+		stop()
+		pool.close()
 	}
 
-	return ctx, cancel
+	return context.WithValue(ctx, tabPoolKey{}, pool), cancel, nil
 }
 
-func createTab(ctx context.Context) (context.Context, context.CancelFunc) {
-	ctx, cancel := chromedp.NewContext(ctx)
+// This function lends a tab of the browser pool. The navigations run in the context returned, whose timeouts and cancellation
+// stop the navigations and not the tab, which is given back to the pool when the function returned is called.
+func createTab(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	pool, ok := tabPoolFromContext(ctx)
+	if !ok {
+		return nil, nil, fmt.Errorf("unable to open a browser tab: %w", errTabPoolClosed)
+	}
+
+	tab, err := pool.borrow(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open a browser tab: %w", err)
+	}
 
-	return ctx, cancel
+	ctx, cancel := context.WithCancel(tabContext{Context: ctx, tab: tab.ctx, pooled: tab})
+	var once sync.Once
+	return ctx, func() {
+		cancel()
+		once.Do(func() { pool.giveBack(tab) })
+	}, nil
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The signals stopping the process, SIGTERM is the one sent by docker and kubernetes
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// This variable stores the error of a crawl stopped by a signal before its end
+var errCrawlInterrupted = errors.New("the crawl was interrupted")
+
+// This function returns a context canceled at the first SIGINT or SIGTERM, the commands stop taking new work when it is
+func notifyShutdown() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), shutdownSignals...)
+}
+
+// This function returns the context of the work in progress of a crawl. Once the crawl is stopping, the work in progress
+// is given the time given to finish before its context is canceled, a second signal cancels it right away.
+func drainContext(stopping context.Context, timeout time.Duration, logger *slog.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(stopping))
+
+	go func() {
+		select {
+		case <-stopping.Done():
+		case <-ctx.Done():
+			return
+		}
+		logger.Warn("Stopping the crawl, the companies in progress are given time to finish", "timeout", timeout.String())
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, shutdownSignals...)
+		defer signal.Stop(signals)
+
+		select {
+		case <-time.After(timeout):
+			logger.Warn("The companies in progress did not finish in time, cancelling them")
+		case <-signals:
+			logger.Warn("Second signal received, cancelling the companies in progress")
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+	}()
+
+	return ctx, cancel
+}
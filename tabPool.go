@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/target"
+	"github.com/chromedp/chromedp"
+)
+
+// The time given to a tab to answer the check made before lending it, or to go back to a blank page once returned
+const TAB_CHECK_TIMEOUT = 10 * time.Second
+
+// The time between two measures of the memory used by the browser
+const BROWSER_MEMORY_CHECK_INTERVAL = 30 * time.Second
+
+// The reasons a tab of the pool is closed, used as the reason label of the closed tabs metric
+const TAB_RECYCLED = "recycled"
+const TAB_UNHEALTHY = "unhealthy"
+
+// The kind of the requests blocked as their domain is a tracker, the other kinds being their resource type
+const BLOCKED_TRACKER = "tracker"
+
+// This variable stores the error of a tab asked to a pool that was closed
+var errTabPoolClosed = errors.New("the browser is closed")
+
+// This variable stores the resource types that can be blocked, the documents being the pages themselves
+var blockableResourceTypes = []network.ResourceType{
+	network.ResourceTypeStylesheet,
+	network.ResourceTypeImage,
+	network.ResourceTypeMedia,
+	network.ResourceTypeFont,
+	network.ResourceTypeScript,
+	network.ResourceTypeTextTrack,
+	network.ResourceTypeXHR,
+	network.ResourceTypeFetch,
+	network.ResourceTypePrefetch,
+	network.ResourceTypeEventSource,
+	network.ResourceTypeWebSocket,
+	network.ResourceTypeManifest,
+	network.ResourceTypePing,
+	network.ResourceTypeOther,
+}
+
+// This function returns the resource types of a comma separated list, like browser.blocked_resources
+func blockedResourceTypes(list string) ([]network.ResourceType, error) {
+	var types []network.ResourceType
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(blockableResourceTypes, func(t network.ResourceType) bool {
+			return strings.EqualFold(string(t), name)
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not a resource type that can be blocked", name)
+		}
+		types = append(types, blockableResourceTypes[i])
+	}
+
+	return types, nil
+}
+
+// This variable stores a tab of the pool, the pages it opened and the console errors of the pages it showed since it was lent
+type pooledTab struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	uses   int
+
+	consoleMu sync.Mutex
+	console   []string
+}
+
+type pooledTabKey struct{}
+
+// This variable stores the tabs of the browser: browser.tabs are lent at a time, and the ones returned are kept for the next pages.
+// The browser is not the parent of the contexts of the crawl, so it can be restarted without canceling them.
+type tabPool struct {
+	slots  chan struct{}
+	logger *slog.Logger
+
+	mu            sync.Mutex
+	browser       context.Context
+	cancelBrowser context.CancelFunc
+	idle          []*pooledTab
+	closed        bool
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+type tabPoolKey struct{}
+
+// This function launches the browser of a tab pool, and starts measuring its memory when browser.max_memory_mb is set
+func newTabPool(logger *slog.Logger) (*tabPool, error) {
+	pool := &tabPool{
+		slots:  make(chan struct{}, appConfig.Browser.Tabs),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+
+	err := pool.launch()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	pool.stop = stop
+	go func() {
+		defer close(pool.done)
+		if appConfig.Browser.MaxMemoryMB > 0 {
+			pool.watchMemory(ctx)
+		}
+	}()
+
+	return pool, nil
+}
+
+// This function returns the tab pool of a context
+func tabPoolFromContext(ctx context.Context) (*tabPool, bool) {
+	pool, ok := ctx.Value(tabPoolKey{}).(*tabPool)
+	return pool, ok
+}
+
+// This function launches the browser, or connects to the one of browser.remote_url, the pool lock being held
+func (p *tabPool) launch() error {
+	var cancelFuncs []context.CancelFunc
+
+	ctx, cancel := newBrowserAllocator(context.Background())
+	cancelFuncs = append(cancelFuncs, cancel)
+
+	ctx, cancel = chromedp.NewContext(ctx)
+	cancelFuncs = append(cancelFuncs, cancel)
+
+	// The browser is closed before the allocator, which kills its process. A remote browser keeps running, only the tabs are closed.
+	cancel = func() {
+		for i := len(cancelFuncs) - 1; i >= 0; i-- {
+			cancelFuncs[i]()
+		}
+	}
+
+	if err := chromedp.Run(ctx); err != nil {
+		cancel()
+		if appConfig.Browser.RemoteURL != "" {
+			return fmt.Errorf("unable to connect to the browser at %s: %w", appConfig.Browser.RemoteURL, err)
+		}
+		return fmt.Errorf("unable to launch the browser: %w", err)
+	}
+
+	p.browser = ctx
+	p.cancelBrowser = cancel
+	return nil
+}
+
+// This function returns the allocator of the browsers given by the configuration : a connection to the browser of browser.remote_url,
+// or a local chrome launched with the browser options
+func newBrowserAllocator(ctx context.Context) (context.Context, context.CancelFunc) {
+	if appConfig.Browser.RemoteURL != "" {
+		return chromedp.NewRemoteAllocator(ctx, appConfig.Browser.RemoteURL)
+	}
+
+	options := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.UserAgent(appConfig.Browser.UserAgent),
+		chromedp.Flag("headless", appConfig.Browser.Headless),
+		chromedp.WindowSize(appConfig.Browser.WindowWidth, appConfig.Browser.WindowHeight),
+	)
+	if appConfig.Browser.Locale != "" {
+		options = append(options, chromedp.Flag("lang", appConfig.Browser.Locale))
+	}
+
+	// specify a new context set up for NewContext
+	return chromedp.NewExecAllocator(ctx, options...)
+}
+
+// This function closes the tabs kept and the browser, the pool lock being held
+func (p *tabPool) shutdown() {
+	for _, tab := range p.idle {
+		tab.cancel()
+	}
+	p.idle = nil
+	if p.cancelBrowser != nil {
+		p.cancelBrowser()
+	}
+	p.browser = nil
+	p.cancelBrowser = nil
+}
+
+// This function closes the browser, the tabs lent are closed when returned
+func (p *tabPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.shutdown()
+	p.mu.Unlock()
+
+	p.stop()
+	<-p.done
+}
+
+// This function lends a tab, waiting for one while browser.tabs are lent. A tab kept from a previous page is checked before being lent,
+// and a new one is opened when none is kept or healthy.
+func (p *tabPool) borrow(ctx context.Context) (*pooledTab, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.slots
+			return nil, errTabPoolClosed
+		}
+		var tab *pooledTab
+		if len(p.idle) > 0 {
+			tab = p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+		}
+		p.mu.Unlock()
+
+		if tab == nil {
+			break
+		}
+		if p.check(tab) {
+			return tab, nil
+		}
+		p.discard(tab, TAB_UNHEALTHY)
+	}
+
+	tab, err := p.open()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return tab, nil
+}
+
+// This function opens a new tab in the browser, launching it again if its restart failed. With proxies, the tab is opened
+// in a browser context of its own going through the next proxy, so the proxies are used in turn by the tabs.
+func (p *tabPool) open() (*pooledTab, error) {
+	p.mu.Lock()
+	if p.browser == nil {
+		err := p.launch()
+		if err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+	}
+	browser := p.browser
+	p.mu.Unlock()
+
+	var options []chromedp.ContextOption
+	proxy := nextProxy()
+	if proxy != nil {
+		options = append(options, chromedp.WithNewBrowserContext(func(params *target.CreateBrowserContextParams) *target.CreateBrowserContextParams {
+			return params.WithProxyServer(proxyServer(proxy))
+		}))
+	}
+
+	ctx, cancel := chromedp.NewContext(browser, options...)
+	err := chromedp.Run(ctx, emulateLocale(), interceptRequests(ctx, proxy))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("unable to open a browser tab: %w", err)
+	}
+
+	tab := &pooledTab{ctx: ctx, cancel: cancel}
+	chromedp.ListenTarget(ctx, tab.recordConsole)
+	return tab, nil
+}
+
+// This function tells whether a tab kept from a previous page still answers
+func (p *tabPool) check(tab *pooledTab) bool {
+	ctx, cancel := context.WithTimeout(tab.ctx, TAB_CHECK_TIMEOUT)
+	defer cancel()
+
+	var result int
+	err := chromedp.Run(ctx, chromedp.Evaluate(`1`, &result))
+	return err == nil && result == 1
+}
+
+// This function takes back a lent tab. The tab is kept for the next pages once back to a blank page, unless it opened browser.tab_max_uses pages,
+// in which case it is closed so the memory it leaked is freed.
+func (p *tabPool) giveBack(tab *pooledTab) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		tab.cancel()
+		return
+	}
+
+	tab.uses++
+	tab.clearConsole()
+	if tab.uses >= appConfig.Browser.TabMaxUses {
+		p.discard(tab, TAB_RECYCLED)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tab.ctx, TAB_CHECK_TIMEOUT)
+	err := chromedp.Run(ctx, chromedp.Navigate("about:blank"))
+	cancel()
+	if err != nil {
+		p.discard(tab, TAB_UNHEALTHY)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// The tabs of a browser closed or restarted while they were lent are not kept
+	if p.closed || p.browser == nil || chromedp.FromContext(tab.ctx).Browser != chromedp.FromContext(p.browser).Browser {
+		tab.cancel()
+		return
+	}
+	p.idle = append(p.idle, tab)
+}
+
+// This function closes a tab of the pool
+func (p *tabPool) discard(tab *pooledTab, reason string) {
+	browserTabsClosedTotal.WithLabelValues(reason).Inc()
+	tab.cancel()
+}
+
+// This function measures the memory of the browser every BROWSER_MEMORY_CHECK_INTERVAL, and restarts it once above browser.max_memory_mb
+func (p *tabPool) watchMemory(ctx context.Context) {
+	ticker := time.NewTicker(BROWSER_MEMORY_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		var process *os.Process
+		if p.browser != nil {
+			process = chromedp.FromContext(p.browser).Browser.Process()
+		}
+		p.mu.Unlock()
+		if process == nil {
+			continue
+		}
+
+		memory, err := processTreeMemory(process.Pid)
+		if err != nil {
+			p.logger.Debug("Unable to measure the memory of the browser", LOG_ERROR, err)
+			continue
+		}
+		browserMemoryBytes.Set(float64(memory))
+
+		if memory > int64(appConfig.Browser.MaxMemoryMB)<<20 {
+			p.logger.Warn("The browser uses too much memory, restarting it", "memory_mb", memory>>20, "max_memory_mb", appConfig.Browser.MaxMemoryMB)
+			p.restart(ctx)
+		}
+	}
+}
+
+// This function restarts the browser once the tabs lent are returned, the pages waiting for a tab meanwhile
+func (p *tabPool) restart(ctx context.Context) {
+	taken := 0
+	defer func() {
+		for ; taken > 0; taken-- {
+			<-p.slots
+		}
+	}()
+	for ; taken < cap(p.slots); taken++ {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+
+	browserRestartsTotal.Inc()
+	p.shutdown()
+	// A browser that does not launch again is launched by the next tab asked
+	err := p.launch()
+	if err != nil {
+		p.logger.Error("Unable to restart the browser", LOG_ERROR, err)
+	}
+}
+
+// This function returns the action making a tab send browser.user_agent and browser.accept_language, and use browser.locale,
+// which a remote browser is not launched with
+func emulateLocale() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		err := emulation.SetUserAgentOverride(appConfig.Browser.UserAgent).WithAcceptLanguage(appConfig.Browser.AcceptLanguage).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to set the user agent: %w", err)
+		}
+		if appConfig.Browser.Locale == "" {
+			return nil
+		}
+		err = emulation.SetLocaleOverride().WithLocale(appConfig.Browser.Locale).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to set the locale: %w", err)
+		}
+		return nil
+	})
+}
+
+// This function returns the kind of a request blocked by the tabs, its resource type when it is one of browser.blocked_resources
+// or tracker when its host is in browser.blocked_domains, and false when it is not blocked
+func blockedRequestKind(blockedTypes []network.ResourceType, resourceType network.ResourceType, rawURL string) (string, bool) {
+	if slices.Contains(blockedTypes, resourceType) {
+		return strings.ToLower(string(resourceType)), true
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range strings.Split(appConfig.Browser.BlockedDomains, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return BLOCKED_TRACKER, true
+		}
+	}
+	return "", false
+}
+
+// This function returns the action making a tab block the requests of browser.blocked_resources types and to the browser.blocked_domains,
+// and give its credentials to the proxy of the tab. Only the blocked requests are paused, unless the proxy has credentials, in which case
+// every request is, to be answered the proxy authentication.
+func interceptRequests(ctx context.Context, proxy *url.URL) chromedp.Action {
+	return chromedp.ActionFunc(func(actionCtx context.Context) error {
+		blockedTypes, err := blockedResourceTypes(appConfig.Browser.BlockedResources)
+		if err != nil {
+			return err
+		}
+
+		var patterns []*fetch.RequestPattern
+		for _, resourceType := range blockedTypes {
+			patterns = append(patterns, &fetch.RequestPattern{URLPattern: "*", ResourceType: resourceType})
+		}
+		for _, domain := range strings.Split(appConfig.Browser.BlockedDomains, ",") {
+			domain = strings.TrimSpace(domain)
+			if domain == "" {
+				continue
+			}
+			patterns = append(patterns,
+				&fetch.RequestPattern{URLPattern: "*://" + domain + "/*"},
+				&fetch.RequestPattern{URLPattern: "*://*." + domain + "/*"},
+			)
+		}
+		proxyAuth := proxy != nil && proxy.User != nil
+		if proxyAuth {
+			patterns = []*fetch.RequestPattern{{URLPattern: "*"}}
+		}
+		if len(patterns) == 0 {
+			return nil
+		}
+
+		// The requests blocked fail as if blocked by an extension
+		chromedp.ListenTarget(ctx, func(ev interface{}) {
+			c := chromedp.FromContext(ctx)
+			executorCtx := cdp.WithExecutor(ctx, c.Target)
+
+			switch ev := ev.(type) {
+			case *fetch.EventRequestPaused:
+				kind, blocked := blockedRequestKind(blockedTypes, ev.ResourceType, ev.Request.URL)
+				if blocked {
+					browserRequestsBlockedTotal.WithLabelValues(kind).Inc()
+				}
+				go func() {
+					if blocked {
+						_ = fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(executorCtx)
+					} else {
+						_ = fetch.ContinueRequest(ev.RequestID).Do(executorCtx)
+					}
+				}()
+			case *fetch.EventAuthRequired:
+				// The sites asking for credentials are given none
+				response := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseCancelAuth}
+				if ev.AuthChallenge.Source == fetch.AuthChallengeSourceProxy {
+					password, _ := proxy.User.Password()
+					response = &fetch.AuthChallengeResponse{
+						Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+						Username: proxy.User.Username(),
+						Password: password,
+					}
+				}
+				go func() {
+					_ = fetch.ContinueWithAuth(ev.RequestID, response).Do(executorCtx)
+				}()
+			}
+		})
+
+		return fetch.Enable().WithPatterns(patterns).WithHandleAuthRequests(proxyAuth).Do(actionCtx)
+	})
+}
+
+// This function returns the resident memory of a process and of its children, like the renderers of a browser. It reads /proc, so it is only
+// available on Linux.
+func processTreeMemory(pid int) (int64, error) {
+	statFiles, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return 0, fmt.Errorf("unable to list the processes: %w", err)
+	}
+	if len(statFiles) == 0 {
+		return 0, fmt.Errorf("unable to list the processes: %w", errors.ErrUnsupported)
+	}
+
+	children := make(map[int][]int)
+	memory := make(map[int]int64)
+	pageSize := int64(os.Getpagesize())
+	for _, statFile := range statFiles {
+		content, err := os.ReadFile(statFile)
+		if err != nil {
+			// The process ended meanwhile
+			continue
+		}
+		// The fields following the command name, which is between parentheses and may contain spaces
+		end := bytes.LastIndexByte(content, ')')
+		if end < 0 {
+			continue
+		}
+		fields := strings.Fields(string(content[end+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+		processID, err := strconv.Atoi(filepath.Base(filepath.Dir(statFile)))
+		if err != nil {
+			continue
+		}
+		parentID, _ := strconv.Atoi(fields[1])
+		residentPages, _ := strconv.ParseInt(fields[21], 10, 64)
+
+		children[parentID] = append(children[parentID], processID)
+		memory[processID] = residentPages * pageSize
+	}
+
+	if _, ok := memory[pid]; !ok {
+		return 0, fmt.Errorf("unable to find the process %d", pid)
+	}
+
+	total := int64(0)
+	queue := []int{pid}
+	for len(queue) > 0 {
+		processID := queue[0]
+		queue = queue[1:]
+		total += memory[processID]
+		queue = append(queue, children[processID]...)
+	}
+	return total, nil
+}
+
+// This variable stores the context of a page opened in a tab of the pool: its values and its cancellation are the ones of the page,
+// the chromedp values the ones of the tab, so canceling it does not close the tab.
+type tabContext struct {
+	context.Context
+	tab    context.Context
+	pooled *pooledTab
+}
+
+func (c tabContext) Value(key any) any {
+	if _, ok := key.(pooledTabKey); ok {
+		return c.pooled
+	}
+	// chromedp stores its state under a single key, the one of the tab shadows the one of the page
+	if value, ok := c.tab.Value(key).(*chromedp.Context); ok {
+		return value
+	}
+	return c.Context.Value(key)
+}
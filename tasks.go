@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The kinds of tasks of the queue. An enrichment task enqueues the offers task of its company once done.
+const TASK_ENRICH = "enrich"
+const TASK_OFFERS = "offers"
+
+// The statuses of a task
+const TASK_STATUS_PENDING = "pending"
+const TASK_STATUS_RUNNING = "running"
+const TASK_STATUS_DONE = "done"
+const TASK_STATUS_FAILED = "failed"
+
+// The number of tasks listed by the tasks endpoint
+const TASKS_LIST_LIMIT = 100
+
+// This variable stores a task of the queue, run by the worker holding its lease
+type CrawlTask struct {
+	ID             int64
+	Kind           string
+	CompanyName    string
+	Status         string
+	Attempts       int
+	MaxAttempts    int
+	RunAfter       time.Time
+	LeasedBy       string
+	LeaseExpiresAt *time.Time
+	LastError      string
+	CreatedAt      time.Time
+	FinishedAt     *time.Time
+}
+
+// This variable stores the number of tasks of a kind in a status
+type CrawlTaskCount struct {
+	Kind   string
+	Status string
+	Count  int
+}
+
+func isTaskKind(kind string) bool {
+	return kind == TASK_ENRICH || kind == TASK_OFFERS
+}
+
+// This function adds a task of the kind given for each company given, or for every company when none is given.
+// The companies already having a task of this kind waiting or running are left out, it returns the number of tasks added.
+func enqueueTasks(ctx context.Context, db *pgxpool.Pool, kind string, companyNames []string) (int64, error) {
+	if companyNames == nil {
+		companyNames = []string{}
+	}
+
+	query := `INSERT INTO crawl_tasks (kind, company_name, max_attempts)
+		SELECT @kind::text, name, @max_attempts::integer FROM companies WHERE cardinality(@company_names::text[]) = 0 OR name = ANY(@company_names::text[])
+		ON CONFLICT (kind, company_name) WHERE status IN ('pending', 'running') DO NOTHING`
+	args := pgx.NamedArgs{
+		"kind":          kind,
+		"company_names": companyNames,
+		"max_attempts":  appConfig.Worker.MaxAttempts,
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert rows: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// This function leases the next task due to a worker. The tasks whose lease expired are taken over, their worker is considered dead.
+// SKIP LOCKED lets the workers claim tasks at the same time without waiting for each other or taking the same one.
+func claimTask(ctx context.Context, db *pgxpool.Pool, workerID string) (CrawlTask, bool, error) {
+	var task CrawlTask
+	query := `UPDATE crawl_tasks SET status = 'running', leased_by = @worker_id, lease_expires_at = now() + make_interval(secs => @lease_seconds), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM crawl_tasks
+			WHERE (status = 'pending' AND run_after <= now()) OR (status = 'running' AND lease_expires_at < now() AND attempts < max_attempts)
+			ORDER BY run_after, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, company_name, status, attempts, max_attempts`
+	args := pgx.NamedArgs{
+		"worker_id":     workerID,
+		"lease_seconds": appConfig.Worker.LeaseDuration.Seconds(),
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&task.ID, &task.Kind, &task.CompanyName, &task.Status, &task.Attempts, &task.MaxAttempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return task, false, nil
+	}
+	if err != nil {
+		return task, false, fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return task, true, nil
+}
+
+// This function extends the lease of a running task, it returns false when the worker lost it to another one
+func extendTaskLease(ctx context.Context, db *pgxpool.Pool, taskID int64, workerID string) (bool, error) {
+	query := `UPDATE crawl_tasks SET lease_expires_at = now() + make_interval(secs => @lease_seconds)
+		WHERE id = @id AND leased_by = @worker_id AND status = 'running'`
+	args := pgx.NamedArgs{
+		"id":            taskID,
+		"worker_id":     workerID,
+		"lease_seconds": appConfig.Worker.LeaseDuration.Seconds(),
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return false, fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// This function marks a task as done. The offers of a company being looked for once it is enriched, an enrichment task enqueues its offers task.
+func completeTask(ctx context.Context, db *pgxpool.Pool, task CrawlTask, workerID string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE crawl_tasks SET status = 'done', finished_at = now(), leased_by = NULL, lease_expires_at = NULL, last_error = ''
+		WHERE id = @id AND leased_by = @worker_id AND status = 'running'`
+	args := pgx.NamedArgs{
+		"id":        task.ID,
+		"worker_id": workerID,
+	}
+	tag, err := tx.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+
+	if task.Kind == TASK_ENRICH && tag.RowsAffected() == 1 {
+		query = `INSERT INTO crawl_tasks (kind, company_name, max_attempts) VALUES (@kind, @company_name, @max_attempts)
+			ON CONFLICT (kind, company_name) WHERE status IN ('pending', 'running') DO NOTHING`
+		args = pgx.NamedArgs{
+			"kind":         TASK_OFFERS,
+			"company_name": task.CompanyName,
+			"max_attempts": appConfig.Worker.MaxAttempts,
+		}
+		_, err = tx.Exec(ctx, query, args)
+		if err != nil {
+			return fmt.Errorf("unable to insert row: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return err
+}
+
+// This function records the failure of a task. It is retried after worker.retry_delay times its attempts, until it reaches its max attempts.
+// It returns TASK_RETRIED or TASK_FAILED, or TASK_LOST when the worker no longer holds the lease of the task, another worker running it.
+func failTask(ctx context.Context, db *pgxpool.Pool, task CrawlTask, workerID string, taskErr error) (string, error) {
+	query := `UPDATE crawl_tasks SET
+			status = CASE WHEN attempts < max_attempts THEN 'pending' ELSE 'failed' END,
+			run_after = now() + make_interval(secs => @retry_seconds * attempts),
+			finished_at = CASE WHEN attempts < max_attempts THEN NULL ELSE now() END,
+			leased_by = NULL, lease_expires_at = NULL, last_error = @last_error
+		WHERE id = @id AND leased_by = @worker_id AND status = 'running'
+		RETURNING status`
+	args := pgx.NamedArgs{
+		"id":            task.ID,
+		"worker_id":     workerID,
+		"retry_seconds": appConfig.Worker.RetryDelay.Seconds(),
+		"last_error":    taskErr.Error(),
+	}
+	var status string
+	err := db.QueryRow(ctx, query, args).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return TASK_LOST, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to update row: %w", err)
+	}
+
+	if status == TASK_STATUS_PENDING {
+		return TASK_RETRIED, nil
+	}
+	return TASK_FAILED, nil
+}
+
+// This function gives a task back to the queue without counting the attempt, when its worker stops before finishing it
+func releaseTask(ctx context.Context, db *pgxpool.Pool, task CrawlTask, workerID string) error {
+	query := `UPDATE crawl_tasks SET status = 'pending', attempts = greatest(attempts - 1, 0), leased_by = NULL, lease_expires_at = NULL
+		WHERE id = @id AND leased_by = @worker_id AND status = 'running'`
+	args := pgx.NamedArgs{
+		"id":        task.ID,
+		"worker_id": workerID,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return err
+}
+
+// This function marks as failed the tasks whose lease expired on their last attempt, the other ones are taken over by claimTask
+func failExpiredTasks(ctx context.Context, db *pgxpool.Pool) error {
+	query := `UPDATE crawl_tasks SET status = 'failed', finished_at = now(), leased_by = NULL, lease_expires_at = NULL,
+			last_error = 'the lease expired, the worker running the task stopped sending heartbeats'
+		WHERE status = 'running' AND lease_expires_at < now() AND attempts >= max_attempts`
+	_, err := db.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to update rows: %w", err)
+	}
+
+	return err
+}
+
+func getTaskCounts(ctx context.Context, db *pgxpool.Pool) ([]CrawlTaskCount, error) {
+	rows, err := db.Query(ctx, `SELECT kind, status, count(*) FROM crawl_tasks GROUP BY kind, status ORDER BY kind, status`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []CrawlTaskCount{}
+	for rows.Next() {
+		var count CrawlTaskCount
+		err = rows.Scan(&count.Kind, &count.Status, &count.Count)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	return counts, nil
+}
+
+// This function returns the last tasks created, of every status when none is given
+func getTasks(ctx context.Context, db *pgxpool.Pool, status string, limit int) ([]CrawlTask, error) {
+	query := `SELECT id, kind, company_name, status, attempts, max_attempts, run_after, coalesce(leased_by, ''), lease_expires_at, last_error, created_at, finished_at
+		FROM crawl_tasks WHERE @status::text = '' OR status = @status ORDER BY id DESC LIMIT @limit`
+	args := pgx.NamedArgs{
+		"status": status,
+		"limit":  limit,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := []CrawlTask{}
+	for rows.Next() {
+		var task CrawlTask
+		err = rows.Scan(&task.ID, &task.Kind, &task.CompanyName, &task.Status, &task.Attempts, &task.MaxAttempts, &task.RunAfter, &task.LeasedBy, &task.LeaseExpiresAt, &task.LastError, &task.CreatedAt, &task.FinishedAt)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("unable to read rows: %w", rows.Err())
+	}
+
+	return tasks, nil
+}
+
+// This function returns the number of tasks by kind and status, and the last tasks created, filtered by the status query parameter
+func getTasksAPI(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != TASK_STATUS_PENDING && status != TASK_STATUS_RUNNING && status != TASK_STATUS_DONE && status != TASK_STATUS_FAILED {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The status must be pending, running, done or failed"})
+		return
+	}
+
+	counts, err := getTaskCounts(c.Request.Context(), dbpoolapi)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	tasks, err := getTasks(c.Request.Context(), dbpoolapi, status, TASKS_LIST_LIMIT)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"counts": counts, "tasks": tasks}})
+}
+
+// This function enqueues a task of the kind given for the companies given, or for every company when none is given
+func enqueueTasksAPI(c *gin.Context) {
+	var request struct {
+		Kind      string `binding:"required,oneof=enrich offers"`
+		Companies []string
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enqueued, err := enqueueTasks(c.Request.Context(), dbpoolapi, request.Kind, request.Companies)
+	if err != nil {
+		slog.Error("An error happened while enqueuing the tasks", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"enqueued": enqueued}})
+}
@@ -2,28 +2,19 @@ package main
 
 import (
 	"fmt"
-	"net/http"
+	"log/slog"
 	"net/url"
 	"strings"
-)
 
-// This function takes an url as input, checks it is responding, and then returns back a bool according to the result
-func isUrlWorking(url string) bool {
-	client := http.Client{}
-	_, err := client.Get(url)
-	if err != nil {
-		return false
-	} else {
-		return true
-	}
-}
+	"golang.org/x/net/publicsuffix"
+)
 
 // This function takes an url as input that might have trailing slashes at it's end, and return it back without them
 func removeTrailingSlash(urlStr string) string {
 	urlStr = strings.TrimSpace(urlStr)
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		fmt.Println(err)
+		slog.Warn("Unable to parse the url", LOG_URL, urlStr, LOG_ERROR, err)
 		return ""
 	}
 
@@ -34,12 +25,93 @@ func removeTrailingSlash(urlStr string) string {
 	return u.String()
 }
 
-// This function returns the absolute url of the href given as parameter combined with it's website url
-func getAbsoluteUrl(website string, href string) string {
-	attrUrl, _ := url.Parse(href)
-	isAbsURL := attrUrl.IsAbs()
-	if !isAbsURL {
-		href = website + href
+// The schemes of the links that lead to a web page
+var webSchemes = map[string]bool{"http": true, "https": true}
+
+// This function resolves an href found on a page against the page url, as a browser does.
+// It returns false for the links that do not lead to another web page : empty or fragment only hrefs, mailto:, tel:, javascript: and other schemes.
+// The fragment of the returned url is removed.
+func resolveLink(base *url.URL, href string) (string, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return "", false
+	}
+
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+
+	link := base.ResolveReference(ref)
+	if !webSchemes[link.Scheme] || link.Host == "" {
+		return "", false
+	}
+	link.Fragment = ""
+	link.RawFragment = ""
+
+	return link.String(), true
+}
+
+// This function returns the base url of the links of a page : its <base> tag resolved against the page url, or the page url itself
+func pageBaseURL(pageURL string, baseHref string) (*url.URL, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", pageURL, err)
+	}
+
+	if strings.TrimSpace(baseHref) != "" {
+		ref, err := url.Parse(strings.TrimSpace(baseHref))
+		if err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	return base, nil
+}
+
+// The hosts of the applicant tracking systems where the companies publish their offers, their links are followed even if they leave the company website
+var atsDomains = map[string]bool{
+	"welcometothejungle.com": true,
+	"lever.co":               true,
+	"greenhouse.io":          true,
+	"workable.com":           true,
+	"smartrecruiters.com":    true,
+	"teamtailor.com":         true,
+	"recruitee.com":          true,
+	"personio.de":            true,
+	"personio.com":           true,
+	"myworkdayjobs.com":      true,
+	"taleo.net":              true,
+	"successfactors.com":     true,
+	"successfactors.eu":      true,
+	"icims.com":              true,
+	"breezy.hr":              true,
+	"ashbyhq.com":            true,
+	"jobvite.com":            true,
+	"jobteaser.com":          true,
+	"flatchr.io":             true,
+	"talent-soft.com":        true,
+	"digitalrecruiters.com":  true,
+	"careers-page.com":       true,
+}
+
+// This function returns the registrable domain of an url host, "jobs.example.co.uk" becomes "example.co.uk"
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimPrefix(host, "www."))
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// This function checks that a link stays on the website of a page, or leads to a known applicant tracking system
+func isLinkOnSite(pageURL *url.URL, link string) bool {
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return false
 	}
-	return href
+
+	linkDomain := registrableDomain(linkURL.Hostname())
+	return linkDomain == registrableDomain(pageURL.Hostname()) || atsDomains[linkDomain]
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestResolveLink(t *testing.T) {
+	base, _ := url.Parse("https://www.example.fr/carrieres/offres?page=2")
+
+	tests := []struct {
+		name string
+		href string
+		want string
+		ok   bool
+	}{
+		{"absolute", "https://jobs.lever.co/example/123", "https://jobs.lever.co/example/123", true},
+		{"relative", "developpeur-go", "https://www.example.fr/carrieres/developpeur-go", true},
+		{"parent relative", "../equipe", "https://www.example.fr/equipe", true},
+		{"root relative", "/jobs/42", "https://www.example.fr/jobs/42", true},
+		{"protocol relative", "//careers.example.fr/jobs/42", "https://careers.example.fr/jobs/42", true},
+		{"query only", "?page=3", "https://www.example.fr/carrieres/offres?page=3", true},
+		{"fragment removed", "/jobs/42#apply", "https://www.example.fr/jobs/42", true},
+		{"surrounding spaces", "  /jobs/42 ", "https://www.example.fr/jobs/42", true},
+		{"fragment only", "#top", "", false},
+		{"empty", "", "", false},
+		{"javascript", "javascript:void(0)", "", false},
+		{"mailto", "mailto:jobs@example.fr", "", false},
+		{"tel", "tel:+33100000000", "", false},
+		{"other scheme", "ftp://example.fr/offres.pdf", "", false},
+		{"invalid", "http://[::1", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := resolveLink(base, test.href)
+			if got != test.want || ok != test.ok {
+				t.Errorf("resolveLink(%q) = %q, %v, want %q, %v", test.href, got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestPageBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageURL  string
+		baseHref string
+		href     string
+		want     string
+	}{
+		{"no base tag", "https://example.fr/carrieres/", "", "offre-1", "https://example.fr/carrieres/offre-1"},
+		{"absolute base tag", "https://example.fr/carrieres/", "https://cdn.example.fr/jobs/", "offre-1", "https://cdn.example.fr/jobs/offre-1"},
+		{"relative base tag", "https://example.fr/carrieres/liste", "/jobs/", "offre-1", "https://example.fr/jobs/offre-1"},
+		{"blank base tag", "https://example.fr/carrieres/liste", "  ", "offre-1", "https://example.fr/carrieres/offre-1"},
+		{"redirected page", "https://jobs.lever.co/example", "", "/example/123", "https://jobs.lever.co/example/123"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base, err := pageBaseURL(test.pageURL, test.baseHref)
+			if err != nil {
+				t.Fatalf("pageBaseURL(%q, %q) returned an error: %v", test.pageURL, test.baseHref, err)
+			}
+			got, ok := resolveLink(base, test.href)
+			if !ok || got != test.want {
+				t.Errorf("the link %q of %q with the base %q is %q, %v, want %q", test.href, test.pageURL, test.baseHref, got, ok, test.want)
+			}
+		})
+	}
+
+	_, err := pageBaseURL("http://[::1", "")
+	if err == nil {
+		t.Error("pageBaseURL of an invalid url did not return an error")
+	}
+}
+
+func TestIsLinkOnSite(t *testing.T) {
+	tests := []struct {
+		name    string
+		pageURL string
+		link    string
+		want    bool
+	}{
+		{"same host", "https://www.example.fr/carrieres", "https://www.example.fr/jobs/42", true},
+		{"subdomain", "https://www.example.fr/carrieres", "https://careers.example.fr/jobs/42", true},
+		{"without www", "https://www.example.fr/carrieres", "https://example.fr/jobs/42", true},
+		{"multi part suffix", "https://www.example.co.uk/careers", "https://jobs.example.co.uk/42", true},
+		{"other site", "https://www.example.fr/carrieres", "https://www.linkedin.com/company/example", false},
+		{"same suffix other site", "https://www.example.co.uk/careers", "https://www.other.co.uk/jobs", false},
+		{"redirected to another host", "https://jobs.lever.co/example", "https://jobs.lever.co/example/123", true},
+		{"other site from a redirected page", "https://jobs.lever.co/example", "https://www.other.fr/jobs", false},
+		{"invalid link", "https://www.example.fr/carrieres", "http://[::1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, _ := url.Parse(test.pageURL)
+			if got := isLinkOnSite(page, test.link); got != test.want {
+				t.Errorf("isLinkOnSite(%q, %q) = %v, want %v", test.pageURL, test.link, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsLinkOnSiteATS(t *testing.T) {
+	page, _ := url.Parse("https://www.example.fr/carrieres")
+
+	for domain := range atsDomains {
+		t.Run(domain, func(t *testing.T) {
+			link := "https://jobs." + domain + "/example/123"
+			if !isLinkOnSite(page, link) {
+				t.Errorf("the link %s to the applicant tracking system %s is not kept", link, domain)
+			}
+			if registrableDomain("jobs."+domain) != domain {
+				t.Errorf("the registrable domain of jobs.%s is %s", domain, registrableDomain("jobs."+domain))
+			}
+		})
+	}
+}
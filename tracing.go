@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name of the service in the traces, when OTEL_SERVICE_NAME is not set
+const TRACING_SERVICE_NAME = "french-top-jobs"
+
+// The exporters of the traces, chosen with the OTEL_TRACES_EXPORTER environment variable
+const TRACES_EXPORTER_OTLP = "otlp"
+const TRACES_EXPORTER_CONSOLE = "console"
+const TRACES_EXPORTER_NONE = "none"
+
+// The attributes attached to the spans, named like the log attributes
+const SPAN_COMPANY = attribute.Key("company")
+const SPAN_RUN_ID = attribute.Key("run_id")
+const SPAN_URL = attribute.Key("url")
+
+// This variable stores the tracer of the crawler and the API, it uses the provider set by initTracer
+var tracer = otel.Tracer("french-top-jobs")
+
+// This function sets the tracer provider from the OTEL_TRACES_EXPORTER environment variable and returns the function flushing the spans left.
+// Without exporter the spans are not recorded, the OTLP exporter is used by default when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+func initTracer(ctx context.Context, component string) (func(context.Context) error, error) {
+	exporterName := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER")))
+	if exporterName == "" {
+		exporterName = TRACES_EXPORTER_NONE
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+			exporterName = TRACES_EXPORTER_OTLP
+		}
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case TRACES_EXPORTER_NONE:
+		return func(context.Context) error { return nil }, nil
+	case TRACES_EXPORTER_OTLP:
+		// The endpoint, headers, timeout, compression and TLS are read from the OTEL_EXPORTER_OTLP_* environment variables
+		exporter, err = otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the OTLP exporter: %w", err)
+		}
+	case TRACES_EXPORTER_CONSOLE, "stdout":
+		// The spans go to the standard error, the standard output is for the logs
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the console exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", exporterName)
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = TRACING_SERVICE_NAME
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		attribute.String("component", component),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the traces resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("An error happened while exporting the traces", LOG_ERROR, err)
+	}))
+
+	return provider.Shutdown, nil
+}
+
+// This function starts a span, the context returned carries it to the spans and queries started under it
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// This function starts the trace of a company in a crawl. Each company has its own trace, linked to the span of the crawl,
+// so the trace of a slow company is found and read without the hundreds of others.
+func startCompanySpan(ctx context.Context, name string, companyName string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(SPAN_COMPANY.String(companyName)),
+	)
+}
+
+// This function marks a span as failed, it is left open
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// This function ends a span, marking it as failed when the operation returned an error
+func endSpan(span trace.Span, err error) {
+	recordSpanError(span, err)
+	span.End()
+}
+
+// This function adds the trace of a span to the attributes of a logger, so the logs and the traces can be matched
+func withTraceID(logger *slog.Logger, span trace.Span) *slog.Logger {
+	if !span.SpanContext().IsValid() {
+		return logger
+	}
+	return logger.With(LOG_TRACE_ID, span.SpanContext().TraceID().String())
+}
+
+// This middleware traces the requests of the API server, it continues the trace of the caller given in the traceparent header
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRouteKey.String(route),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCodeKey.Int(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if len(c.Errors) > 0 {
+			span.RecordError(c.Errors.Last())
+		}
+	}
+}
+
+// This variable stores the pgx tracer, it records a span for each query run under a span.
+// The queries run outside of a traced operation are not recorded, they would each make a trace of their own.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	ctx, _ = tracer.Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatementKey.String(data.SQL)),
+	)
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	endSpan(span, data.Err)
+}
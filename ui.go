@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The pages of the web interface, each one is rendered inside the layout template
+var uiPages = []string{"companies.html", "company.html", "offers.html", "login.html", "applications.html"}
+
+// The functions available in the web interface templates
+var uiTemplateFuncs = template.FuncMap{
+	"highlight":  highlightSnippet,
+	"formatDate": func(t time.Time) string { return t.Format("02/01/2006") },
+	"filterURL":  filterURL,
+	"dict":       templateDict,
+}
+
+var uiTemplates = parseUITemplates()
+
+// This variable stores a company as displayed in the companies list
+type CompanySummary struct {
+	Company
+	OffersCount int
+	Wishlisted  bool
+}
+
+// This function parses every page of the web interface with the layout
+func parseUITemplates() map[string]*template.Template {
+	templates := make(map[string]*template.Template)
+	for _, page := range uiPages {
+		templates[page] = template.Must(template.New(page).Funcs(uiTemplateFuncs).ParseFS(templatesFS, "templates/ui/layout.html", "templates/ui/"+page))
+	}
+	return templates
+}
+
+// This function renders a page of the web interface, the connected user and the CSRF token of its session are available to every page
+func renderPage(c *gin.Context, status int, page string, data gin.H) {
+	if user, ok := currentUser(c); ok {
+		data["User"] = user
+	}
+	if cookie, err := c.Cookie(SESSION_COOKIE); err == nil {
+		data["CSRFToken"] = csrfToken(cookie)
+	}
+
+	var output bytes.Buffer
+	err := uiTemplates[page].ExecuteTemplate(&output, "layout", data)
+	if err != nil {
+		slog.Error("An error happened while rendering the page", "page", page, LOG_ERROR, err)
+		c.String(http.StatusInternalServerError, "The page could not be rendered")
+		return
+	}
+
+	c.Data(status, "text/html; charset=utf-8", output.Bytes())
+}
+
+// This function builds a map from a list of keys and values, to give several values to a sub template
+func templateDict(values ...any) (map[string]any, error) {
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("dict expects keys and values pairs")
+	}
+	dict := make(map[string]any)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings")
+		}
+		dict[key] = values[i+1]
+	}
+	return dict, nil
+}
+
+// This function escapes a search snippet while keeping the <mark> tags surrounding the matching words
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.NewReplacer("&lt;mark&gt;", "<mark>", "&lt;/mark&gt;", "</mark>").Replace(escaped)
+	return template.HTML(escaped)
+}
+
+// This function returns the offers page url with the current filters and a filter changed, an empty value removes the filter
+func filterURL(current url.Values, key string, value string) string {
+	query := url.Values{}
+	for k, v := range current {
+		query[k] = v
+	}
+	query.Del("offset")
+	if value == "" {
+		query.Del(key)
+	} else {
+		query.Set(key, value)
+	}
+	return "/board/offers?" + query.Encode()
+}
+
+// This function returns the companies with their number of offers, filtered by name, list and wishlist of a user
+func getCompanySummaries(ctx context.Context, db *pgxpool.Pool, userID int, nameQuery string, listSlug string, wishlistOnly bool) ([]CompanySummary, error) {
+	var summaries []CompanySummary
+
+	query := `SELECT ` + COMPANY_COLUMNS + `,
+		(SELECT count(*) FROM offers o WHERE o.company_name = c.name),
+		EXISTS (SELECT 1 FROM wishlist w WHERE w.company_name = c.name AND w.user_id = @user_id)
+		FROM companies c
+		WHERE (@name_query = '' OR unaccent(c.name) ILIKE '%' || unaccent(@name_query) || '%')
+		AND (@list = '' OR EXISTS (SELECT 1 FROM company_list_memberships m WHERE m.company_name = c.name AND m.list_slug = @list
+			AND m.edition_year = (SELECT max(edition_year) FROM company_list_memberships WHERE list_slug = @list)))
+		AND (NOT @wishlist_only OR EXISTS (SELECT 1 FROM wishlist w WHERE w.company_name = c.name AND w.user_id = @user_id))
+		ORDER BY c.name`
+	args := pgx.NamedArgs{
+		"user_id":       userID,
+		"name_query":    nameQuery,
+		"list":          listSlug,
+		"wishlist_only": wishlistOnly,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return summaries, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var summary CompanySummary
+		err = rows.Scan(&summary.Name, &summary.IsTop500, &summary.Website, &summary.LinkedInURL, &summary.WTTJURL, &summary.JobsPageURL, &summary.IsContractor, &summary.Slug,
+			&summary.OffersCount, &summary.Wishlisted)
+		if err != nil {
+			return summaries, fmt.Errorf("unable to scan row: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
+
+// This function returns the lists editions a company is a member of
+func getCompanyMemberships(ctx context.Context, db *pgxpool.Pool, companyName string) ([]CompanyListMembership, error) {
+	var memberships []CompanyListMembership
+
+	query := `SELECT list_slug, edition_year, company_name, rank FROM company_list_memberships WHERE company_name = @company_name ORDER BY list_slug, edition_year DESC`
+	args := pgx.NamedArgs{
+		"company_name": companyName,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return memberships, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var membership CompanyListMembership
+		err = rows.Scan(&membership.ListSlug, &membership.Year, &membership.CompanyName, &membership.Rank)
+		if err != nil {
+			return memberships, fmt.Errorf("unable to scan row: %w", err)
+		}
+		memberships = append(memberships, membership)
+	}
+
+	return memberships, rows.Err()
+}
+
+func companiesPage(c *gin.Context) {
+	wishlistOnly := c.Query("wishlist") == "true"
+
+	companies, err := getCompanySummaries(c.Request.Context(), dbpoolapi, currentUserID(c), c.Query("q"), c.Query("list"), wishlistOnly)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		renderPage(c, http.StatusInternalServerError, "companies.html", gin.H{"Error": "The companies could not be loaded"})
+		return
+	}
+
+	renderPage(c, http.StatusOK, "companies.html", gin.H{
+		"Title":        "Companies",
+		"Companies":    companies,
+		"Lists":        getCompanyLists(c.Request.Context(), dbpoolapi),
+		"Query":        c.Query("q"),
+		"List":         c.Query("list"),
+		"WishlistOnly": wishlistOnly,
+		"Path":         c.Request.URL.RequestURI(),
+	})
+}
+
+func companyPage(c *gin.Context) {
+	company, exists, err := getCompanyBySlug(c.Request.Context(), dbpoolapi, c.Param("slug"))
+	if err != nil {
+		renderPage(c, http.StatusInternalServerError, "company.html", gin.H{"Error": "The company could not be loaded"})
+		return
+	}
+	if !exists {
+		renderPage(c, http.StatusNotFound, "company.html", gin.H{"Error": "This company does not exist"})
+		return
+	}
+
+	offers, err := searchOffers(c.Request.Context(), dbpoolapi, OfferSearchParams{CompanyName: company.Name, Sort: "date", Limit: SEARCH_MAX_LIMIT})
+	if err != nil {
+		slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+	}
+	memberships, err := getCompanyMemberships(c.Request.Context(), dbpoolapi, company.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+	}
+	wishlisted, err := isCompanyInWishlist(c.Request.Context(), dbpoolapi, currentUserID(c), company.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+	}
+
+	renderPage(c, http.StatusOK, "company.html", gin.H{
+		"Title":       company.Name,
+		"Company":     company,
+		"Offers":      offers,
+		"Memberships": memberships,
+		"Wishlisted":  wishlisted,
+		"Path":        c.Request.URL.RequestURI(),
+	})
+}
+
+func offersPage(c *gin.Context) {
+	params, err := parseOfferSearchParams(c)
+	if err != nil {
+		renderPage(c, http.StatusBadRequest, "offers.html", gin.H{"Error": err.Error()})
+		return
+	}
+
+	response, err := searchOffers(c.Request.Context(), dbpoolapi, params)
+	if err != nil {
+		slog.Error("An error happened while searching the offers", LOG_ERROR, err)
+		renderPage(c, http.StatusInternalServerError, "offers.html", gin.H{"Error": "The search failed"})
+		return
+	}
+
+	query := c.Request.URL.Query()
+	data := gin.H{
+		"Title":    "Offers",
+		"Params":   params,
+		"Response": response,
+		"Query":    query,
+		"FeedURL":  "/feeds/search?" + query.Encode(),
+		"From":     params.Offset + 1,
+		"To":       params.Offset + len(response.Results),
+	}
+	if params.Offset > 0 {
+		data["PreviousURL"] = filterURL(query, "offset", strconv.Itoa(max(params.Offset-params.Limit, 0)))
+	}
+	if params.Offset+params.Limit < response.Total {
+		data["NextURL"] = filterURL(query, "offset", strconv.Itoa(params.Offset+params.Limit))
+	}
+
+	renderPage(c, http.StatusOK, "offers.html", data)
+}
+
+// This function adds or removes a company from the wishlist, then goes back to the page the form was sent from
+func toggleWishlistPage(c *gin.Context) {
+	company, exists, err := getCompanyBySlug(c.Request.Context(), dbpoolapi, c.Param("slug"))
+	if err != nil || !exists {
+		c.String(http.StatusNotFound, "This company does not exist")
+		return
+	}
+
+	_, err = toggleCompanyInWishlist(c.Request.Context(), dbpoolapi, currentUserID(c), company.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.String(http.StatusInternalServerError, "The wishlist could not be updated")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, safeRedirect(c.PostForm("redirect"), "/board/companies/"+company.Slug))
+}
+
+func applicationsPage(c *gin.Context) {
+	applications, err := getApplications(c.Request.Context(), dbpoolapi, currentUserID(c), c.Query("status"))
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		renderPage(c, http.StatusInternalServerError, "applications.html", gin.H{"Error": "The applications could not be loaded"})
+		return
+	}
+
+	renderPage(c, http.StatusOK, "applications.html", gin.H{
+		"Title":        "Applications",
+		"Applications": applications,
+		"Statuses":     applicationStatuses,
+		"Status":       c.Query("status"),
+	})
+}
+
+// This function starts tracking the application to an offer, an offer that is already tracked is left as it is
+func trackOfferPage(c *gin.Context) {
+	offerID, err := strconv.Atoi(c.PostForm("offer_id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid offer")
+		return
+	}
+
+	_, err = createApplication(c.Request.Context(), dbpoolapi, Application{UserID: currentUserID(c), OfferID: &offerID})
+	switch {
+	case err == errOfferNotFound:
+		c.String(http.StatusNotFound, "This offer does not exist")
+		return
+	case err != nil && err != errApplicationAlreadyExists:
+		slog.Error("An error happened while creating the application", LOG_ERROR, err)
+		c.String(http.StatusInternalServerError, "The application could not be tracked")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/board/applications")
+}
+
+// This function saves the status, notes and follow up date of an application from its form
+func updateApplicationPage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	application, exists, err := getApplication(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.String(http.StatusInternalServerError, "The application could not be updated")
+		return
+	}
+	if !exists {
+		c.String(http.StatusNotFound, "This application does not exist")
+		return
+	}
+
+	application.Status = c.PostForm("status")
+	application.Notes = c.PostForm("notes")
+	application.FollowUpAt = nil
+	if c.PostForm("follow_up_at") != "" {
+		followUpAt, err := time.ParseInLocation("2006-01-02", c.PostForm("follow_up_at"), time.Local)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid follow up date")
+			return
+		}
+		application.FollowUpAt = &followUpAt
+	}
+	if !slices.Contains(applicationStatuses, application.Status) {
+		c.String(http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	_, _, err = updateApplication(c.Request.Context(), dbpoolapi, application)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.String(http.StatusInternalServerError, "The application could not be updated")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/board/applications")
+}
+
+func deleteApplicationPage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	_, err = deleteApplication(c.Request.Context(), dbpoolapi, currentUserID(c), id)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.String(http.StatusInternalServerError, "The application could not be deleted")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/board/applications")
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The roles a user can have, admins can manage the users and the companies, readers can only use the board
+const ROLE_ADMIN = "admin"
+const ROLE_READER = "reader"
+
+// How long a web interface session lasts
+const SESSION_DURATION = 30 * 24 * time.Hour
+
+// The prefix of the personal API tokens, it makes them easy to recognise in scripts and secrets scanners
+const API_TOKEN_PREFIX = "ftj_"
+
+var errEmailAlreadyUsed = errors.New("this email is already used")
+
+// A password hash only used to spend the same time checking an unknown email as a known one
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// This variable stores a user of the board
+type User struct {
+	ID        int
+	Email     string
+	Role      string
+	CreatedAt time.Time
+}
+
+// This variable stores a personal API token, the token itself is only known when it is created
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func (u User) isAdmin() bool {
+	return u.Role == ROLE_ADMIN
+}
+
+// This function returns a random token and the hash under which it is stored in the database
+func generateToken(prefix string) (string, string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to generate a token: %w", err)
+	}
+	token := prefix + base64.RawURLEncoding.EncodeToString(bytes)
+	return token, hashToken(token), nil
+}
+
+// Tokens are random and long so a sha256 is enough to store them, unlike passwords
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func createUser(ctx context.Context, db *pgxpool.Pool, email string, password string, role string) (User, error) {
+	var user User
+
+	if role != ROLE_ADMIN && role != ROLE_READER {
+		return user, fmt.Errorf("the role must be %s or %s", ROLE_ADMIN, ROLE_READER)
+	}
+	if len(password) < 8 {
+		return user, fmt.Errorf("the password must be at least 8 characters long")
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return user, fmt.Errorf("unable to hash the password: %w", err)
+	}
+
+	query := `INSERT INTO users (email, password_hash, role) VALUES (lower(@email), @password_hash, @role) RETURNING id, email, role, created_at`
+	args := pgx.NamedArgs{
+		"email":         email,
+		"password_hash": string(passwordHash),
+		"role":          role,
+	}
+	err = db.QueryRow(ctx, query, args).Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return user, errEmailAlreadyUsed
+		}
+		return user, fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return user, err
+}
+
+// This function returns the user matching an email and a password, or false if they do not match
+func authenticateUser(ctx context.Context, db *pgxpool.Pool, email string, password string) (User, bool, error) {
+	var user User
+	var passwordHash string
+
+	query := `SELECT id, email, role, created_at, password_hash FROM users WHERE email = lower(@email)`
+	args := pgx.NamedArgs{
+		"email": email,
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt, &passwordHash)
+	switch {
+	case err == pgx.ErrNoRows:
+		// Compare anyway so an unknown email takes as long as a wrong password
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return user, false, nil
+	case err != nil:
+		return user, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return user, false, nil
+	}
+
+	return user, true, nil
+}
+
+func getUsers(ctx context.Context, db *pgxpool.Pool) ([]User, error) {
+	var users []User
+
+	rows, err := db.Query(ctx, `SELECT id, email, role, created_at FROM users ORDER BY id`)
+	if err != nil {
+		return users, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt)
+		if err != nil {
+			return users, fmt.Errorf("unable to scan row: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func deleteUser(ctx context.Context, db *pgxpool.Pool, id int) (bool, error) {
+	query := `DELETE FROM users WHERE id = @id`
+	args := pgx.NamedArgs{
+		"id": id,
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, err
+}
+
+// This function opens a web interface session for a user and returns the token to put in its cookie
+func createSession(ctx context.Context, db *pgxpool.Pool, userID int) (string, error) {
+	token, tokenHash, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	query := `INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (@token_hash, @user_id, @expires_at)`
+	args := pgx.NamedArgs{
+		"token_hash": tokenHash,
+		"user_id":    userID,
+		"expires_at": time.Now().Add(SESSION_DURATION),
+	}
+	_, err = db.Exec(ctx, query, args)
+	if err != nil {
+		return "", fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return token, nil
+}
+
+// This function returns the user of a session token, or false if the session does not exist or has expired
+func getSessionUser(ctx context.Context, db *pgxpool.Pool, token string) (User, bool, error) {
+	var user User
+
+	query := `SELECT u.id, u.email, u.role, u.created_at FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = @token_hash AND s.expires_at > now()`
+	args := pgx.NamedArgs{
+		"token_hash": hashToken(token),
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt)
+	switch {
+	case err == pgx.ErrNoRows:
+		return user, false, nil
+	case err != nil:
+		return user, false, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	return user, true, nil
+}
+
+func deleteSession(ctx context.Context, db *pgxpool.Pool, token string) error {
+	query := `DELETE FROM sessions WHERE token_hash = @token_hash OR expires_at <= now()`
+	args := pgx.NamedArgs{
+		"token_hash": hashToken(token),
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	return err
+}
+
+// This function creates a personal API token and returns it, it is the only time the token is readable
+func createAPIToken(ctx context.Context, db *pgxpool.Pool, userID int, name string) (APIToken, string, error) {
+	var apiToken APIToken
+
+	token, tokenHash, err := generateToken(API_TOKEN_PREFIX)
+	if err != nil {
+		return apiToken, "", err
+	}
+
+	query := `INSERT INTO api_tokens (user_id, name, token_hash) VALUES (@user_id, @name, @token_hash) RETURNING id, user_id, name, created_at, last_used_at`
+	args := pgx.NamedArgs{
+		"user_id":    userID,
+		"name":       name,
+		"token_hash": tokenHash,
+	}
+	err = db.QueryRow(ctx, query, args).Scan(&apiToken.ID, &apiToken.UserID, &apiToken.Name, &apiToken.CreatedAt, &apiToken.LastUsedAt)
+	if err != nil {
+		return apiToken, "", fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return apiToken, token, nil
+}
+
+// This function returns the user of an API token and records that the token has been used, or false if the token does not exist
+func getAPITokenUser(ctx context.Context, db *pgxpool.Pool, token string) (User, bool, error) {
+	var user User
+
+	query := `UPDATE api_tokens t SET last_used_at = now() FROM users u
+		WHERE u.id = t.user_id AND t.token_hash = @token_hash
+		RETURNING u.id, u.email, u.role, u.created_at`
+	args := pgx.NamedArgs{
+		"token_hash": hashToken(token),
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt)
+	switch {
+	case err == pgx.ErrNoRows:
+		return user, false, nil
+	case err != nil:
+		return user, false, fmt.Errorf("unable to update row: %w", err)
+	}
+
+	return user, true, nil
+}
+
+func getAPITokens(ctx context.Context, db *pgxpool.Pool, userID int) ([]APIToken, error) {
+	var tokens []APIToken
+
+	query := `SELECT id, user_id, name, created_at, last_used_at FROM api_tokens WHERE user_id = @user_id ORDER BY id`
+	args := pgx.NamedArgs{
+		"user_id": userID,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return tokens, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token APIToken
+		err = rows.Scan(&token.ID, &token.UserID, &token.Name, &token.CreatedAt, &token.LastUsedAt)
+		if err != nil {
+			return tokens, fmt.Errorf("unable to scan row: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func deleteAPIToken(ctx context.Context, db *pgxpool.Pool, userID int, id int) (bool, error) {
+	query := `DELETE FROM api_tokens WHERE id = @id AND user_id = @user_id`
+	args := pgx.NamedArgs{
+		"id":      id,
+		"user_id": userID,
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func addCompanyToWishlist(ctx context.Context, db *pgxpool.Pool, userID int, companyName string) error {
+	query := `INSERT INTO wishlist (user_id, company_name) VALUES (@user_id, @company_name) ON CONFLICT DO NOTHING`
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"company_name": companyName,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to insert row: %w", err)
+	}
+
+	return err
+}
+
+func removeCompanyFromWishlist(ctx context.Context, db *pgxpool.Pool, userID int, companyName string) error {
+	query := `DELETE FROM wishlist WHERE user_id = @user_id AND company_name = @company_name`
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"company_name": companyName,
+	}
+	_, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to delete row: %w", err)
+	}
+
+	return err
+}
+
+func isCompanyInWishlist(ctx context.Context, db *pgxpool.Pool, userID int, companyName string) (bool, error) {
+	var exists bool
+
+	query := `SELECT EXISTS (SELECT 1 FROM wishlist WHERE user_id = @user_id AND company_name = @company_name)`
+	args := pgx.NamedArgs{
+		"user_id":      userID,
+		"company_name": companyName,
+	}
+	err := db.QueryRow(ctx, query, args).Scan(&exists)
+	if err != nil {
+		return exists, fmt.Errorf("unable to query row: %w", err)
+	}
+
+	return exists, err
+}
+
+// This function adds a company to a user wishlist if it is not in it, and removes it otherwise. It returns whether the company is now in the wishlist
+func toggleCompanyInWishlist(ctx context.Context, db *pgxpool.Pool, userID int, companyName string) (bool, error) {
+	exists, err := isCompanyInWishlist(ctx, db, userID, companyName)
+	if err != nil {
+		return exists, err
+	}
+
+	if exists {
+		return false, removeCompanyFromWishlist(ctx, db, userID, companyName)
+	}
+	return true, addCompanyToWishlist(ctx, db, userID, companyName)
+}
+
+func getWishlistCompanies(ctx context.Context, db *pgxpool.Pool, userID int) ([]Company, error) {
+	var companies []Company
+
+	query := `SELECT ` + COMPANY_COLUMNS + ` FROM companies JOIN wishlist w ON w.company_name = companies.name WHERE w.user_id = @user_id ORDER BY name`
+	args := pgx.NamedArgs{
+		"user_id": userID,
+	}
+	rows, err := db.Query(ctx, query, args)
+	if err != nil {
+		return companies, fmt.Errorf("unable to query rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		company, err := scanCompany(rows)
+		if err != nil {
+			return companies, fmt.Errorf("unable to scan row: %w", err)
+		}
+		companies = append(companies, company)
+	}
+
+	return companies, rows.Err()
+}
+
+func getWishlistAPI(c *gin.Context) {
+	companies, err := getWishlistCompanies(c.Request.Context(), dbpoolapi, currentUserID(c))
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": companies})
+}
+
+// This function returns the company of the slug given in the request path, and answers with an error if it does not exist
+func getCompanyFromPath(c *gin.Context) (Company, bool) {
+	company, exists, err := getCompanyBySlug(c.Request.Context(), dbpoolapi, c.Param("slug"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return company, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return company, false
+	}
+
+	return company, true
+}
+
+func addCompanyToWishlistAPI(c *gin.Context) {
+	company, ok := getCompanyFromPath(c)
+	if !ok {
+		return
+	}
+
+	err := addCompanyToWishlist(c.Request.Context(), dbpoolapi, currentUserID(c), company.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func removeCompanyFromWishlistAPI(c *gin.Context) {
+	company, ok := getCompanyFromPath(c)
+	if !ok {
+		return
+	}
+
+	err := removeCompanyFromWishlist(c.Request.Context(), dbpoolapi, currentUserID(c), company.Name)
+	if err != nil {
+		slog.Error("An error happened with the query", LOG_ERROR, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// This function returns the identifier of a worker, it holds the leases of the tasks the worker runs
+func newWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	return hostname + "-" + newRunID()
+}
+
+// This function runs a worker, taking the tasks of the queue worker.concurrency at a time. Several workers can run on several machines.
+// When the context given is canceled the worker stops taking tasks, the ones in progress are given crawl.shutdown_timeout to finish
+// and are given back to the queue if they do not.
+func runWorker(stopping context.Context) error {
+	workerID := newWorkerID()
+	logger := slog.Default().With(LOG_WORKER_ID, workerID)
+	slog.SetDefault(logger)
+	logger.Info("The worker is starting", "concurrency", appConfig.Worker.Concurrency)
+	serveCrawlMetrics(logger)
+
+	ctx, cancel := drainContext(stopping, appConfig.Crawl.ShutdownTimeout, logger)
+	defer cancel()
+
+	shutdownTracer, err := initTracer(ctx, "worker")
+	if err != nil {
+		return fmt.Errorf("unable to initialise the tracing: %w", err)
+	}
+	flushCtx := context.WithoutCancel(ctx)
+	defer func() {
+		err := shutdownTracer(flushCtx)
+		if err != nil {
+			logger.Error("Unable to flush the traces", LOG_ERROR, err)
+		}
+	}()
+
+	dbpool, err := initDbConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbpool.Close()
+
+	// The tabs of the tasks are opened in one browser, it is closed when the worker returns
+	browserCtx, cancelBrowser, err := createBrowser(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancelBrowser()
+	ctx = withLogger(browserCtx, logger)
+
+	// The stages the companies go through are recorded as for a crawl, so a resumed crawl skips them
+	progress := newCrawlProgress(dbpool, workerID)
+
+	// The worker runs for days, the expired artifacts are deleted while it runs
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cleanExpiredArtifactsPeriodically(stopping, dbpool)
+	}()
+
+	for i := 0; i < appConfig.Worker.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumeTasks(stopping, ctx, dbpool, workerID, progress)
+		}()
+	}
+	wg.Wait()
+
+	logger.Info("The worker stopped")
+	return nil
+}
+
+// This function claims and runs tasks until the worker is stopping, it waits worker.poll_interval when the queue is empty
+func consumeTasks(stopping context.Context, ctx context.Context, db *pgxpool.Pool, workerID string, progress *crawlProgress) {
+	logger := loggerFromContext(ctx)
+
+	for stopping.Err() == nil {
+		task, found, err := claimTask(ctx, db, workerID)
+		if err != nil {
+			logger.Error("Unable to claim a task", LOG_ERROR, err)
+		}
+		if found {
+			runTask(ctx, db, workerID, task, progress)
+			continue
+		}
+
+		// The queue being empty, it is a good time to mark the tasks abandoned on their last attempt
+		if err == nil {
+			err = failExpiredTasks(ctx, db)
+			if err != nil {
+				logger.Error("Unable to mark the expired tasks as failed", LOG_ERROR, err)
+			}
+		}
+
+		select {
+		case <-stopping.Done():
+		case <-time.After(appConfig.Worker.PollInterval):
+		}
+	}
+}
+
+// This function runs a task and records its outcome. Its lease is extended every worker.heartbeat_interval while it runs,
+// and it is cancelled if another worker took it over.
+func runTask(ctx context.Context, db *pgxpool.Pool, workerID string, task CrawlTask, progress *crawlProgress) {
+	logger := loggerFromContext(ctx).With(LOG_TASK_ID, task.ID, LOG_COMPANY, task.CompanyName, "kind", task.Kind, "attempt", task.Attempts)
+	logger.Info("Running the task")
+
+	taskCtx, cancelTask := context.WithCancel(withLogger(ctx, logger))
+	defer cancelTask()
+	// The pages the task fails to read are captured as artifacts of the worker, up to artifacts.max_per_run for the task
+	taskCtx = withArtifacts(taskCtx, db, workerID)
+
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(appConfig.Worker.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-taskCtx.Done():
+				return
+			case <-ticker.C:
+				kept, err := extendTaskLease(taskCtx, db, task.ID, workerID)
+				if err != nil {
+					logger.Warn("Unable to extend the lease of the task", LOG_ERROR, err)
+					continue
+				}
+				if !kept {
+					logger.Warn("The lease of the task was lost, cancelling it")
+					cancelTask()
+					return
+				}
+			}
+		}
+	}()
+
+	// A panic fails the task like an error, the worker going on with the next ones
+	err := func() (err error) {
+		defer recoverPanic(&err)
+		return executeTask(taskCtx, db, task, progress)
+	}()
+	cancelTask()
+	<-heartbeatDone
+
+	// The outcome is recorded even when the worker is being cancelled
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), CRAWL_STAGE_RECORD_TIMEOUT)
+	defer cancel()
+
+	switch {
+	case err == nil:
+		err = completeTask(recordCtx, db, task, workerID)
+		if err != nil {
+			logger.Error("Unable to mark the task as done", LOG_ERROR, err)
+			return
+		}
+		tasksTotal.WithLabelValues(task.Kind, TASK_DONE).Inc()
+		logger.Info("The task is done")
+	case ctx.Err() != nil:
+		err = releaseTask(recordCtx, db, task, workerID)
+		if err != nil {
+			logger.Error("Unable to give the task back to the queue", LOG_ERROR, err)
+			return
+		}
+		tasksTotal.WithLabelValues(task.Kind, TASK_RELEASED).Inc()
+		logger.Warn("The worker stopped before the end of the task, it has been given back to the queue")
+	default:
+		outcome, failErr := failTask(recordCtx, db, task, workerID, err)
+		if failErr != nil {
+			logger.Error("Unable to record the failure of the task", LOG_ERROR, failErr)
+			return
+		}
+		tasksTotal.WithLabelValues(task.Kind, outcome).Inc()
+		switch outcome {
+		case TASK_LOST:
+			logger.Warn("The task was stopped as its lease was lost, another worker runs it", LOG_ERROR, err)
+		case TASK_RETRIED:
+			logger.Warn("The task failed, it will be retried", LOG_ERROR, err)
+		default:
+			logger.Error("The task failed on its last attempt", LOG_ERROR, err)
+		}
+	}
+}
+
+// This function runs the work of a task on its company
+func executeTask(ctx context.Context, db *pgxpool.Pool, task CrawlTask, progress *crawlProgress) error {
+	company, found, err := getCompany(ctx, db, task.CompanyName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("the company %s does not exist", task.CompanyName)
+	}
+
+	switch task.Kind {
+	case TASK_ENRICH:
+		err = enrichCompany(ctx, db, company, progress)
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	case TASK_OFFERS:
+		return crawlCompanyOffers(ctx, db, company, progress)
+	}
+
+	return fmt.Errorf("unknown task kind %q", task.Kind)
+}
+
+// This function is the enqueue command : enqueue <enrich|offers> [company...], every company is enqueued when none is given
+func enqueueCommand(ctx context.Context, args []string) error {
+	if len(args) < 1 || !isTaskKind(args[0]) {
+		return fmt.Errorf("usage: french-top-jobs enqueue [flags] <enrich|offers> [company...]")
+	}
+
+	db, err := initDbConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	enqueued, err := enqueueTasks(ctx, db, args[0], args[1:])
+	if err != nil {
+		return fmt.Errorf("unable to enqueue the tasks: %w", err)
+	}
+
+	slog.Info("The tasks have been enqueued", "kind", args[0], "enqueued", enqueued)
+	return nil
+}